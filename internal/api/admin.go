@@ -0,0 +1,273 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/auth"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+// lookupUser returns the login of the user the admin request is about, or
+// answers with 404 when there is no such user.
+func (h *AdminRouter) lookupUser(rw http.ResponseWriter, r *http.Request) (string, bool) {
+	login := chi.URLParam(r, "login")
+	_, err := h.Cursor.GetUserInfo(r.Context(), &models.UserInfo{Username: login}, h.Logger)
+	if err == errors.ErrNotFound {
+		http.Error(rw, "user not found", http.StatusNotFound)
+		return "", false
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	return login, true
+}
+
+func (h *AdminRouter) GetUserOrders(rw http.ResponseWriter, r *http.Request) {
+	if login, ok := h.lookupUser(rw, r); ok {
+		writeOrders(rw, r, h.Cursor, login, h.Logger)
+	}
+}
+
+func (h *AdminRouter) GetUserBalance(rw http.ResponseWriter, r *http.Request) {
+	if login, ok := h.lookupUser(rw, r); ok {
+		writeBalance(rw, r, h.Cursor, login, h.Logger)
+	}
+}
+
+func (h *AdminRouter) GetUserWithdrawals(rw http.ResponseWriter, r *http.Request) {
+	if login, ok := h.lookupUser(rw, r); ok {
+		writeWithdrawals(rw, r, h.Cursor, login, h.Logger)
+	}
+}
+
+func (h *AdminRouter) GetUserStatement(rw http.ResponseWriter, r *http.Request) {
+	if login, ok := h.lookupUser(rw, r); ok {
+		writeStatement(rw, r, h.Cursor, login, h.Logger)
+	}
+}
+
+// AdjustBalance credits or debits the user's balance by hand with a reason
+// code and a reason kept in the ledger. Adjustments above the approval
+// threshold are only recorded and answered with 202, they are applied once
+// another admin approves them.
+func (h *AdminRouter) AdjustBalance(rw http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	input := &models.AdjustmentPost{}
+	if err := json.NewDecoder(r.Body).Decode(input); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ValidateAdjustment(input); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	login, ok := h.lookupUser(rw, r)
+	if !ok {
+		return
+	}
+	adjustment := &models.Adjustment{
+		User:        login,
+		Amount:      input.Amount,
+		ReasonCode:  input.ReasonCode,
+		Reason:      input.Reason,
+		Status:      configuration.ADJUSTMENTAPPLIED,
+		RequestedBy: principal.Username,
+		CreatedAt:   time.Now(),
+	}
+	status := http.StatusOK
+	if input.Amount > h.ApprovalThreshold || -input.Amount > h.ApprovalThreshold {
+		adjustment.Status = configuration.ADJUSTMENTPENDING
+		status = http.StatusAccepted
+	}
+	err := h.Cursor.SaveAdjustment(r.Context(), adjustment, h.Logger)
+	if err == errors.ErrInsufficientFunds {
+		http.Error(rw, "not enough money", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.Logger.Info("Balance adjustment requested",
+		zap.Int64("id", adjustment.ID), zap.String("admin", principal.Username), zap.String("user", login),
+		zap.String("status", adjustment.Status))
+	writeJSON(rw, status, adjustment)
+}
+
+// GetAdjustments lists the adjustments, ?status=PENDING gives the ones
+// waiting for approval.
+func (h *AdminRouter) GetAdjustments(rw http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", configuration.ADJUSTMENTPENDING, configuration.ADJUSTMENTAPPLIED, configuration.ADJUSTMENTREJECTED:
+	default:
+		http.Error(rw, "unknown status", http.StatusBadRequest)
+		return
+	}
+	adjustments, err := h.Cursor.GetAdjustments(r.Context(), status, h.Logger)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(adjustments) == 0 {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(rw, http.StatusOK, adjustments)
+}
+
+func (h *AdminRouter) ApproveAdjustment(rw http.ResponseWriter, r *http.Request) {
+	h.reviewAdjustment(rw, r, configuration.ADJUSTMENTAPPLIED)
+}
+
+func (h *AdminRouter) RejectAdjustment(rw http.ResponseWriter, r *http.Request) {
+	h.reviewAdjustment(rw, r, configuration.ADJUSTMENTREJECTED)
+}
+
+func (h *AdminRouter) reviewAdjustment(rw http.ResponseWriter, r *http.Request, status string) {
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(rw, "invalid adjustment id", http.StatusBadRequest)
+		return
+	}
+	review := &models.Adjustment{ID: id, Status: status, ReviewedBy: principal.Username}
+	err = h.Cursor.ReviewAdjustment(r.Context(), review, h.Logger)
+	switch err {
+	case nil:
+	case errors.ErrNotFound:
+		http.Error(rw, "adjustment not found", http.StatusNotFound)
+		return
+	case errors.ErrSelfApproval:
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return
+	case errors.ErrNotPending:
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	case errors.ErrInsufficientFunds:
+		http.Error(rw, "not enough money", http.StatusConflict)
+		return
+	default:
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.Logger.Info("Balance adjustment reviewed",
+		zap.Int64("id", review.ID), zap.String("admin", principal.Username), zap.String("status", review.Status))
+	writeJSON(rw, http.StatusOK, review)
+}
+
+// UnlockUser lifts the lockout of the account after failed logins.
+func (h *AdminRouter) UnlockUser(rw http.ResponseWriter, r *http.Request) {
+	if login, ok := h.lookupUser(rw, r); ok {
+		h.unlockLogin(rw, r, configuration.LOGINSCOPEACCOUNT, login)
+	}
+}
+
+// UnlockIP lifts the lockout of the address after failed logins.
+func (h *AdminRouter) UnlockIP(rw http.ResponseWriter, r *http.Request) {
+	h.unlockLogin(rw, r, configuration.LOGINSCOPEIP, chi.URLParam(r, "ip"))
+}
+
+func (h *AdminRouter) unlockLogin(rw http.ResponseWriter, r *http.Request, scope string, key string) {
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	attempts, err := h.Cursor.GetLoginAttempts(r.Context(), scope, key, h.Logger)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.Cursor.ResetLoginAttempts(r.Context(), scope, key, h.Logger); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.Cursor, principal.Username, configuration.AUDITLOGINUNLOCKED, key, attempts, nil, h.Logger)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// GetDeadJobs lists the accrual jobs that gave up after exhausting their
+// retries.
+func (h *AdminRouter) GetDeadJobs(rw http.ResponseWriter, r *http.Request) {
+	jobs, err := h.Manager.DeadJobs(r.Context(), h.Logger)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(jobs) == 0 {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(rw, http.StatusOK, jobs)
+}
+
+// RedriveJob puts a dead accrual job back in the queue with a fresh retry
+// budget.
+func (h *AdminRouter) RedriveJob(rw http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	number := chi.URLParam(r, "number")
+	err := h.Manager.RedriveJob(r.Context(), number, h.Logger)
+	if err == errors.ErrNotFound {
+		http.Error(rw, "dead job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.Cursor, principal.Username, configuration.AUDITJOBREDRIVEN, number, nil, nil, h.Logger)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// SetUserRole changes the role of another user.
+func (h *AdminRouter) SetUserRole(rw http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	input := &models.RolePost{}
+	if err := json.NewDecoder(r.Body).Decode(input); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !auth.ValidRole(input.Role) {
+		http.Error(rw, "unknown role", http.StatusBadRequest)
+		return
+	}
+	login := chi.URLParam(r, "login")
+	// keeps the last admin from locking everyone out
+	if login == principal.Username {
+		http.Error(rw, "cannot change own role", http.StatusForbidden)
+		return
+	}
+	err := h.Cursor.SetUserRole(r.Context(), login, input.Role, h.Logger)
+	if err == errors.ErrNotFound {
+		http.Error(rw, "user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.Logger.Info("User role changed",
+		zap.String("admin", principal.Username), zap.String("user", login), zap.String("role", input.Role))
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,295 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/jobmanager"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/mocks"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+func TestAdmin(t *testing.T) {
+	l := zap.NewNop()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx := context.Background()
+	manager := jobmanager.NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l)
+	handler := NewHandler(cursor, manager, nil, nil, l)
+
+	for _, user := range []struct{ name, role string }{
+		{"test", configuration.ROLEUSER},
+		{"support", configuration.ROLESUPPORT},
+		{"admin", configuration.ROLEADMIN},
+	} {
+		require.NoError(t, cursor.SaveUserInfo(ctx, &models.UserInfo{Username: user.name, Password: "test"}, l))
+		require.NoError(t, cursor.SetUserRole(ctx, user.name, user.role, l))
+		cursor.SaveSession(ctx, user.name, &models.Session{Username: user.name, Token: user.name, ExpiresAt: time.Now().Add(time.Minute)}, l)
+	}
+	cursor.AppendLedgerEntry(ctx, &models.LedgerEntry{
+		User:   "test",
+		Kind:   configuration.CREDIT,
+		Amount: models.NewMoney(100),
+		Order:  "9278923470",
+	}, l)
+
+	do := func(method string, url string, as string, payload interface{}) *httptest.ResponseRecorder {
+		body := bytes.NewBuffer([]byte{})
+		if payload != nil {
+			json.NewEncoder(body).Encode(payload)
+		}
+		request := httptest.NewRequest(method, "http://localhost:8080"+url, body)
+		request.AddCookie(&http.Cookie{Name: "session_token", Value: as})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		as      string
+		payload interface{}
+		code    int
+	}{
+		{"user cannot look", http.MethodGet, "/api/admin/users/test/balance", "test", nil, http.StatusForbidden},
+		{"support looks at balance", http.MethodGet, "/api/admin/users/test/balance", "support", nil, http.StatusOK},
+		{"support looks at orders", http.MethodGet, "/api/admin/users/test/orders", "support", nil, http.StatusNoContent},
+		{"support looks at withdrawals", http.MethodGet, "/api/admin/users/test/withdrawals", "support", nil, http.StatusNoContent},
+		{"unknown user", http.MethodGet, "/api/admin/users/nobody/balance", "support", nil, http.StatusNotFound},
+		{"support cannot adjust", http.MethodPost, "/api/admin/users/test/adjustments", "support",
+			&models.AdjustmentPost{Amount: models.NewMoney(10), ReasonCode: configuration.GOODWILL, Reason: "outage"}, http.StatusForbidden},
+		{"adjustment needs a reason", http.MethodPost, "/api/admin/users/test/adjustments", "admin",
+			&models.AdjustmentPost{Amount: models.NewMoney(10), ReasonCode: configuration.GOODWILL}, http.StatusBadRequest},
+		{"adjustment needs a reason code", http.MethodPost, "/api/admin/users/test/adjustments", "admin",
+			&models.AdjustmentPost{Amount: models.NewMoney(10), ReasonCode: "BONUS", Reason: "outage"}, http.StatusBadRequest},
+		{"adjustment needs an amount", http.MethodPost, "/api/admin/users/test/adjustments", "admin",
+			&models.AdjustmentPost{ReasonCode: configuration.GOODWILL, Reason: "outage"}, http.StatusBadRequest},
+		{"admin adjusts", http.MethodPost, "/api/admin/users/test/adjustments", "admin",
+			&models.AdjustmentPost{Amount: models.NewMoney(-25.5), ReasonCode: configuration.ACCRUALCORRECTION, Reason: "duplicate accrual"}, http.StatusOK},
+		{"adjustment cannot overdraw", http.MethodPost, "/api/admin/users/test/adjustments", "admin",
+			&models.AdjustmentPost{Amount: models.NewMoney(-500), ReasonCode: configuration.FRAUDREVERSAL, Reason: "stolen card"}, http.StatusConflict},
+		{"user sees the adjustment", http.MethodGet, "/api/user/balance/statement", "test", nil, http.StatusOK},
+		{"support sees the statement", http.MethodGet, "/api/admin/users/test/balance/statement", "support", nil, http.StatusOK},
+		{"support cannot promote", http.MethodPut, "/api/admin/users/test/role", "support",
+			&models.RolePost{Role: configuration.ROLEADMIN}, http.StatusForbidden},
+		{"unknown role", http.MethodPut, "/api/admin/users/test/role", "admin",
+			&models.RolePost{Role: "root"}, http.StatusBadRequest},
+		{"own role", http.MethodPut, "/api/admin/users/admin/role", "admin",
+			&models.RolePost{Role: configuration.ROLEUSER}, http.StatusForbidden},
+		{"admin promotes", http.MethodPut, "/api/admin/users/test/role", "admin",
+			&models.RolePost{Role: configuration.ROLESUPPORT}, http.StatusNoContent},
+		{"promoted user looks", http.MethodGet, "/api/admin/users/support/balance", "test", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, do(tt.method, tt.url, tt.as, tt.payload).Code)
+		})
+	}
+
+	balance, err := cursor.GetUserBalance(ctx, "test", l)
+	require.NoError(t, err)
+	assert.Equal(t, models.NewMoney(74.5), balance.Current)
+	entries, err := cursor.GetLedger(ctx, "test", l)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, configuration.ADJUSTMENT, entries[1].Kind)
+	assert.Equal(t, "ACCRUAL_CORRECTION: duplicate accrual", entries[1].Reason)
+}
+
+func TestAdminAdjustmentApproval(t *testing.T) {
+	l := zap.NewNop()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx := context.Background()
+	manager := jobmanager.NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l)
+	handler := NewHandler(cursor, manager, nil, nil, l)
+
+	for _, user := range []struct{ name, role string }{
+		{"test", configuration.ROLEUSER},
+		{"admin", configuration.ROLEADMIN},
+		{"other", configuration.ROLEADMIN},
+	} {
+		require.NoError(t, cursor.SaveUserInfo(ctx, &models.UserInfo{Username: user.name, Password: "test"}, l))
+		require.NoError(t, cursor.SetUserRole(ctx, user.name, user.role, l))
+		cursor.SaveSession(ctx, user.name, &models.Session{Username: user.name, Token: user.name, ExpiresAt: time.Now().Add(time.Minute)}, l)
+	}
+
+	do := func(method string, url string, as string, payload interface{}) *httptest.ResponseRecorder {
+		body := bytes.NewBuffer([]byte{})
+		if payload != nil {
+			json.NewEncoder(body).Encode(payload)
+		}
+		request := httptest.NewRequest(method, "http://localhost:8080"+url, body)
+		request.AddCookie(&http.Cookie{Name: "session_token", Value: as})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+	request := func(amount float64) *models.Adjustment {
+		res := do(http.MethodPost, "/api/admin/users/test/adjustments", "admin",
+			&models.AdjustmentPost{Amount: models.NewMoney(amount), ReasonCode: configuration.GOODWILL, Reason: "outage"})
+		require.Equal(t, http.StatusAccepted, res.Code)
+		adjustment := &models.Adjustment{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(adjustment))
+		assert.Equal(t, configuration.ADJUSTMENTPENDING, adjustment.Status)
+		return adjustment
+	}
+
+	// above the threshold nothing is applied until a second admin approves
+	approved := request(configuration.ADJUSTMENTAPPROVALTHRESHOLD + 1)
+	rejected := request(configuration.ADJUSTMENTAPPROVALTHRESHOLD * 2)
+	balance, err := cursor.GetUserBalance(ctx, "test", l)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(0), balance.Current)
+
+	res := do(http.MethodGet, "/api/admin/adjustments?status=PENDING", "admin", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	pending := []*models.Adjustment{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&pending))
+	assert.Len(t, pending, 2)
+
+	approve := "/api/admin/adjustments/" + strconv.FormatInt(approved.ID, 10) + "/approve"
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, approve, "admin", nil).Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, approve, "test", nil).Code)
+	res = do(http.MethodPost, approve, "other", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(approved))
+	assert.Equal(t, configuration.ADJUSTMENTAPPLIED, approved.Status)
+	assert.Equal(t, "other", approved.ReviewedBy)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, approve, "other", nil).Code)
+
+	reject := "/api/admin/adjustments/" + strconv.FormatInt(rejected.ID, 10) + "/reject"
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, reject, "other", nil).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/admin/adjustments/42/approve", "other", nil).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/admin/adjustments?status=PENDING", "admin", nil).Code)
+
+	balance, err = cursor.GetUserBalance(ctx, "test", l)
+	require.NoError(t, err)
+	assert.Equal(t, models.NewMoney(configuration.ADJUSTMENTAPPROVALTHRESHOLD+1), balance.Current)
+	res = do(http.MethodGet, "/api/user/balance/statement", "test", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	entries := []*models.LedgerEntry{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&entries))
+	require.Len(t, entries, 1)
+	assert.Equal(t, "GOODWILL: outage", entries[0].Reason)
+}
+
+func TestAdminAuditLog(t *testing.T) {
+	l := zap.NewNop()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx := context.Background()
+	manager := jobmanager.NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l)
+	handler := NewHandler(cursor, manager, nil, nil, l)
+	for _, user := range []struct{ name, role string }{
+		{"support", configuration.ROLESUPPORT},
+		{"admin", configuration.ROLEADMIN},
+	} {
+		require.NoError(t, cursor.SaveUserInfo(ctx, &models.UserInfo{Username: user.name, Password: "test"}, l))
+		require.NoError(t, cursor.SetUserRole(ctx, user.name, user.role, l))
+		cursor.SaveSession(ctx, user.name, &models.Session{Username: user.name, Token: user.name, ExpiresAt: time.Now().Add(time.Minute)}, l)
+	}
+
+	do := func(method string, url string, as string, payload interface{}) *httptest.ResponseRecorder {
+		body := bytes.NewBuffer([]byte{})
+		if payload != nil {
+			json.NewEncoder(body).Encode(payload)
+		}
+		request := httptest.NewRequest(method, "http://localhost:8080"+url, body)
+		request.Header.Set("User-Agent", "laptop")
+		if as != "" {
+			request.AddCookie(&http.Cookie{Name: "session_token", Value: as})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/api/user/register", "", &models.UserInfo{Username: "test", Password: "test"}).Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/user/login", "", &models.UserInfo{Username: "test", Password: "wrong"}).Code)
+	require.Equal(t, http.StatusNoContent, do(http.MethodPut, "/api/admin/users/test/role", "admin", &models.RolePost{Role: configuration.ROLESUPPORT}).Code)
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/admin/audit", "support", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/admin/audit?limit=0", "admin", nil).Code)
+	res := do(http.MethodGet, "/api/admin/audit?subject=test&limit=3", "admin", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	entries := []*models.AuditEntry{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&entries))
+	require.Len(t, entries, 3)
+	assert.Equal(t, configuration.AUDITROLECHANGED, entries[0].Action)
+	assert.Equal(t, "admin", entries[0].Actor)
+	assert.JSONEq(t, `{"role":"user"}`, string(entries[0].Before))
+	assert.JSONEq(t, `{"role":"support"}`, string(entries[0].After))
+	assert.Equal(t, configuration.AUDITLOGINFAILED, entries[1].Action)
+	assert.Equal(t, "laptop", entries[1].UserAgent)
+	assert.NotEmpty(t, entries[1].IP)
+	assert.Equal(t, configuration.AUDITSESSIONCREATED, entries[2].Action)
+
+	res = do(http.MethodGet, "/api/admin/audit?subject=test&limit=3&after="+res.Header().Get("X-Next-Cursor"), "admin", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	entries = []*models.AuditEntry{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&entries))
+	require.Len(t, entries, 1)
+	assert.Equal(t, configuration.AUDITREGISTERED, entries[0].Action)
+}
+
+func TestAdminDeadJobs(t *testing.T) {
+	l := zap.NewNop()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx := context.Background()
+	manager := jobmanager.NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l)
+	handler := NewHandler(cursor, manager, nil, nil, l)
+	for _, user := range []struct{ name, role string }{
+		{"support", configuration.ROLESUPPORT},
+		{"admin", configuration.ROLEADMIN},
+	} {
+		require.NoError(t, cursor.SaveUserInfo(ctx, &models.UserInfo{Username: user.name, Password: "test"}, l))
+		require.NoError(t, cursor.SetUserRole(ctx, user.name, user.role, l))
+		cursor.SaveSession(ctx, user.name, &models.Session{Username: user.name, Token: user.name, ExpiresAt: time.Now().Add(time.Minute)}, l)
+	}
+	do := func(method string, url string, as string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "http://localhost:8080"+url, nil)
+		request.AddCookie(&http.Cookie{Name: "session_token", Value: as})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/admin/jobs/dead", "admin").Code)
+	require.NoError(t, cursor.EnqueueJob(ctx, &models.AccrualJob{Number: "9278923470", Username: "test", CreatedAt: time.Now()}, l))
+	require.NoError(t, cursor.BuryJob(ctx, "9278923470", "accrual is down", l))
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/admin/jobs/dead", "support").Code)
+	w := do(http.MethodGet, "/api/admin/jobs/dead", "admin")
+	require.Equal(t, http.StatusOK, w.Code)
+	var dead []*models.AccrualJob
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&dead))
+	require.Len(t, dead, 1)
+	assert.Equal(t, "9278923470", dead[0].Number)
+	assert.Equal(t, "accrual is down", dead[0].LastError)
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/admin/jobs/dead/9278923470/redrive", "support").Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPost, "/api/admin/jobs/dead/9278923470/redrive", "admin").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/admin/jobs/dead/9278923470/redrive", "admin").Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/admin/jobs/dead", "admin").Code)
+	claimed, err := cursor.ClaimJobs(ctx, 10, time.Minute, l)
+	require.NoError(t, err)
+	assert.Len(t, claimed, 1)
+
+	entries, err := cursor.GetAuditLog(ctx, &models.AuditQuery{Action: configuration.AUDITJOBREDRIVEN}, l)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "admin", entries[0].Actor)
+	assert.Equal(t, "9278923470", entries[0].Subject)
+}
@@ -1,8 +1,15 @@
 package api
 
 import (
+	"net/http"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/auth"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/events"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/jobmanager"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/ratelimit"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
@@ -10,6 +17,7 @@ import (
 type UserRouter struct {
 	*chi.Mux
 	Cursor *db.Cursor
+	Keys   *auth.Keyring
 	Logger *zap.Logger
 }
 
@@ -23,52 +31,100 @@ type OrderRouter struct {
 type BalanceRouter struct {
 	*chi.Mux
 	Cursor *db.Cursor
+	Events *events.Bus
 	Logger *zap.Logger
 }
 
+type WebhookRouter struct {
+	*chi.Mux
+	Cursor *db.Cursor
+	Logger *zap.Logger
+}
+
+type AdminRouter struct {
+	*chi.Mux
+	Cursor  *db.Cursor
+	Manager *jobmanager.Jobmanager
+	Logger  *zap.Logger
+	// ApprovalThreshold is the largest adjustment applied without a second
+	// admin's approval.
+	ApprovalThreshold models.Money
+}
+
 type Handler struct {
 	*chi.Mux
 	Cursor *db.Cursor
+	Keys   *auth.Keyring
 	Logger *zap.Logger
 }
 
-func NewHandler(cursor *db.Cursor, manager *jobmanager.Jobmanager, l *zap.Logger) *Handler {
+// NewHandler serves the API. Each route group gets its own rate limit from
+// limits, there is none when limits is nil.
+func NewHandler(cursor *db.Cursor, manager *jobmanager.Jobmanager, keys *auth.Keyring, limits ratelimit.Limits, l *zap.Logger) *Handler {
 	handler := &Handler{
 		Mux:    chi.NewMux(),
 		Cursor: cursor,
+		Keys:   keys,
 		Logger: l,
 	}
 	handler.Use(GzipHandle)
-	handler.Use(handler.CookieHandle)
+	handler.Use(Authenticate(cursor, keys, l))
+	handler.Use(Audit)
 
 	userRouter := &UserRouter{
 		Mux:    chi.NewMux(),
 		Cursor: cursor,
+		Keys:   keys,
 		Logger: l,
 	}
 
 	balanceRouter := &BalanceRouter{
 		Mux:    chi.NewMux(),
 		Cursor: cursor,
+		Events: manager.Events,
 		Logger: l,
 	}
 
 	handler.Route("/api/user", func(r chi.Router) {
 
-		r.Post("/register", userRouter.RegisterUser)
-		r.Post("/login", userRouter.Login)
+		r.Group(func(r chi.Router) {
+			r.Use(rateLimit(limits, configuration.RATELIMITAUTH))
+			r.Post("/register", userRouter.RegisterUser)
+			r.Post("/login", userRouter.Login)
+			r.Post("/refresh", userRouter.Refresh)
+			r.Post("/logout", userRouter.Logout)
+			r.Get("/sessions", userRouter.GetSessions)
+			r.Delete("/sessions/{id}", userRouter.RevokeSession)
+		})
 
-		r.Get("/withdrawals", balanceRouter.GetWithdrawals)
-		r.Get("/balance", balanceRouter.GetBalance)
-		r.Post("/balance/withdraw", balanceRouter.WithdrawMoney)
+		r.Group(func(r chi.Router) {
+			r.Use(rateLimit(limits, configuration.RATELIMITBALANCE))
+			r.Get("/withdrawals", balanceRouter.GetWithdrawals)
+			r.Get("/balance", balanceRouter.GetBalance)
+			r.Get("/balance/ws", balanceRouter.StreamBalance)
+			r.Get("/balance/statement", balanceRouter.GetStatement)
+			r.Post("/balance/withdraw", balanceRouter.WithdrawMoney)
+		})
 
 		OrdersRouter := NewOrdersRouter(cursor, manager, l)
-		r.Mount("/orders", OrdersRouter)
+		r.With(rateLimit(limits, configuration.RATELIMITORDERS)).Mount("/orders", OrdersRouter)
+		r.Mount("/webhooks", NewWebhooksRouter(cursor, l))
 	})
+	handler.Mount("/api/admin", NewAdminRouter(cursor, manager, l))
 
 	return handler
 }
 
+// rateLimit limits the requests to the route group with a limiter of its
+// own, or lets them all through without limits.
+func rateLimit(limits ratelimit.Limits, group string) func(http.Handler) http.Handler {
+	limit, ok := limits[group]
+	if !ok {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return RateLimit(ratelimit.NewLimiter(limit))
+}
+
 func NewOrdersRouter(cursor *db.Cursor, manager *jobmanager.Jobmanager, l *zap.Logger) *OrderRouter {
 	r := &OrderRouter{
 		Mux:     chi.NewMux(),
@@ -78,5 +134,49 @@ func NewOrdersRouter(cursor *db.Cursor, manager *jobmanager.Jobmanager, l *zap.L
 	}
 	r.Post("/", r.UploadOrder)
 	r.Get("/", r.GetOrders)
+	r.Get("/stream", r.StreamOrders)
+	return r
+}
+
+func NewWebhooksRouter(cursor *db.Cursor, l *zap.Logger) *WebhookRouter {
+	r := &WebhookRouter{
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
+		Logger: l,
+	}
+	r.Post("/", r.CreateWebhook)
+	r.Get("/", r.GetWebhooks)
+	r.Delete("/{id}", r.DeleteWebhook)
+	r.Get("/{id}/deliveries", r.GetDeliveries)
+	return r
+}
+
+// NewAdminRouter serves the staff API. Support can look into any user's
+// account, only admins can change it.
+func NewAdminRouter(cursor *db.Cursor, manager *jobmanager.Jobmanager, l *zap.Logger) *AdminRouter {
+	r := &AdminRouter{
+		Mux:               chi.NewMux(),
+		Cursor:            cursor,
+		Manager:           manager,
+		Logger:            l,
+		ApprovalThreshold: models.NewMoney(configuration.ADJUSTMENTAPPROVALTHRESHOLD),
+	}
+	r.Use(RequireRole(configuration.ROLESUPPORT))
+	r.Route("/users/{login}", func(u chi.Router) {
+		u.Get("/orders", r.GetUserOrders)
+		u.Get("/balance", r.GetUserBalance)
+		u.Get("/balance/statement", r.GetUserStatement)
+		u.Get("/withdrawals", r.GetUserWithdrawals)
+		u.With(RequireRole(configuration.ROLEADMIN)).Post("/adjustments", r.AdjustBalance)
+		u.With(RequireRole(configuration.ROLEADMIN)).Put("/role", r.SetUserRole)
+		u.With(RequireRole(configuration.ROLEADMIN)).Post("/unlock", r.UnlockUser)
+	})
+	r.With(RequireRole(configuration.ROLEADMIN)).Post("/ips/{ip}/unlock", r.UnlockIP)
+	r.With(RequireRole(configuration.ROLEADMIN)).Get("/audit", r.GetAuditLog)
+	r.Get("/adjustments", r.GetAdjustments)
+	r.With(RequireRole(configuration.ROLEADMIN)).Post("/adjustments/{id}/approve", r.ApproveAdjustment)
+	r.With(RequireRole(configuration.ROLEADMIN)).Post("/adjustments/{id}/reject", r.RejectAdjustment)
+	r.With(RequireRole(configuration.ROLEADMIN)).Get("/jobs/dead", r.GetDeadJobs)
+	r.With(RequireRole(configuration.ROLEADMIN)).Post("/jobs/dead/{number}/redrive", r.RedriveJob)
 	return r
 }
@@ -16,17 +16,14 @@ import (
 )
 
 func TestCookies(t *testing.T) {
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
 	handler := &Handler{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
 	ur := &UserRouter{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
 	handler.Post("/api/user/register", ur.RegisterUser)
 	handler.Post("/api/user/login", ur.Login)
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/audit"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+// clientIP is the address the request came from, without the port.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// recordAudit appends an action taken by actor to the audit log. The action
+// has already happened by then, so a failure is only logged.
+func recordAudit(r *http.Request, cursor *db.Cursor, actor string, action string, subject string, before interface{}, after interface{}, l *zap.Logger) {
+	entry, err := audit.NewEntry(r.Context(), action, subject, before, after)
+	if err == nil {
+		entry.Actor = actor
+		err = cursor.AppendAudit(r.Context(), entry, l)
+	}
+	if err != nil {
+		l.Error("Error recording audit entry", zap.String("action", action), zap.Error(err))
+	}
+}
+
+// GetAuditLog pages through the audit log, newest first. It can be narrowed
+// down with the actor, subject, action, from and to parameters.
+func (h *AdminRouter) GetAuditLog(rw http.ResponseWriter, r *http.Request) {
+	query, err := ParseAuditQuery(r.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// one extra entry tells whether there is a next page
+	page := *query
+	if query.Limit > 0 {
+		page.Limit = query.Limit + 1
+	}
+	entries, err := h.Cursor.GetAuditLog(r.Context(), &page, h.Logger)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+		last := entries[len(entries)-1]
+		SetNextPage(rw, r, &models.PageCursor{Time: last.CreatedAt, Key: strconv.FormatInt(last.ID, 10)})
+	}
+	if len(entries) == 0 {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(rw, http.StatusOK, entries)
+}
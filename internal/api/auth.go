@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/auth"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// requirePrincipal returns the principal put in the request context by
+// Authenticate. Without one the request is answered as unauthorized.
+func requirePrincipal(rw http.ResponseWriter, r *http.Request) (*auth.Principal, bool) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+	}
+	return principal, ok
+}
+
+// issueAccessToken hands a signed access token to the client in the
+// Authorization header, next to the session cookie.
+func (h *UserRouter) issueAccessToken(rw http.ResponseWriter, session *models.Session) {
+	if h.Keys == nil {
+		return
+	}
+	token, err := h.Keys.IssueToken(session.Username, session.ID, session.Role, configuration.ACCESSTOKENTTL*time.Second)
+	if err != nil {
+		h.Logger.Error("Error issuing access token", zap.Error(err))
+		return
+	}
+	rw.Header().Set("Authorization", "Bearer "+token)
+}
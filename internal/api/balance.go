@@ -4,17 +4,22 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
 )
 
 func (h *BalanceRouter) GetBalance(rw http.ResponseWriter, r *http.Request) {
-	cookie, _ := r.Cookie("session_token")
-	sessionToken := cookie.Value
-	username, err := h.Cursor.GetUsernameByToken(sessionToken, h.Logger)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
 		return
 	}
-	balance, err := h.Cursor.GetUserBalance(username, h.Logger)
+	writeBalance(rw, r, h.Cursor, principal.Username, h.Logger)
+}
+
+func writeBalance(rw http.ResponseWriter, r *http.Request, cursor *db.Cursor, username string, l *zap.Logger) {
+	balance, err := cursor.GetUserBalance(r.Context(), username, l)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
@@ -33,3 +38,26 @@ func (h *BalanceRouter) GetBalance(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// GetStatement lists every movement of the user's balance: accruals,
+// withdrawals and manual adjustments.
+func (h *BalanceRouter) GetStatement(rw http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	writeStatement(rw, r, h.Cursor, principal.Username, h.Logger)
+}
+
+func writeStatement(rw http.ResponseWriter, r *http.Request, cursor *db.Cursor, username string, l *zap.Logger) {
+	entries, err := cursor.GetLedger(r.Context(), username, l)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(entries) == 0 {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(rw, http.StatusOK, entries)
+}
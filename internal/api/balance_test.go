@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/logger"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/mocks"
@@ -13,14 +14,15 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
 )
 
 func TestBalanceGet(t *testing.T) {
 	expectedBalance := &models.Balance{
-		Current:   500.5,
-		Withdrawn: 42,
+		Current:   models.NewMoney(500.5),
+		Withdrawn: models.NewMoney(42),
 	}
 	type want struct {
 		code int
@@ -43,42 +45,47 @@ func TestBalanceGet(t *testing.T) {
 			},
 		},
 	}
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
 	handler := &Handler{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
 	ur := &UserRouter{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
 	br := &BalanceRouter{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
-	handler.Post("/api/user/register", ur.RegisterUser)
-	handler.Post("/api/user/login", ur.Login)
-	handler.Get("/api/user/balance", br.GetBalance)
 	l, err := logger.InitializeLogger("info")
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
+	handler.Use(Authenticate(cursor, nil, l))
+	handler.Post("/api/user/register", ur.RegisterUser)
+	handler.Post("/api/user/login", ur.Login)
+	handler.Get("/api/user/balance", br.GetBalance)
 	ts := httptest.NewServer(handler)
-	handler.Cursor.SaveUserInfo(&models.UserInfo{
+	handler.Cursor.SaveUserInfo(context.Background(), &models.UserInfo{
 		Username: "test",
 		Password: "test",
 	}, l)
 
-	result, _ := handler.Cursor.UpdateUserBalance(
-		"test", expectedBalance,
-		l,
-	)
-	assert.Equal(t, expectedBalance, result)
+	handler.Cursor.AppendLedgerEntry(context.Background(), &models.LedgerEntry{
+		User:   "test",
+		Kind:   configuration.CREDIT,
+		Amount: models.NewMoney(542.5),
+		Order:  "9278923470",
+	}, l)
+	result, _ := handler.Cursor.AppendLedgerEntry(context.Background(), &models.LedgerEntry{
+		User:   "test",
+		Kind:   configuration.DEBIT,
+		Amount: models.NewMoney(-42),
+		Order:  "2377225624",
+	}, l)
+	assert.Equal(t, expectedBalance.Current, result.Current)
+	assert.Equal(t, expectedBalance.Withdrawn, result.Withdrawn)
 
 	buff := bytes.NewBuffer([]byte{})
 	encoder := json.NewEncoder(buff)
@@ -1,12 +1,17 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/MlDenis/diploma-wannabe-v2/internal/auth"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
-	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
 func (h *UserRouter) Login(rw http.ResponseWriter, r *http.Request) {
@@ -19,31 +24,138 @@ func (h *UserRouter) Login(rw http.ResponseWriter, r *http.Request) {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-	dbData, err := h.Cursor.GetUserInfo(userInput, h.Logger)
+	targets := loginTargets(userInput.Username, clientIP(r))
+	attempts, wait, err := h.reserveLogin(r.Context(), targets)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(rw, "too many failed logins", http.StatusTooManyRequests)
+		return
+	}
+	dbData, err := h.Cursor.GetUserInfo(r.Context(), userInput, h.Logger)
 
 	if err != nil {
+		auth.BurnPasswordCheck(userInput.Password)
+		h.recordLoginFailure(r, userInput.Username, targets, attempts)
 		http.Error(rw, "wrong password/username", http.StatusUnauthorized)
 		return
 	}
 	if err := ValidateLogin(userInput, dbData); err != nil {
+		h.recordLoginFailure(r, userInput.Username, targets, attempts)
 		http.Error(rw, "wrong password/username", http.StatusUnauthorized)
 		return
 	}
-	sessionToken := uuid.NewString()
-	expiresAt := time.Now().Add(600 * time.Second)
-
-	_ = h.Cursor.SaveSession(sessionToken, &models.Session{
-		Username:  userInput.Username,
-		ExpiresAt: expiresAt,
-		Token:     sessionToken,
-	}, h.Logger)
-
-	http.SetCookie(rw, &http.Cookie{
-		Name:    "session_token",
-		Value:   sessionToken,
-		Expires: expiresAt,
-	})
+	recordAudit(r, h.Cursor, dbData.Username, configuration.AUDITLOGIN, dbData.Username, nil, nil, h.Logger)
+	if err := h.Cursor.ResetLoginAttempts(r.Context(), configuration.LOGINSCOPEACCOUNT, dbData.Username, h.Logger); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the address counted the attempt too, but it did not fail
+	h.releaseLogin(r.Context(), attempts[1:])
+	if auth.NeedsRehash(dbData.Password) {
+		h.rehashPassword(r.Context(), userInput)
+	}
+	if err := h.startSession(rw, r, dbData.Username, dbData.Role); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	rw.WriteHeader(http.StatusOK)
 
 	_, _ = rw.Write([]byte(`success`))
 }
+
+// rehashPassword upgrades a legacy or weaker stored password after the user
+// proved to know it. Failures only delay the upgrade to the next login.
+func (h *UserRouter) rehashPassword(ctx context.Context, input *models.UserInfo) {
+	hash, err := auth.HashPassword(input.Password)
+	if err != nil {
+		h.Logger.Error("Error hashing password", zap.Error(err))
+		return
+	}
+	if err := h.Cursor.UpdateUserPassword(ctx, input.Username, hash, h.Logger); err != nil {
+		h.Logger.Error("Error upgrading password hash", zap.Error(err))
+	}
+}
+
+// loginTarget is what failed logins are counted against, with the number
+// of attempts it gets within LOGINFAILUREWINDOW and how long it waits after
+// each failure.
+type loginTarget struct {
+	scope string
+	key   string
+	max   int
+	delay func(int) time.Duration
+}
+
+func loginTargets(login string, ip string) []loginTarget {
+	return []loginTarget{
+		{configuration.LOGINSCOPEACCOUNT, login, configuration.LOGINMAXFAILURES, auth.AccountLoginDelay},
+		{configuration.LOGINSCOPEIP, ip, configuration.LOGINIPMAXFAILURES, auth.IPLoginDelay},
+	}
+}
+
+// reserveLogin counts the attempt against every target before the password
+// is checked and decides on the returned numbers, so parallel requests can
+// not get more guesses than the limits allow. When a target is locked or out
+// of attempts it releases what it counted and returns how long to wait.
+func (h *UserRouter) reserveLogin(ctx context.Context, targets []loginTarget) ([]*models.LoginAttempts, time.Duration, error) {
+	now := time.Now()
+	reserved := make([]*models.LoginAttempts, 0, len(targets))
+	for _, target := range targets {
+		attempts := &models.LoginAttempts{Scope: target.scope, Key: target.key, LastFailureAt: now}
+		counted, err := h.Cursor.RecordLoginAttempt(ctx, attempts, configuration.LOGINFAILUREWINDOW*time.Second, h.Logger)
+		if err != nil {
+			h.releaseLogin(ctx, reserved)
+			return nil, 0, err
+		}
+		if counted && attempts.Failures <= target.max {
+			reserved = append(reserved, attempts)
+			continue
+		}
+		wait := target.delay(attempts.Failures)
+		if counted {
+			reserved = append(reserved, attempts)
+		} else {
+			wait = attempts.LockedUntil.Sub(now)
+		}
+		h.releaseLogin(ctx, reserved)
+		return nil, max(wait, time.Second), nil
+	}
+	return reserved, 0, nil
+}
+
+// releaseLogin takes back the counted attempts that did not fail. Errors
+// only leave the targets with an attempt less to spare.
+func (h *UserRouter) releaseLogin(ctx context.Context, reserved []*models.LoginAttempts) {
+	for _, attempts := range reserved {
+		if err := h.Cursor.ReleaseLoginAttempt(ctx, attempts.Scope, attempts.Key, h.Logger); err != nil {
+			h.Logger.Error("Error releasing login attempt", zap.Error(err))
+		}
+	}
+}
+
+// recordLoginFailure audits the failed login and, the attempt being counted
+// already, refuses further logins to the targets for a while once they fail
+// too often. Lockouts are audited as well.
+func (h *UserRouter) recordLoginFailure(r *http.Request, login string, targets []loginTarget, reserved []*models.LoginAttempts) {
+	recordAudit(r, h.Cursor, login, configuration.AUDITLOGINFAILED, login, nil, nil, h.Logger)
+	for i, target := range targets {
+		attempts := reserved[i]
+		delay := target.delay(attempts.Failures)
+		if delay == 0 {
+			continue
+		}
+		attempts.LockedUntil = attempts.LastFailureAt.Add(delay)
+		if err := h.Cursor.LockLogin(r.Context(), attempts, h.Logger); err != nil {
+			h.Logger.Error("Error locking login", zap.Error(err))
+			continue
+		}
+		// shorter delays only slow guessing down, the lockout is an event
+		if delay == configuration.LOGINLOCKOUT*time.Second {
+			recordAudit(r, h.Cursor, login, configuration.AUDITLOGINLOCKED, target.key, nil, attempts, h.Logger)
+		}
+	}
+}
@@ -2,18 +2,28 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/logger"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/mocks"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/MlDenis/diploma-wannabe-v2/internal/auth"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/jobmanager"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 func TestAuthentication(t *testing.T) {
@@ -111,7 +121,7 @@ func TestAuthentication(t *testing.T) {
 	ur.Post("/api/user/login", ur.Login)
 	l, _ := logger.InitializeLogger("info")
 	ts := httptest.NewServer(ur)
-	ur.Cursor.SaveUserInfo(&models.UserInfo{
+	ur.Cursor.SaveUserInfo(context.Background(), &models.UserInfo{
 		Username: "test",
 		Password: "test",
 	}, l)
@@ -146,3 +156,155 @@ func TestAuthentication(t *testing.T) {
 		})
 	}
 }
+
+func TestLoginRehashesLegacyPassword(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	ur := &UserRouter{
+		Mux: chi.NewMux(),
+		Cursor: &db.Cursor{
+			IDBInterface: mocks.NewMock(),
+		},
+		Logger: l,
+	}
+	ur.Post("/api/user/login", ur.Login)
+	ur.Cursor.SaveUserInfo(context.Background(), &models.UserInfo{
+		Username: "test",
+		Password: "test",
+	}, l)
+
+	buff := bytes.NewBuffer([]byte{})
+	json.NewEncoder(buff).Encode(&models.UserInfo{
+		Username: "test",
+		Password: "test",
+	})
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/login", buff)
+	w := httptest.NewRecorder()
+	ur.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	stored, err := ur.Cursor.GetUserInfo(context.Background(), &models.UserInfo{Username: "test"}, l)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "test", stored.Password)
+	assert.True(t, auth.VerifyPassword(stored.Password, "test"))
+	assert.False(t, auth.NeedsRehash(stored.Password))
+}
+
+func TestLoginLockout(t *testing.T) {
+	l := zap.NewNop()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx := context.Background()
+	manager := jobmanager.NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l)
+	handler := NewHandler(cursor, manager, nil, nil, l)
+	cursor.SaveUserInfo(ctx, &models.UserInfo{Username: "test", Password: "test"}, l)
+	cursor.SaveUserInfo(ctx, &models.UserInfo{Username: "admin", Password: "test"}, l)
+	cursor.SetUserRole(ctx, "admin", configuration.ROLEADMIN, l)
+	cursor.SaveSession(ctx, "admin", &models.Session{Username: "admin", Token: "admin", ExpiresAt: time.Now().Add(time.Minute)}, l)
+	cursor.SaveSession(ctx, "test", &models.Session{Username: "test", Token: "test", ExpiresAt: time.Now().Add(time.Minute)}, l)
+
+	do := func(method string, url string, payload interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		body := bytes.NewBuffer([]byte{})
+		if payload != nil {
+			json.NewEncoder(body).Encode(payload)
+		}
+		request := httptest.NewRequest(method, "http://localhost:8080"+url, body)
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+	login := func(username string, password string) *httptest.ResponseRecorder {
+		return do(http.MethodPost, "/api/user/login", &models.UserInfo{Username: username, Password: password})
+	}
+	admin := &http.Cookie{Name: "session_token", Value: "admin"}
+
+	// the first failures are free, then the account has to wait
+	for i := 0; i <= configuration.LOGINFREEFAILURES; i++ {
+		require.Equal(t, http.StatusUnauthorized, login("test", "wrong").Code)
+	}
+	res := login("test", "test")
+	require.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "1", res.Header().Get("Retry-After"))
+
+	// one more failure locks the account out, the reset lifts the delay
+	// instead of waiting it out
+	cursor.ResetLoginAttempts(ctx, configuration.LOGINSCOPEACCOUNT, "test", l)
+	for i := 1; i < configuration.LOGINMAXFAILURES; i++ {
+		cursor.RecordLoginAttempt(ctx, &models.LoginAttempts{Scope: configuration.LOGINSCOPEACCOUNT, Key: "test", LastFailureAt: time.Now()},
+			configuration.LOGINFAILUREWINDOW*time.Second, l)
+	}
+	require.Equal(t, http.StatusUnauthorized, login("test", "wrong").Code)
+	res = login("test", "test")
+	require.Equal(t, http.StatusTooManyRequests, res.Code)
+	retryAfter, err := strconv.Atoi(res.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.Greater(t, retryAfter, configuration.LOGINLOCKOUT-5)
+	// other accounts are not affected
+	assert.Equal(t, http.StatusOK, login("admin", "test").Code)
+
+	entries, err := cursor.GetAuditLog(ctx, &models.AuditQuery{Action: configuration.AUDITLOGINLOCKED}, l)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "test", entries[0].Subject)
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/admin/users/test/unlock", nil,
+		&http.Cookie{Name: "session_token", Value: "test"}).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/admin/users/nobody/unlock", nil, admin).Code)
+	require.Equal(t, http.StatusNoContent, do(http.MethodPost, "/api/admin/users/test/unlock", nil, admin).Code)
+	assert.Equal(t, http.StatusOK, login("test", "test").Code)
+	entries, err = cursor.GetAuditLog(ctx, &models.AuditQuery{Action: configuration.AUDITLOGINUNLOCKED}, l)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "admin", entries[0].Actor)
+
+	// an address failing with many accounts is locked out for all of them
+	ip := "192.0.2.1"
+	cursor.ResetLoginAttempts(ctx, configuration.LOGINSCOPEIP, ip, l)
+	for i := 1; i < configuration.LOGINIPMAXFAILURES; i++ {
+		cursor.RecordLoginAttempt(ctx, &models.LoginAttempts{Scope: configuration.LOGINSCOPEIP, Key: ip, LastFailureAt: time.Now()},
+			configuration.LOGINFAILUREWINDOW*time.Second, l)
+	}
+	require.Equal(t, http.StatusUnauthorized, login("nobody", "wrong").Code)
+	assert.Equal(t, http.StatusTooManyRequests, login("test", "test").Code)
+	require.Equal(t, http.StatusNoContent, do(http.MethodPost, "/api/admin/ips/"+ip+"/unlock", nil, admin).Code)
+	assert.Equal(t, http.StatusOK, login("test", "test").Code)
+}
+
+func TestLoginLockoutConcurrent(t *testing.T) {
+	l := zap.NewNop()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx := context.Background()
+	manager := jobmanager.NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l)
+	handler := NewHandler(cursor, manager, nil, nil, l)
+	cursor.SaveUserInfo(ctx, &models.UserInfo{Username: "test", Password: "test"}, l)
+
+	// parallel guesses get no more password checks than the limit
+	const n = 3 * configuration.LOGINMAXFAILURES
+	var wg sync.WaitGroup
+	codes := make(chan int, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := bytes.NewBuffer([]byte{})
+			json.NewEncoder(body).Encode(&models.UserInfo{Username: "test", Password: "wrong"})
+			request := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/login", body)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	count := map[int]int{}
+	for code := range codes {
+		count[code]++
+	}
+	assert.LessOrEqual(t, count[http.StatusUnauthorized], configuration.LOGINMAXFAILURES)
+	assert.Equal(t, n, count[http.StatusUnauthorized]+count[http.StatusTooManyRequests])
+
+	attempts, err := cursor.GetLoginAttempts(ctx, configuration.LOGINSCOPEACCOUNT, "test", l)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, attempts.Failures, configuration.LOGINMAXFAILURES)
+}
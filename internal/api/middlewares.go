@@ -2,11 +2,20 @@ package api
 
 import (
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"compress/gzip"
+
+	"go.uber.org/zap"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/audit"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/auth"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/ratelimit"
 )
 
 type gzipWriter struct {
@@ -18,9 +27,21 @@ func (w gzipWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Flush pushes the compressed data written so far to the client, which
+// streaming handlers rely on.
+func (w gzipWriter) Flush() {
+	if gz, ok := w.Writer.(*gzip.Writer); ok {
+		gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func GzipHandle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		// upgraded connections such as websockets are not compressed here
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Header.Get("Upgrade") != "" {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -44,32 +65,111 @@ func GzipHandle(next http.Handler) http.Handler {
 	})
 }
 
-func (h *Handler) CookieHandle(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.Contains(r.URL.Path, "/api/user/register") || strings.Contains(r.URL.Path, "/api/user/login") {
-			next.ServeHTTP(w, r)
-		}
-		c, err := r.Cookie("session_token")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				w.WriteHeader(http.StatusUnauthorized)
+// Authenticate resolves who the request is made by, from a bearer token or
+// else the session cookie, and puts the principal in the request context.
+// The session of a bearer token is looked up as well, so revoking it or
+// changing the role of the user takes effect before the token expires.
+// Requests without valid credentials are refused, except the ones that
+// obtain them.
+func Authenticate(cursor *db.Cursor, keys *auth.Keyring, l *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.Contains(r.URL.Path, "/api/user/register") || strings.Contains(r.URL.Path, "/api/user/login") ||
+				strings.Contains(r.URL.Path, "/api/user/refresh") {
+				next.ServeHTTP(w, r)
 				return
 			}
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		sessionToken := c.Value
+			if token, ok := bearerToken(r); ok {
+				if keys == nil {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				claims, err := keys.VerifyToken(token)
+				if err != nil {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				session, err := cursor.GetSessionByID(r.Context(), claims.Subject, claims.Session, l)
+				if err != nil {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				principal := auth.NewPrincipal(session.Username, session.ID, session.Role)
+				next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+				return
+			}
+			c, err := r.Cookie("session_token")
+			if err != nil {
+				if err == http.ErrNoCookie {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sessionToken := c.Value
 
-		userSession, err := h.Cursor.GetSession(sessionToken, h.Logger)
+			userSession, err := cursor.GetSession(r.Context(), sessionToken, l)
 
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		if userSession.ExpiresAt.Before(time.Now()) {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if userSession.ExpiresAt.Before(time.Now()) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			principal := auth.NewPrincipal(userSession.Username, userSession.ID, userSession.Role)
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+		})
+	}
+}
+
+// Audit puts who makes the request and from where in its context, for the
+// audit log. It goes after Authenticate.
+func Audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := &audit.Actor{IP: clientIP(r), UserAgent: r.UserAgent()}
+		if principal, ok := auth.FromContext(r.Context()); ok {
+			actor.Username = principal.Username
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(audit.NewContext(r.Context(), actor)))
 	})
 }
+
+// RequireRole lets through only the requests of principals with the role.
+// It goes after Authenticate.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := requirePrincipal(w, r)
+			if !ok {
+				return
+			}
+			if !principal.HasRole(role) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimit refuses the requests of a user, or of an address before it logs
+// in, once they run out of tokens in limiter. It goes after Authenticate.
+func RateLimit(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "ip:" + clientIP(r)
+			if principal, ok := auth.FromContext(r.Context()); ok {
+				key = "user:" + principal.Username
+			}
+			if ok, wait := limiter.Allow(key, time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,25 +1,34 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/logger"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/MlDenis/diploma-wannabe-v2/internal/auth"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/jobmanager"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/mocks"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/ratelimit"
 
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 )
 
 func TestCookiesMiddleware(t *testing.T) {
 	l, _ := logger.InitializeLogger("info")
 	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
 	ctx := context.Background()
-	manager := jobmanager.NewJobmanager(cursor, "http://localhost:8081", &ctx)
-	handler := NewHandler(cursor, manager, l)
+	manager := jobmanager.NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l)
+	keys, _ := auth.NewRandomKeyring()
+	handler := NewHandler(cursor, manager, keys, nil, l)
 	ts := httptest.NewServer(handler)
 
 	defer ts.Close()
@@ -32,3 +41,156 @@ func TestCookiesMiddleware(t *testing.T) {
 	defer res.Body.Close()
 	assert.Equal(t, 401, res.StatusCode)
 }
+
+func TestBearerToken(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx := context.Background()
+	manager := jobmanager.NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l)
+	keys, _ := auth.NewRandomKeyring()
+	handler := NewHandler(cursor, manager, keys, nil, l)
+	cursor.SaveUserInfo(ctx, &models.UserInfo{Username: "test", Password: "test"}, l)
+	cursor.SaveUserBalance(ctx, "test", &models.Balance{User: "test", Current: models.NewMoney(500)}, l)
+
+	buff := bytes.NewBuffer([]byte{})
+	json.NewEncoder(buff).Encode(&models.UserInfo{Username: "test", Password: "test"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/login", buff))
+	assert.Equal(t, http.StatusOK, w.Code)
+	authorization := w.Header().Get("Authorization")
+	cookies := w.Result().Cookies()
+	assert.Contains(t, authorization, "Bearer ")
+
+	request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/balance", nil)
+	request.Header.Set("Authorization", authorization)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+	balance := &models.Balance{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(balance))
+	assert.Equal(t, models.NewMoney(500), balance.Current)
+
+	// a token signed with another key is refused even with a valid cookie
+	other, _ := auth.NewRandomKeyring()
+	forged, _ := other.IssueToken("test", 1, configuration.ROLEUSER, time.Minute)
+	request = httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/balance", nil)
+	request.Header.Set("Authorization", "Bearer "+forged)
+	request.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthenticatePrincipal(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	keys, _ := auth.NewRandomKeyring()
+	session := &models.Session{Username: "test", Token: "token", ExpiresAt: time.Now().Add(time.Minute)}
+	cursor.SaveSession(context.Background(), "token", session, l)
+
+	var principals []*auth.Principal
+	handler := Authenticate(cursor, keys, l)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		principal, ok := requirePrincipal(rw, r)
+		if ok {
+			principals = append(principals, principal)
+		}
+	}))
+
+	request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/balance", nil)
+	request.AddCookie(&http.Cookie{Name: "session_token", Value: "token"})
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	token, _ := keys.IssueToken("test", session.ID, configuration.ROLEUSER, time.Minute)
+	request = httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/balance", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	for _, principal := range principals {
+		assert.Equal(t, "test", principal.Username)
+		assert.Equal(t, session.ID, principal.Session)
+		assert.True(t, principal.HasRole(configuration.ROLEUSER))
+	}
+	assert.Len(t, principals, 2)
+
+	// handlers reached without the middleware refuse the request
+	w := httptest.NewRecorder()
+	(&BalanceRouter{Cursor: cursor, Logger: l}).GetBalance(w, httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/balance", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestBearerTokenFollowsSession(t *testing.T) {
+	l := zap.NewNop()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx := context.Background()
+	manager := jobmanager.NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l)
+	keys, _ := auth.NewRandomKeyring()
+	handler := NewHandler(cursor, manager, keys, nil, l)
+	cursor.SaveUserInfo(ctx, &models.UserInfo{Username: "admin", Password: "test"}, l)
+	cursor.SetUserRole(ctx, "admin", configuration.ROLEADMIN, l)
+	session := &models.Session{Username: "admin", Token: "admin", ExpiresAt: time.Now().Add(time.Minute)}
+	cursor.SaveSession(ctx, "admin", session, l)
+	token, _ := keys.IssueToken("admin", session.ID, configuration.ROLEADMIN, time.Minute)
+
+	do := func() int {
+		request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/admin/adjustments", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusNoContent, do())
+
+	// a demotion applies to the tokens issued before it
+	cursor.SetUserRole(ctx, "admin", configuration.ROLEUSER, l)
+	assert.Equal(t, http.StatusForbidden, do())
+	cursor.SetUserRole(ctx, "admin", configuration.ROLEADMIN, l)
+	assert.Equal(t, http.StatusNoContent, do())
+
+	// and so does revoking the session
+	cursor.DeleteSession(ctx, "admin", session.ID, l)
+	assert.Equal(t, http.StatusUnauthorized, do())
+}
+
+func TestRateLimit(t *testing.T) {
+	l := zap.NewNop()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx := context.Background()
+	manager := jobmanager.NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l)
+	handler := NewHandler(cursor, manager, nil, ratelimit.Limits{
+		configuration.RATELIMITAUTH:   {Rate: 0.1, Burst: 1},
+		configuration.RATELIMITORDERS: {Rate: 0.1, Burst: 2},
+	}, l)
+	for _, name := range []string{"test", "other"} {
+		cursor.SaveUserInfo(ctx, &models.UserInfo{Username: name, Password: "test"}, l)
+		cursor.SaveSession(ctx, name, &models.Session{Username: name, Token: name, ExpiresAt: time.Now().Add(time.Minute)}, l)
+	}
+
+	do := func(method string, url string, as string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "http://localhost:8080"+url, bytes.NewBufferString("{}"))
+		if as != "" {
+			request.AddCookie(&http.Cookie{Name: "session_token", Value: as})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.NotEqual(t, http.StatusTooManyRequests, do(http.MethodGet, "/api/user/orders", "test").Code)
+	}
+	w := do(http.MethodGet, "/api/user/orders", "test")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+
+	// users are limited apart, and each route group on its own
+	assert.NotEqual(t, http.StatusTooManyRequests, do(http.MethodGet, "/api/user/orders", "other").Code)
+	assert.NotEqual(t, http.StatusTooManyRequests, do(http.MethodGet, "/api/user/sessions", "test").Code)
+	// the balance has no limit here
+	for i := 0; i < 5; i++ {
+		assert.NotEqual(t, http.StatusTooManyRequests, do(http.MethodGet, "/api/user/balance", "test").Code)
+	}
+
+	// before logging in requests are limited by address
+	assert.NotEqual(t, http.StatusTooManyRequests, do(http.MethodPost, "/api/user/login", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/api/user/register", "").Code)
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"go.uber.org/zap"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
 
@@ -34,12 +36,11 @@ func (h *OrderRouter) UploadOrder(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cookie, _ := r.Cookie("session_token")
-	sessionToken := cookie.Value
-	username, err := h.Cursor.GetUsernameByToken(sessionToken, h.Logger)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
 	}
+	username := principal.Username
 
 	requestNumber := string(body)
 
@@ -53,7 +54,7 @@ func (h *OrderRouter) UploadOrder(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	order, err := GetOrderFromDB(h.Cursor, username, requestNumber, h.Logger)
+	order, err := GetOrderFromDB(r.Context(), h.Cursor, username, requestNumber, h.Logger)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
@@ -66,17 +67,18 @@ func (h *OrderRouter) UploadOrder(rw http.ResponseWriter, r *http.Request) {
 			UploadedAt: time.Now(),
 			Status:     "NEW",
 		}
-		err := ValidateOrder(h.Cursor, newOrder)
+		err := ValidateOrder(r.Context(), h.Cursor, newOrder)
 		if err != nil {
-			h.Logger.Error("Validation error for new order, token", zap.String("", sessionToken))
+			h.Logger.Error("Validation error for new order, user", zap.String("", username))
 			http.Error(rw, "order was uploaded already by another user", http.StatusConflict)
 			return
 		}
-		err = h.Cursor.SaveOrder(newOrder, h.Logger)
+		err = h.Cursor.SaveOrder(r.Context(), newOrder, h.Logger)
 		if err != nil {
 			return
 		}
-		err = h.Manager.AddJob(requestNumber, username)
+		recordAudit(r, h.Cursor, username, configuration.AUDITORDERUPLOADED, username, nil, newOrder, h.Logger)
+		err = h.Manager.AddJob(r.Context(), requestNumber, username)
 		if err != nil {
 			http.Error(rw, err.Error(), http.StatusInternalServerError)
 			return
@@ -91,7 +93,7 @@ func (h *OrderRouter) UploadOrder(rw http.ResponseWriter, r *http.Request) {
 
 	h.Logger.Info(order.Username)
 	if order.Username != username {
-		h.Logger.Error("Validation error for order, token", zap.String("", sessionToken))
+		h.Logger.Error("Validation error for order, user", zap.String("", username))
 		http.Error(rw, "order was uploaded already by another user", http.StatusConflict)
 		return
 	}
@@ -106,8 +108,8 @@ func (h *OrderRouter) UploadOrder(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func GetOrderFromDB(cursor *db.Cursor, username string, requestOrder string, l *zap.Logger) (*models.Order, error) {
-	order, err := cursor.GetOrder(username, requestOrder, l)
+func GetOrderFromDB(ctx context.Context, cursor *db.Cursor, username string, requestOrder string, l *zap.Logger) (*models.Order, error) {
+	order, err := cursor.GetOrder(ctx, username, requestOrder, l)
 	if order == nil {
 		return nil, err
 	}
@@ -115,18 +117,36 @@ func GetOrderFromDB(cursor *db.Cursor, username string, requestOrder string, l *
 }
 
 func (h *OrderRouter) GetOrders(rw http.ResponseWriter, r *http.Request) {
-	cookie, _ := r.Cookie("session_token")
-	sessionToken := cookie.Value
-	username, err := h.Cursor.GetUsernameByToken(sessionToken, h.Logger)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
 	}
+	writeOrders(rw, r, h.Cursor, principal.Username, h.Logger)
+}
 
-	orders, err := h.Cursor.GetOrders(username, h.Logger)
+// writeOrders answers with the page of the user's orders the query asks for.
+func writeOrders(rw http.ResponseWriter, r *http.Request, cursor *db.Cursor, username string, l *zap.Logger) {
+	query, err := ParseOrderQuery(r.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// one extra order tells whether there is a next page
+	page := *query
+	if query.Limit > 0 {
+		page.Limit = query.Limit + 1
+	}
+	orders, err := cursor.GetOrders(r.Context(), username, &page, l)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if query.Limit > 0 && len(orders) > query.Limit {
+		orders = orders[:query.Limit]
+		last := orders[len(orders)-1]
+		SetNextPage(rw, r, &models.PageCursor{Time: last.UploadedAt, Key: last.Number})
 	}
-	if orders == nil {
+	if len(orders) == 0 {
 		rw.WriteHeader(http.StatusNoContent)
 		_, err := rw.Write([]byte(`no orders found`))
 		if err != nil {
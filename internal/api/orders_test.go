@@ -2,8 +2,10 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/jobmanager"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/logger"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/mocks"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
@@ -40,7 +42,7 @@ func TestPostOrders(t *testing.T) {
 			},
 			args: arguments{
 				url:         "http://localhost:8080/api/user/orders",
-				number:      "14242412",
+				number:      "714683",
 				contentType: "text/plain",
 			},
 		},
@@ -52,7 +54,7 @@ func TestPostOrders(t *testing.T) {
 			},
 			args: arguments{
 				url:         "http://localhost:8080/api/user/orders",
-				number:      "14242412",
+				number:      "714683",
 				contentType: "text/plain",
 			},
 		},
@@ -64,7 +66,7 @@ func TestPostOrders(t *testing.T) {
 			},
 			args: arguments{
 				url:         "http://localhost:8080/api/user/orders",
-				number:      "714683",
+				number:      "14242412",
 				contentType: "text/plain",
 			},
 		},
@@ -76,7 +78,7 @@ func TestPostOrders(t *testing.T) {
 			},
 			args: arguments{
 				url:         "http://localhost:8080/api/user/orders",
-				number:      "14242412",
+				number:      "714683",
 				contentType: "text/plain",
 			},
 		},
@@ -96,31 +98,30 @@ func TestPostOrders(t *testing.T) {
 
 	l, _ := logger.InitializeLogger("info")
 
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
 	handler := &Handler{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
+	ctx := context.Background()
 	r := &OrderRouter{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:     chi.NewMux(),
+		Cursor:  cursor,
+		Manager: jobmanager.NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l),
+		Logger:  l,
 	}
 	ur := &UserRouter{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
 
+	handler.Use(Authenticate(cursor, nil, l))
 	handler.Post("/api/user/register", ur.RegisterUser)
 	handler.Post("/api/user/login", ur.Login)
 	handler.Post("/api/user/orders", r.UploadOrder)
 	ts := httptest.NewServer(handler)
 
-	handler.Cursor.SaveUserInfo(&models.UserInfo{
+	handler.Cursor.SaveUserInfo(context.Background(), &models.UserInfo{
 		Username: "test",
 		Password: "test",
 	}, l)
@@ -231,52 +232,62 @@ func TestGetOrders(t *testing.T) {
 	orders := []*models.Order{
 		{
 			Number:     "9278923470",
+			Username:   "test",
 			Status:     "PROCESSED",
-			Accrual:    500,
+			Accrual:    models.NewMoney(500),
 			UploadedAt: parseTime(layout, "2020-12-10T15:15:45+03:00"),
 		},
 		{
 			Number:     "12345678903",
+			Username:   "test",
 			Status:     "PROCESSING",
 			UploadedAt: parseTime(layout, "2020-12-10T15:12:01+03:00"),
 		},
 		{
 			Number:     "346436439",
+			Username:   "test",
 			Status:     "INVALID",
 			UploadedAt: parseTime(layout, "2020-12-09T16:09:53+03:00"),
 		},
 		{
 			Number:     "3464364393333",
+			Username:   "test",
 			Status:     "NEW",
 			UploadedAt: parseTime(layout, "2020-12-09T16:09:53+03:00"),
 		},
 	}
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
 	handler := &Handler{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
+	l, _ := logger.InitializeLogger("info")
 	r := &OrderRouter{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
+		Logger: l,
 	}
+	handler.Use(Authenticate(cursor, nil, l))
 	handler.Get("/api/user/orders", r.GetOrders)
 	ts := httptest.NewServer(handler)
 
+	cursor.SaveSession(context.Background(), "token", &models.Session{
+		Username:  "test",
+		Token:     "token",
+		ExpiresAt: time.Now().Add(time.Minute),
+	}, l)
+
 	defer ts.Close()
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			l, _ := logger.InitializeLogger("info")
 			request := httptest.NewRequest(http.MethodGet, tt.args.url, nil)
+			request.AddCookie(&http.Cookie{Name: "session_token", Value: "token"})
 
 			w := httptest.NewRecorder()
 			if tt.name == "Test Positive order get" {
 				for _, order := range orders {
-					handler.Cursor.SaveOrder(order, l)
+					handler.Cursor.SaveOrder(context.Background(), order, l)
 				}
 			}
 			handler.ServeHTTP(w, request)
@@ -293,3 +304,71 @@ func TestGetOrders(t *testing.T) {
 		})
 	}
 }
+
+func TestGetOrdersPages(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	r := &OrderRouter{
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
+		Logger: l,
+	}
+	r.Use(Authenticate(cursor, nil, l))
+	r.Get("/api/user/orders", r.GetOrders)
+
+	cursor.SaveSession(context.Background(), "token", &models.Session{
+		Username:  "test",
+		Token:     "token",
+		ExpiresAt: time.Now().Add(time.Minute),
+	}, l)
+	start := time.Date(2020, 12, 9, 16, 9, 53, 0, time.UTC)
+	for i, number := range []string{"9278923470", "12345678903", "346436439"} {
+		cursor.SaveOrder(context.Background(), &models.Order{
+			Number:     number,
+			Username:   "test",
+			Status:     "NEW",
+			UploadedAt: start.Add(time.Duration(i) * time.Hour),
+		}, l)
+	}
+
+	get := func(url string) (*http.Response, []*models.Order) {
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		request.AddCookie(&http.Cookie{Name: "session_token", Value: "token"})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request)
+		res := w.Result()
+		defer res.Body.Close()
+		var orders []*models.Order
+		json.NewDecoder(res.Body).Decode(&orders)
+		return res, orders
+	}
+
+	res, orders := get("/api/user/orders?limit=2&status=NEW")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, orders, 2)
+	assert.Equal(t, "346436439", orders[0].Number)
+	assert.Equal(t, "12345678903", orders[1].Number)
+	next := res.Header.Get("X-Next-Cursor")
+	assert.NotEmpty(t, next)
+	assert.Equal(t, `</api/user/orders?after=`+next+`&limit=2&status=NEW>; rel="next"`, res.Header.Get("Link"))
+
+	res, orders = get("/api/user/orders?limit=2&status=NEW&after=" + next)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, orders, 1)
+	assert.Equal(t, "9278923470", orders[0].Number)
+	assert.Empty(t, res.Header.Get("Link"))
+
+	res, _ = get("/api/user/orders?status=PROCESSED")
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	for _, url := range []string{
+		"/api/user/orders?limit=0",
+		"/api/user/orders?after=broken",
+		"/api/user/orders?status=DONE",
+		"/api/user/orders?from=yesterday",
+		"/api/user/orders?sort=number",
+	} {
+		res, _ = get(url)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, url)
+	}
+}
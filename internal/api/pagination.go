@@ -0,0 +1,132 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+// ParseOrderQuery reads the page of orders requested with the limit, after,
+// status, from, to and sort parameters. Without limit every order is returned
+// as the API always did.
+func ParseOrderQuery(values url.Values) (*models.OrderQuery, error) {
+	query := &models.OrderQuery{}
+	limit, after, err := parsePage(values)
+	if err != nil {
+		return nil, err
+	}
+	query.Limit, query.After = limit, after
+
+	for _, value := range values["status"] {
+		for _, status := range strings.Split(value, ",") {
+			switch status {
+			case configuration.NEW, configuration.PROCESSING, configuration.INVALID, configuration.PROCESSED:
+				query.Statuses = append(query.Statuses, status)
+			default:
+				return nil, fmt.Errorf("unknown status %q", status)
+			}
+		}
+	}
+	if query.From, query.To, err = parseRange(values); err != nil {
+		return nil, err
+	}
+
+	switch values.Get("sort") {
+	case "", "-uploaded_at":
+	case "uploaded_at":
+		query.Ascending = true
+	default:
+		return nil, fmt.Errorf("unknown sort %q", values.Get("sort"))
+	}
+	return query, nil
+}
+
+// ParseWithdrawalQuery reads the page of withdrawals requested with the limit,
+// after, from and to parameters.
+func ParseWithdrawalQuery(values url.Values) (*models.WithdrawalQuery, error) {
+	query := &models.WithdrawalQuery{}
+	limit, after, err := parsePage(values)
+	if err != nil {
+		return nil, err
+	}
+	query.Limit, query.After = limit, after
+	if query.From, query.To, err = parseRange(values); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// ParseAuditQuery reads the page of the audit log requested with the limit,
+// after, actor, subject, action, from and to parameters.
+func ParseAuditQuery(values url.Values) (*models.AuditQuery, error) {
+	query := &models.AuditQuery{
+		Actor:   values.Get("actor"),
+		Subject: values.Get("subject"),
+		Action:  values.Get("action"),
+	}
+	limit, after, err := parsePage(values)
+	if err != nil {
+		return nil, err
+	}
+	query.Limit, query.After = limit, after
+	if query.From, query.To, err = parseRange(values); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+func parsePage(values url.Values) (int, *models.PageCursor, error) {
+	limit := 0
+	if raw := values.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			return 0, nil, fmt.Errorf("invalid limit %q", raw)
+		}
+		limit = parsed
+		if limit > configuration.MAXPAGELIMIT {
+			limit = configuration.MAXPAGELIMIT
+		}
+	}
+	if raw := values.Get("after"); raw != "" {
+		after, err := models.DecodePageCursor(raw)
+		if err != nil {
+			return 0, nil, err
+		}
+		return limit, after, nil
+	}
+	return limit, nil, nil
+}
+
+// parseRange reads the from (inclusive) and to (exclusive) RFC3339 bounds.
+func parseRange(values url.Values) (time.Time, time.Time, error) {
+	var bounds [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		raw := values.Get(name)
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid %s %q", name, raw)
+		}
+		bounds[i] = parsed
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// SetNextPage points the client to the page after cursor, keeping the rest
+// of the request parameters.
+func SetNextPage(rw http.ResponseWriter, r *http.Request, cursor *models.PageCursor) {
+	token := cursor.Encode()
+	values := r.URL.Query()
+	values.Set("after", token)
+	next := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+	rw.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+	rw.Header().Set("X-Next-Cursor", token)
+}
@@ -3,10 +3,10 @@ package api
 import (
 	"encoding/json"
 	"net/http"
-	"time"
 
+	"github.com/MlDenis/diploma-wannabe-v2/internal/auth"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
-	"github.com/google/uuid"
 )
 
 func (h *UserRouter) RegisterUser(rw http.ResponseWriter, r *http.Request) {
@@ -19,35 +19,36 @@ func (h *UserRouter) RegisterUser(rw http.ResponseWriter, r *http.Request) {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := h.Cursor.SaveUserInfo(userInput, h.Logger); err != nil {
-		http.Error(rw, "user already exists", http.StatusConflict)
+	if err := ValidatePassword(userInput.Password); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-	sessionToken := uuid.NewString()
-	expiresAt := time.Now().Add(600 * time.Second)
-
-	err := h.Cursor.SaveSession(sessionToken, &models.Session{
-		Username:  userInput.Username,
-		ExpiresAt: expiresAt,
-		Token:     sessionToken,
-	}, h.Logger)
+	hash, err := auth.HashPassword(userInput.Password)
 	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.Cursor.SaveUserInfo(r.Context(), &models.UserInfo{
+		Username: userInput.Username,
+		Password: hash,
+	}, h.Logger); err != nil {
+		http.Error(rw, "user already exists", http.StatusConflict)
 		return
 	}
-	_, err = h.Cursor.SaveUserBalance(userInput.Username, &models.Balance{
+	recordAudit(r, h.Cursor, userInput.Username, configuration.AUDITREGISTERED, userInput.Username, nil,
+		&models.RolePost{Role: configuration.ROLEUSER}, h.Logger)
+	_, err = h.Cursor.SaveUserBalance(r.Context(), userInput.Username, &models.Balance{
 		User:      userInput.Username,
-		Current:   0.0,
-		Withdrawn: 0.0,
+		Current:   0,
+		Withdrawn: 0,
 	}, h.Logger)
 	if err != nil {
 		return
 	}
-
-	http.SetCookie(rw, &http.Cookie{
-		Name:    "session_token",
-		Value:   sessionToken,
-		Expires: expiresAt,
-	})
+	if err := h.startSession(rw, r, userInput.Username, configuration.ROLEUSER); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	rw.WriteHeader(http.StatusOK)
 	_, err = rw.Write([]byte(`user created successfully`))
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -70,6 +71,34 @@ func TestRegistration(t *testing.T) {
 				payload: userinfo{},
 			},
 		},
+		{
+			name: "Test Positive registration longest password",
+			want: want{
+				code:     200,
+				response: `user created successfully`,
+			},
+			args: arguments{
+				url: "http://localhost:8080/api/user/register",
+				payload: userinfo{
+					Username: "longest",
+					Password: strings.Repeat("p", 72),
+				},
+			},
+		},
+		{
+			name: "Test Negative registration password too long",
+			want: want{
+				code:     400,
+				response: "password is longer than 72 bytes\n",
+			},
+			args: arguments{
+				url: "http://localhost:8080/api/user/register",
+				payload: userinfo{
+					Username: "long",
+					Password: strings.Repeat("p", 73),
+				},
+			},
+		},
 		{
 			name: "Test Negative registration user exists and different password",
 			want: want{
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/auth"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+// newSession makes a session for the device the request came from along
+// with the refresh token to hand to it.
+func newSession(r *http.Request, username string, role string) (*models.Session, string, error) {
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	return &models.Session{
+		Username:         username,
+		Token:            uuid.NewString(),
+		ExpiresAt:        now.Add(configuration.SESSIONTTL * time.Second),
+		RefreshToken:     refreshHash,
+		RefreshExpiresAt: now.Add(configuration.REFRESHTOKENTTL * time.Second),
+		CreatedAt:        now,
+		UserAgent:        r.UserAgent(),
+		IP:               clientIP(r),
+		Role:             role,
+	}, refreshToken, nil
+}
+
+func (h *UserRouter) startSession(rw http.ResponseWriter, r *http.Request, username string, role string) error {
+	session, refreshToken, err := newSession(r, username, role)
+	if err != nil {
+		return err
+	}
+	if err := h.Cursor.SaveSession(r.Context(), session.Token, session, h.Logger); err != nil {
+		return err
+	}
+	recordAudit(r, h.Cursor, username, configuration.AUDITSESSIONCREATED, username, nil, session, h.Logger)
+	h.setSessionTokens(rw, session, refreshToken)
+	return nil
+}
+
+// setSessionTokens hands the tokens of the session to the client: cookies
+// for browsers, headers for the other clients.
+func (h *UserRouter) setSessionTokens(rw http.ResponseWriter, session *models.Session, refreshToken string) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:    "session_token",
+		Value:   session.Token,
+		Expires: session.ExpiresAt,
+	})
+	http.SetCookie(rw, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		Path:     "/api/user",
+		Expires:  session.RefreshExpiresAt,
+		HttpOnly: true,
+	})
+	rw.Header().Set("X-Refresh-Token", refreshToken)
+	h.issueAccessToken(rw, session)
+}
+
+func clearSessionTokens(rw http.ResponseWriter) {
+	http.SetCookie(rw, &http.Cookie{Name: "session_token", MaxAge: -1})
+	http.SetCookie(rw, &http.Cookie{Name: "refresh_token", Path: "/api/user", MaxAge: -1})
+}
+
+// Refresh renews the tokens of a session. The refresh token comes in the
+// X-Refresh-Token header or the refresh_token cookie and is replaced with a
+// new one.
+func (h *UserRouter) Refresh(rw http.ResponseWriter, r *http.Request) {
+	refreshToken := r.Header.Get("X-Refresh-Token")
+	if refreshToken == "" {
+		if cookie, err := r.Cookie("refresh_token"); err == nil {
+			refreshToken = cookie.Value
+		}
+	}
+	if refreshToken == "" {
+		http.Error(rw, "no refresh token", http.StatusUnauthorized)
+		return
+	}
+	session, newRefreshToken, err := newSession(r, "", "")
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = h.Cursor.RefreshSession(r.Context(), auth.HashRefreshToken(refreshToken), session, h.Logger)
+	if err == errors.ErrNotFound {
+		http.Error(rw, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.setSessionTokens(rw, session, newRefreshToken)
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte(`success`))
+}
+
+// Logout ends the session the request was made with. Access tokens issued
+// for it are refused from then on, as they are only honoured while their
+// session exists.
+func (h *UserRouter) Logout(rw http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	err := h.Cursor.DeleteSession(r.Context(), principal.Username, principal.Session, h.Logger)
+	if err != nil && err != errors.ErrNotFound {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	clearSessionTokens(rw)
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte(`logged out`))
+}
+
+// GetSessions lists the active sessions of the user, newest first, marking
+// the one the request was made with.
+func (h *UserRouter) GetSessions(rw http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	sessions, err := h.Cursor.GetSessions(r.Context(), principal.Username, h.Logger)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(sessions) == 0 {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	for _, session := range sessions {
+		session.Current = session.ID == principal.Session
+	}
+	writeJSON(rw, http.StatusOK, sessions)
+}
+
+// RevokeSession ends one of the user's sessions, usually on another device.
+func (h *UserRouter) RevokeSession(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(rw, "invalid session id", http.StatusBadRequest)
+		return
+	}
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	username := principal.Username
+	err = h.Cursor.DeleteSession(r.Context(), username, id, h.Logger)
+	if err == errors.ErrNotFound {
+		http.Error(rw, "session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.Cursor, username, configuration.AUDITSESSIONREVOKED, strconv.FormatInt(id, 10), nil, nil, h.Logger)
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/auth"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/jobmanager"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/mocks"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+func TestSessions(t *testing.T) {
+	l := zap.NewNop()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx := context.Background()
+	manager := jobmanager.NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l)
+	keys, _ := auth.NewRandomKeyring()
+	handler := NewHandler(cursor, manager, keys, nil, l)
+	cursor.SaveUserInfo(ctx, &models.UserInfo{Username: "test", Password: "test"}, l)
+
+	do := func(method string, url string, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		body := bytes.NewBuffer([]byte{})
+		if method == http.MethodPost && url == "/api/user/login" {
+			json.NewEncoder(body).Encode(&models.UserInfo{Username: "test", Password: "test"})
+		}
+		request := httptest.NewRequest(method, "http://localhost:8080"+url, body)
+		for key := range header {
+			request.Header.Set(key, header.Get(key))
+		}
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+
+	laptop := do(http.MethodPost, "/api/user/login", http.Header{"User-Agent": {"laptop"}})
+	require.Equal(t, http.StatusOK, laptop.Code)
+	phone := do(http.MethodPost, "/api/user/login", http.Header{"User-Agent": {"phone"}})
+	require.Equal(t, http.StatusOK, phone.Code)
+	refreshToken := phone.Header().Get("X-Refresh-Token")
+	assert.NotEmpty(t, refreshToken)
+
+	// the refresh token works once and gives new tokens
+	refreshed := do(http.MethodPost, "/api/user/refresh", http.Header{"X-Refresh-Token": {refreshToken}})
+	require.Equal(t, http.StatusOK, refreshed.Code)
+	assert.NotEqual(t, refreshToken, refreshed.Header().Get("X-Refresh-Token"))
+	assert.NotEqual(t, phone.Result().Cookies()[0].Value, refreshed.Result().Cookies()[0].Value)
+	res := do(http.MethodPost, "/api/user/refresh", http.Header{"X-Refresh-Token": {refreshToken}})
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	res = do(http.MethodGet, "/api/user/balance", nil, phone.Result().Cookies()[0])
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	res = do(http.MethodPost, "/api/user/refresh", nil, refreshed.Result().Cookies()[1])
+	require.Equal(t, http.StatusOK, res.Code)
+	bearer := http.Header{"Authorization": {res.Header().Get("Authorization")}}
+
+	res = do(http.MethodGet, "/api/user/sessions", bearer)
+	require.Equal(t, http.StatusOK, res.Code)
+	sessions := []*models.Session{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&sessions))
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "phone", sessions[0].UserAgent)
+	assert.True(t, sessions[0].Current)
+	assert.Equal(t, "laptop", sessions[1].UserAgent)
+	assert.False(t, sessions[1].Current)
+	assert.NotEmpty(t, sessions[1].IP)
+
+	// the laptop is revoked from the phone
+	res = do(http.MethodDelete, "/api/user/sessions/"+strconv.FormatInt(sessions[1].ID, 10), bearer)
+	assert.Equal(t, http.StatusNoContent, res.Code)
+	res = do(http.MethodDelete, "/api/user/sessions/"+strconv.FormatInt(sessions[1].ID, 10), bearer)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	entries, err := cursor.GetAuditLog(ctx, &models.AuditQuery{Action: configuration.AUDITSESSIONREVOKED}, l)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "test", entries[0].Actor)
+	assert.Equal(t, strconv.FormatInt(sessions[1].ID, 10), entries[0].Subject)
+	res = do(http.MethodGet, "/api/user/balance", nil, laptop.Result().Cookies()[0])
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	res = do(http.MethodPost, "/api/user/refresh", http.Header{"X-Refresh-Token": {laptop.Header().Get("X-Refresh-Token")}})
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	res = do(http.MethodPost, "/api/user/logout", bearer)
+	assert.Equal(t, http.StatusOK, res.Code)
+	found, err := cursor.GetSessions(ctx, "test", l)
+	require.NoError(t, err)
+	assert.Empty(t, found)
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/events"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// StreamOrders sends the user's order updates as Server-Sent Events until
+// the client goes away. A comment line is sent every SSEHEARTBEAT seconds so
+// that proxies keep the connection open.
+func (h *OrderRouter) StreamOrders(rw http.ResponseWriter, r *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	username := principal.Username
+
+	updates, unsubscribe := h.Manager.Events.Subscribe(username)
+	defer unsubscribe()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(configuration.SSEHEARTBEAT * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(rw, ": ping\n\n"); err != nil {
+				return
+			}
+		case event := <-updates:
+			if event.Type != configuration.ORDERUPDATED {
+				continue
+			}
+			if err := writeEvent(rw, event); err != nil {
+				h.Logger.Error("Error writing order event", zap.Error(err))
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w io.Writer, event events.Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
+
+// StreamBalance upgrades the request to a websocket and sends the user's
+// balance as JSON, first on connect and then after every credit or debit.
+// Messages from the client are ignored. The connection is pinged every
+// WSPINGINTERVAL seconds and dropped if the client stops answering.
+func (h *BalanceRouter) StreamBalance(rw http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	username := principal.Username
+
+	// subscribe before reading the balance, so no change is missed in between
+	updates, unsubscribe := h.Events.Subscribe(username)
+	defer unsubscribe()
+	balance, err := h.Cursor.GetUserBalance(r.Context(), username, h.Logger)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		// the upgrader has already replied to the client
+		h.Logger.Info("Websocket upgrade failed", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	pingInterval := configuration.WSPINGINTERVAL * time.Second
+	conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	})
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(balance interface{}) error {
+		conn.SetWriteDeadline(time.Now().Add(configuration.WSWRITETIMEOUT * time.Second))
+		return conn.WriteJSON(balance)
+	}
+	if err := send(balance); err != nil {
+		return
+	}
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+				time.Now().Add(configuration.WSWRITETIMEOUT*time.Second))
+			return
+		case <-closed:
+			return
+		case <-ping.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(configuration.WSWRITETIMEOUT*time.Second))
+			if err != nil {
+				return
+			}
+		case event := <-updates:
+			if event.Type != configuration.BALANCEUPDATED {
+				continue
+			}
+			if err := send(event.Data); err != nil {
+				h.Logger.Error("Error writing balance update", zap.Error(err))
+				return
+			}
+		}
+	}
+}
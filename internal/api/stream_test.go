@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/events"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/jobmanager"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/logger"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/mocks"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/outbox"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamOrders(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	ctx := context.Background()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	r := &OrderRouter{
+		Mux:     chi.NewMux(),
+		Cursor:  cursor,
+		Manager: jobmanager.NewJobmanager(cursor, "", 1, &ctx, l),
+		Logger:  l,
+	}
+	r.Use(GzipHandle)
+	r.Use(Authenticate(cursor, nil, l))
+	r.Get("/api/user/orders/stream", r.StreamOrders)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	cursor.SaveSession(ctx, "token", &models.Session{
+		Username:  "test",
+		Token:     "token",
+		ExpiresAt: time.Now().Add(time.Minute),
+	}, l)
+
+	for _, encoding := range []string{"identity", "gzip"} {
+		t.Run(encoding, func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/user/orders/stream", nil)
+			request.AddCookie(&http.Cookie{Name: "session_token", Value: "token"})
+			request.Header.Set("Accept-Encoding", encoding)
+			res, err := ts.Client().Do(request)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+			var body io.Reader = res.Body
+			if encoding == "gzip" {
+				gz, err := gzip.NewReader(res.Body)
+				require.NoError(t, err)
+				body = gz
+			}
+
+			r.Manager.Events.Publish(events.Event{Type: configuration.ORDERUPDATED, User: "other", Data: &models.Order{Number: "346436439"}})
+			r.Manager.Events.Publish(events.Event{Type: configuration.ORDERUPDATED, User: "test", Data: &models.Order{
+				Number:  "9278923470",
+				Status:  configuration.PROCESSED,
+				Accrual: models.NewMoney(500),
+			}})
+
+			reader := bufio.NewReader(body)
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, "event: order.updated\n", line)
+			line, err = reader.ReadString('\n')
+			require.NoError(t, err)
+			assert.Contains(t, line, `"number":"9278923470"`)
+			assert.Contains(t, line, `"status":"PROCESSED"`)
+		})
+	}
+}
+
+func TestStreamBalance(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	ctx := context.Background()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	br := &BalanceRouter{
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
+		Events: events.NewBus(),
+		Logger: l,
+	}
+	br.Use(GzipHandle)
+	br.Use(Authenticate(cursor, nil, l))
+	br.Get("/api/user/balance/ws", br.StreamBalance)
+	br.Post("/api/user/balance/withdraw", br.WithdrawMoney)
+	ts := httptest.NewServer(br)
+	defer ts.Close()
+
+	cursor.SaveSession(ctx, "token", &models.Session{
+		Username:  "test",
+		Token:     "token",
+		ExpiresAt: time.Now().Add(time.Minute),
+	}, l)
+	cursor.AppendLedgerEntry(ctx, &models.LedgerEntry{
+		User:   "test",
+		Kind:   configuration.CREDIT,
+		Amount: models.NewMoney(100),
+		Order:  "9278923470",
+	}, l)
+	relay := outbox.NewRelay(cursor, l, br.Events)
+	relay.Dispatch(ctx)
+
+	header := http.Header{}
+	header.Set("Cookie", "session_token=token")
+	header.Set("Accept-Encoding", "gzip")
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/user/balance/ws"
+	conn, res, err := websocket.DefaultDialer.Dial(url, header)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+
+	balance := &models.Balance{}
+	require.NoError(t, conn.ReadJSON(balance))
+	assert.Equal(t, models.NewMoney(100), balance.Current)
+
+	// order updates are not sent over this channel
+	br.Events.Publish(events.Event{Type: configuration.ORDERUPDATED, User: "test", Data: &models.Order{Number: "9278923470"}})
+
+	request, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/user/balance/withdraw",
+		bytes.NewBufferString(`{"order": "2377225624", "sum": 40.5}`))
+	request.AddCookie(&http.Cookie{Name: "session_token", Value: "token"})
+	withdraw, err := ts.Client().Do(request)
+	require.NoError(t, err)
+	withdraw.Body.Close()
+	assert.Equal(t, http.StatusOK, withdraw.StatusCode)
+	relay.Dispatch(ctx)
+
+	balance = &models.Balance{}
+	require.NoError(t, conn.ReadJSON(balance))
+	assert.Equal(t, models.NewMoney(59.5), balance.Current)
+	assert.Equal(t, models.NewMoney(40.5), balance.Withdrawn)
+}
@@ -1,8 +1,15 @@
 package api
 
 import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/auth"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/jobmanager"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
 )
 
@@ -13,17 +20,26 @@ func ValidateUserInfo(input *models.UserInfo) error {
 	return nil
 }
 
+// ValidatePassword refuses passwords bcrypt can not hash, it only reads the
+// first PASSWORDMAXLENGTH bytes.
+func ValidatePassword(password string) error {
+	if len(password) > configuration.PASSWORDMAXLENGTH {
+		return errors.ErrPasswordTooLong
+	}
+	return nil
+}
+
 func ValidateLogin(input *models.UserInfo, existingInfo *models.UserInfo) error {
 	if input.Username == existingInfo.Username {
-		if input.Password == existingInfo.Password {
+		if auth.VerifyPassword(existingInfo.Password, input.Password) {
 			return nil
 		}
 	}
 	return errors.ErrValidation
 }
 
-func ValidateOrder(cursor *db.Cursor, newOrder *models.Order) error {
-	orders, err := cursor.GetAllOrders()
+func ValidateOrder(ctx context.Context, cursor *db.Cursor, newOrder *models.Order) error {
+	orders, err := cursor.GetAllOrders(ctx)
 	if err != nil {
 		return err
 	}
@@ -34,3 +50,37 @@ func ValidateOrder(cursor *db.Cursor, newOrder *models.Order) error {
 	}
 	return nil
 }
+
+// ValidateAdjustment requires a non-zero amount, a known reason code and a
+// reason for it.
+func ValidateAdjustment(adjustment *models.AdjustmentPost) error {
+	if adjustment.Amount == 0 || strings.TrimSpace(adjustment.Reason) == "" {
+		return errors.ErrValidation
+	}
+	switch adjustment.ReasonCode {
+	case configuration.ACCRUALCORRECTION, configuration.GOODWILL, configuration.FRAUDREVERSAL, configuration.OTHERREASON:
+		return nil
+	}
+	return errors.ErrValidation
+}
+
+// ValidateWebhook accepts absolute http(s) URLs and secrets that fit the
+// webhooks table. Hosts that resolve to an address webhooks are not delivered
+// to are refused with ErrForbiddenAddress.
+func ValidateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	parsed, err := url.Parse(webhook.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return errors.ErrValidation
+	}
+	if len(webhook.Secret) > 128 {
+		return errors.ErrValidation
+	}
+	err = jobmanager.CheckWebhookHost(ctx, parsed.Hostname())
+	if err == errors.ErrForbiddenAddress {
+		return err
+	}
+	if err != nil {
+		return errors.ErrValidation
+	}
+	return nil
+}
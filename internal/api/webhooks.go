@@ -0,0 +1,152 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// CreateWebhook registers a URL for the user's order and withdrawal events.
+// Without a secret in the request one is generated. The secret is returned
+// only here.
+func (h *WebhookRouter) CreateWebhook(rw http.ResponseWriter, r *http.Request) {
+	webhook := &models.Webhook{}
+	if err := json.NewDecoder(r.Body).Decode(webhook); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err := ValidateWebhook(r.Context(), webhook)
+	if err == errors.ErrForbiddenAddress {
+		http.Error(rw, "webhook url is not publicly reachable", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "invalid webhook url or secret", http.StatusBadRequest)
+		return
+	}
+
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	username := principal.Username
+
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+	webhook.ID = 0
+	webhook.User = username
+	webhook.CreatedAt = time.Now()
+	if err := h.Cursor.SaveWebhook(r.Context(), webhook, h.Logger); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(rw, http.StatusCreated, webhook)
+}
+
+func (h *WebhookRouter) GetWebhooks(rw http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	username := principal.Username
+	webhooks, err := h.Cursor.GetWebhooks(r.Context(), username, h.Logger)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(webhooks) == 0 {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+	}
+	writeJSON(rw, http.StatusOK, webhooks)
+}
+
+func (h *WebhookRouter) DeleteWebhook(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(rw, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	username := principal.Username
+	err = h.Cursor.DeleteWebhook(r.Context(), username, id, h.Logger)
+	if err == errors.ErrNotFound {
+		http.Error(rw, "webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// GetDeliveries returns the delivery log of one of the user's webhooks,
+// newest first.
+func (h *WebhookRouter) GetDeliveries(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(rw, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	username := principal.Username
+	webhooks, err := h.Cursor.GetWebhooks(r.Context(), username, h.Logger)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	found := false
+	for _, webhook := range webhooks {
+		found = found || webhook.ID == id
+	}
+	if !found {
+		http.Error(rw, "webhook not found", http.StatusNotFound)
+		return
+	}
+	deliveries, err := h.Cursor.GetDeliveries(r.Context(), username, id, h.Logger)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(deliveries) == 0 {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(rw, http.StatusOK, deliveries)
+}
+
+func writeJSON(rw http.ResponseWriter, status int, value interface{}) {
+	buff := bytes.NewBuffer([]byte{})
+	if err := json.NewEncoder(buff).Encode(value); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_, _ = rw.Write(buff.Bytes())
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/jobmanager"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/logger"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/mocks"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/outbox"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhooks(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	ctx := context.Background()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	handler := chi.NewMux()
+	handler.Use(Authenticate(cursor, nil, l))
+	handler.Mount("/api/user/webhooks", NewWebhooksRouter(cursor, l))
+	br := &BalanceRouter{
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
+		Logger: l,
+	}
+	relay := outbox.NewRelay(cursor, l, jobmanager.NewWebhookDispatcher(cursor, l))
+	handler.Post("/api/user/balance/withdraw", br.WithdrawMoney)
+
+	cursor.SaveSession(ctx, "token", &models.Session{
+		Username:  "test",
+		Token:     "token",
+		ExpiresAt: time.Now().Add(time.Minute),
+	}, l)
+	cursor.AppendLedgerEntry(ctx, &models.LedgerEntry{
+		User:   "test",
+		Kind:   configuration.CREDIT,
+		Amount: models.NewMoney(100),
+		Order:  "9278923470",
+	}, l)
+
+	do := func(method string, url string, body string) *http.Response {
+		request := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		request.AddCookie(&http.Cookie{Name: "session_token", Value: "token"})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w.Result()
+	}
+
+	for _, body := range []string{`{"url": "ftp://shop.example"}`, `{"url": "/hooks"}`, `not json`} {
+		res := do(http.MethodPost, "/api/user/webhooks", body)
+		res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, body)
+	}
+	// hooks into the network of the service are refused
+	for _, url := range []string{"http://127.0.0.1:8080/api", "http://localhost/hooks", "http://10.0.0.1/hooks",
+		"http://169.254.169.254/latest/meta-data", "http://[::1]/hooks", "http://[::ffff:192.168.0.1]/hooks"} {
+		res := do(http.MethodPost, "/api/user/webhooks", `{"url": "`+url+`"}`)
+		res.Body.Close()
+		assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode, url)
+	}
+	res := do(http.MethodGet, "/api/user/webhooks", "")
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	res = do(http.MethodPost, "/api/user/webhooks", `{"url": "https://203.0.113.7/hooks"}`)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	created := &models.Webhook{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(created))
+	res.Body.Close()
+	assert.NotZero(t, created.ID)
+	assert.Len(t, created.Secret, 64)
+	id := strconv.FormatInt(created.ID, 10)
+
+	res = do(http.MethodGet, "/api/user/webhooks", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var webhooks []*models.Webhook
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&webhooks))
+	res.Body.Close()
+	require.Len(t, webhooks, 1)
+	assert.Equal(t, "https://203.0.113.7/hooks", webhooks[0].URL)
+	assert.Empty(t, webhooks[0].Secret)
+
+	res = do(http.MethodPost, "/api/user/balance/withdraw", `{"order": "2377225624", "sum": 40}`)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	relay.Dispatch(ctx)
+	res = do(http.MethodGet, "/api/user/webhooks/"+id+"/deliveries", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var deliveries []*models.WebhookDelivery
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&deliveries))
+	res.Body.Close()
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, configuration.WITHDRAWALCREATED, deliveries[0].EventType)
+	assert.Equal(t, configuration.DELIVERYPENDING, deliveries[0].Status)
+
+	res = do(http.MethodGet, "/api/user/webhooks/42/deliveries", "")
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res = do(http.MethodDelete, "/api/user/webhooks/"+id, "")
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = do(http.MethodDelete, "/api/user/webhooks/"+id, "")
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
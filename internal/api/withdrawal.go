@@ -4,9 +4,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/theplant/luhn"
+	"go.uber.org/zap"
 )
 
 func (h *BalanceRouter) WithdrawMoney(rw http.ResponseWriter, r *http.Request) {
@@ -16,41 +22,35 @@ func (h *BalanceRouter) WithdrawMoney(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cookie, _ := r.Cookie("session_token")
-	sessionToken := cookie.Value
-	username, err := h.Cursor.GetUsernameByToken(sessionToken, h.Logger)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	n, err := strconv.Atoi(withrawal.Order)
+	if err != nil || !luhn.Valid(n) {
+		http.Error(rw, "invalid order number", http.StatusUnprocessableEntity)
 		return
 	}
-
-	userBalance, err := h.Cursor.GetUserBalance(username, h.Logger)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	if withrawal.Sum <= 0 {
+		http.Error(rw, "invalid sum", http.StatusUnprocessableEntity)
 		return
 	}
-	resultedAccrual := userBalance.Current - withrawal.Sum
-	if resultedAccrual < 0 {
-		http.Error(rw, "not enough money", http.StatusPaymentRequired)
+
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
 		return
 	}
-	resultedWithdrawn := userBalance.Withdrawn + withrawal.Sum
-	err = h.Cursor.SaveWithdrawal(&models.Withdrawal{
+	username := principal.Username
+
+	_, err = h.Cursor.Withdraw(r.Context(), &models.Withdrawal{
 		User:        username,
 		Order:       withrawal.Order,
 		Sum:         withrawal.Sum,
 		ProcessedAt: time.Now(),
 	}, h.Logger)
-	if err != nil {
+	if err == errors.ErrInsufficientFunds {
+		http.Error(rw, "not enough money", http.StatusPaymentRequired)
 		return
 	}
-	_, err = h.Cursor.UpdateUserBalance(username, &models.Balance{
-		User:      username,
-		Current:   resultedAccrual,
-		Withdrawn: resultedWithdrawn,
-	}, h.Logger)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	rw.WriteHeader(http.StatusOK)
@@ -61,16 +61,39 @@ func (h *BalanceRouter) WithdrawMoney(rw http.ResponseWriter, r *http.Request) {
 }
 
 func (h *BalanceRouter) GetWithdrawals(rw http.ResponseWriter, r *http.Request) {
-	cookie, _ := r.Cookie("session_token")
-	sessionToken := cookie.Value
-	username, err := h.Cursor.GetUsernameByToken(sessionToken, h.Logger)
+	principal, ok := requirePrincipal(rw, r)
+	if !ok {
+		return
+	}
+	writeWithdrawals(rw, r, h.Cursor, principal.Username, h.Logger)
+}
+
+// writeWithdrawals answers with the page of the user's withdrawals the query
+// asks for.
+func writeWithdrawals(rw http.ResponseWriter, r *http.Request, cursor *db.Cursor, username string, l *zap.Logger) {
+	query, err := ParseWithdrawalQuery(r.URL.Query())
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-	withdrawals, err := h.Cursor.GetWithdrawals(username, h.Logger)
+	// one extra withdrawal tells whether there is a next page
+	page := *query
+	if query.Limit > 0 {
+		page.Limit = query.Limit + 1
+	}
+	withdrawals, err := cursor.GetWithdrawals(r.Context(), username, &page, l)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if query.Limit > 0 && len(withdrawals) > query.Limit {
+		withdrawals = withdrawals[:query.Limit]
+		last := withdrawals[len(withdrawals)-1]
+		SetNextPage(rw, r, &models.PageCursor{Time: last.ProcessedAt, Key: last.Order})
+	}
+	if len(withdrawals) == 0 {
+		rw.WriteHeader(http.StatusNoContent)
+		return
 	}
 	buff := bytes.NewBuffer([]byte{})
 	encoder := json.NewEncoder(buff)
@@ -79,11 +102,6 @@ func (h *BalanceRouter) GetWithdrawals(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 	rw.Header().Set("Content-Type", "application/json")
-
-	if withdrawals == nil {
-		rw.WriteHeader(http.StatusNoContent)
-
-	}
 	_, err = rw.Write(buff.Bytes())
 	if err != nil {
 		return
@@ -2,15 +2,18 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/logger"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/mocks"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
 
@@ -42,7 +45,7 @@ func TestWithdrawal(t *testing.T) {
 				url: "http://localhost:8080/api/user/balance/withdraw",
 				payload: &models.WithdrawalPost{
 					Order: "2377225624",
-					Sum:   751,
+					Sum:   models.NewMoney(751),
 				},
 			},
 		},
@@ -56,7 +59,7 @@ func TestWithdrawal(t *testing.T) {
 				url: "http://localhost:8080/api/user/balance/withdraw",
 				payload: &models.WithdrawalPost{
 					Order: "2377225624",
-					Sum:   751,
+					Sum:   models.NewMoney(751),
 				},
 			},
 		},
@@ -70,7 +73,35 @@ func TestWithdrawal(t *testing.T) {
 				url: "http://localhost:8080/api/user/balance/withdraw",
 				payload: &models.WithdrawalPost{
 					Order: "111",
-					Sum:   3,
+					Sum:   models.NewMoney(3),
+				},
+			},
+		},
+		{
+			name: "Test Negative withdrawal - negative sum",
+			want: want{
+				code:     422,
+				response: "invalid sum\n",
+			},
+			args: arguments{
+				url: "http://localhost:8080/api/user/balance/withdraw",
+				payload: &models.WithdrawalPost{
+					Order: "2377225624",
+					Sum:   models.NewMoney(-100),
+				},
+			},
+		},
+		{
+			name: "Test Negative withdrawal - zero sum",
+			want: want{
+				code:     422,
+				response: "invalid sum\n",
+			},
+			args: arguments{
+				url: "http://localhost:8080/api/user/balance/withdraw",
+				payload: &models.WithdrawalPost{
+					Order: "2377225624",
+					Sum:   0,
 				},
 			},
 		},
@@ -78,43 +109,40 @@ func TestWithdrawal(t *testing.T) {
 
 	l, _ := logger.InitializeLogger("info")
 
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
 	handler := &Handler{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
 	ur := &UserRouter{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
 	br := &BalanceRouter{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
+	handler.Use(Authenticate(cursor, nil, l))
 	handler.Post("/api/user/login", ur.Login)
 	handler.Post("/api/user/balance/withdraw", br.WithdrawMoney)
 	ts := httptest.NewServer(handler)
-	handler.Cursor.SaveUserInfo(&models.UserInfo{
+	handler.Cursor.SaveUserInfo(context.Background(), &models.UserInfo{
 		Username: "test",
 		Password: "test",
 	}, l)
-	handler.Cursor.SaveOrder(
+	handler.Cursor.SaveOrder(context.Background(),
 		&models.Order{
 			Number:     "2377225624",
 			UploadedAt: time.Now(),
 		},
 		l,
 	)
-	handler.Cursor.UpdateUserBalance(
-		"test", &models.Balance{
-			User:      "test",
-			Current:   752,
-			Withdrawn: 0,
+	handler.Cursor.AppendLedgerEntry(context.Background(),
+		&models.LedgerEntry{
+			User:   "test",
+			Kind:   configuration.CREDIT,
+			Amount: models.NewMoney(752),
+			Order:  "2377225624",
 		},
 		l,
 	)
@@ -178,13 +206,13 @@ func TestGetWithdrawal(t *testing.T) {
 	mockWithdrawals := []*models.Withdrawal{
 		{
 			Order:       "2377225624",
-			Sum:         500,
+			Sum:         models.NewMoney(500),
 			ProcessedAt: parseTime(layout, "2020-12-09T16:09:57+03:00"),
 		},
 		{
 			Order:       "1111111111",
-			Sum:         322,
-			ProcessedAt: parseTime(layout, "2020-12-09T16:09:57+03:00"),
+			Sum:         models.NewMoney(322),
+			ProcessedAt: parseTime(layout, "2020-12-09T16:09:53+03:00"),
 		},
 	}
 	type want struct {
@@ -223,35 +251,31 @@ func TestGetWithdrawal(t *testing.T) {
 
 	l, _ := logger.InitializeLogger("info")
 
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
 	handler := &Handler{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
 	ur := &UserRouter{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
 	br := &BalanceRouter{
-		Mux: chi.NewMux(),
-		Cursor: &db.Cursor{
-			IDBInterface: mocks.NewMock(),
-		},
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
 	}
+	handler.Use(Authenticate(cursor, nil, l))
 	handler.Post("/api/user/login", ur.Login)
 	handler.Get("/api/user/withdrawals", br.GetWithdrawals)
 	handler.Post("/api/user/register", ur.RegisterUser)
 	ts := httptest.NewServer(handler)
-	handler.Cursor.SaveUserInfo(&models.UserInfo{
+	handler.Cursor.SaveUserInfo(context.Background(), &models.UserInfo{
 		Username: "test",
 		Password: "test",
 	}, l)
 	for _, withdrawal := range mockWithdrawals {
 		withdrawal.User = "test"
-		handler.Cursor.SaveWithdrawal(withdrawal, l)
+		handler.Cursor.SaveWithdrawal(context.Background(), withdrawal, l)
 		withdrawal.User = ""
 	}
 
@@ -308,11 +332,141 @@ func TestGetWithdrawal(t *testing.T) {
 				t.Errorf("Expected status code %d, got %d", tt.want.code, w.Code)
 			}
 
-			receivedWithdrawals := []*models.Withdrawal{}
-			if err := json.NewDecoder(res.Body).Decode(&receivedWithdrawals); err != nil {
+			var receivedWithdrawals []*models.Withdrawal
+			if err := json.NewDecoder(res.Body).Decode(&receivedWithdrawals); err != nil && err != io.EOF {
 				panic(err)
 			}
 			assert.Equal(t, tt.want.response, receivedWithdrawals)
 		})
 	}
 }
+
+func TestConcurrentWithdrawals(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	br := &BalanceRouter{
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
+		Logger: l,
+	}
+	br.Use(Authenticate(cursor, nil, l))
+	br.Post("/api/user/balance/withdraw", br.WithdrawMoney)
+
+	cursor.SaveSession(context.Background(), "token", &models.Session{
+		Username:  "test",
+		Token:     "token",
+		ExpiresAt: time.Now().Add(time.Minute),
+	}, l)
+	cursor.AppendLedgerEntry(context.Background(), &models.LedgerEntry{
+		User:   "test",
+		Kind:   configuration.CREDIT,
+		Amount: models.NewMoney(100),
+		Order:  "9278923470",
+	}, l)
+
+	orders := []string{
+		"2377225608", "2377225616", "2377225624", "2377225632", "2377225640",
+		"2377225657", "2377225665", "2377225673", "2377225681", "2377225699",
+	}
+	var wg sync.WaitGroup
+	codes := make(chan int, len(orders))
+	for _, order := range orders {
+		wg.Add(1)
+		go func(order string) {
+			defer wg.Done()
+			buff := bytes.NewBuffer([]byte{})
+			json.NewEncoder(buff).Encode(&models.WithdrawalPost{Order: order, Sum: models.NewMoney(30)})
+			request := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/user/balance/withdraw", buff)
+			request.AddCookie(&http.Cookie{Name: "session_token", Value: "token"})
+			w := httptest.NewRecorder()
+			br.ServeHTTP(w, request)
+			codes <- w.Code
+		}(order)
+	}
+	wg.Wait()
+	close(codes)
+
+	succeeded := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			succeeded++
+		} else {
+			assert.Equal(t, http.StatusPaymentRequired, code)
+		}
+	}
+	assert.Equal(t, 3, succeeded)
+
+	entries, _ := cursor.GetLedger(context.Background(), "test", l)
+	balance := models.Money(0)
+	for _, entry := range entries {
+		balance += entry.Amount
+	}
+	assert.Equal(t, models.NewMoney(10), balance)
+}
+
+func TestGetWithdrawalsPages(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	br := &BalanceRouter{
+		Mux:    chi.NewMux(),
+		Cursor: cursor,
+		Logger: l,
+	}
+	br.Use(Authenticate(cursor, nil, l))
+	br.Get("/api/user/withdrawals", br.GetWithdrawals)
+
+	cursor.SaveSession(context.Background(), "token", &models.Session{
+		Username:  "test",
+		Token:     "token",
+		ExpiresAt: time.Now().Add(time.Minute),
+	}, l)
+	start := time.Date(2020, 12, 9, 16, 9, 53, 0, time.UTC)
+	for i, order := range []string{"2377225624", "1111111111", "9278923470"} {
+		cursor.SaveWithdrawal(context.Background(), &models.Withdrawal{
+			User:        "test",
+			Order:       order,
+			Sum:         models.NewMoney(10),
+			ProcessedAt: start.Add(time.Duration(i) * time.Hour),
+		}, l)
+	}
+
+	get := func(url string) (*http.Response, []*models.Withdrawal) {
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		request.AddCookie(&http.Cookie{Name: "session_token", Value: "token"})
+		w := httptest.NewRecorder()
+		br.ServeHTTP(w, request)
+		res := w.Result()
+		defer res.Body.Close()
+		var withdrawals []*models.Withdrawal
+		json.NewDecoder(res.Body).Decode(&withdrawals)
+		return res, withdrawals
+	}
+
+	res, withdrawals := get("/api/user/withdrawals?limit=2")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, withdrawals, 2)
+	assert.Equal(t, "9278923470", withdrawals[0].Order)
+	assert.Equal(t, "1111111111", withdrawals[1].Order)
+	next := res.Header.Get("X-Next-Cursor")
+	assert.NotEmpty(t, next)
+
+	res, withdrawals = get("/api/user/withdrawals?limit=2&after=" + next)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, withdrawals, 1)
+	assert.Equal(t, "2377225624", withdrawals[0].Order)
+	assert.Empty(t, res.Header.Get("Link"))
+
+	res, withdrawals = get("/api/user/withdrawals?from=2020-12-09T17:00:00Z&to=2020-12-09T18:00:00Z")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, withdrawals, 1)
+	assert.Equal(t, "1111111111", withdrawals[0].Order)
+
+	for _, url := range []string{
+		"/api/user/withdrawals?limit=-1",
+		"/api/user/withdrawals?after=broken",
+		"/api/user/withdrawals?to=tomorrow",
+	} {
+		res, _ = get(url)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, url)
+	}
+}
@@ -3,18 +3,28 @@ package app
 import (
 	"context"
 	"go.uber.org/zap"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/MlDenis/diploma-wannabe-v2/internal/api"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/auth"
 	config "github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/jobmanager"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/logger"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/outbox"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/ratelimit"
 )
 
 type App struct {
 	config  *config.Config
+	cursor  *db.Cursor
 	manager *jobmanager.Jobmanager
+	relay   *outbox.Relay
+	events  *outbox.Broadcast
 	Server  *http.Server
 	Logger  *zap.Logger
 }
@@ -24,6 +34,9 @@ func (a *App) Run(ctx context.Context) error {
 	done := make(chan bool)
 
 	go a.manager.ManageJobs(ctx, a.config.Accrual, done, a.Logger)
+	go a.relay.Run(ctx)
+	go a.events.Listen(ctx, a.manager.Events)
+	go a.sweepSessions(ctx)
 
 	go func() {
 		if err := a.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -35,9 +48,9 @@ func (a *App) Run(ctx context.Context) error {
 
 	select {
 	case <-ctx.Done():
-		// Если контекст был отменен, остановите сервер и закройте канал done
+		// Если контекст был отменен, остановите сервер и дождитесь завершения jobmanager
 		a.Server.Shutdown(ctx)
-		close(done)
+		<-done
 	case <-done:
 		// Если работа была завершена, просто верните nil
 		return nil
@@ -56,20 +69,104 @@ func NewApp(config *config.Config, ctx context.Context) (*App, error) {
 	l.Info("Accrual addr is: ", zap.String("", config.Accrual))
 	l.Info("DB addr is: ", zap.String("", config.DatabaseURI))
 
-	cursor, err := db.GetCursor(config.DatabaseURI, l)
+	cursor, err := db.GetCursor(config.DatabaseURI, config.QueryTimeout, l)
 	if err != nil {
 		return nil, err
 	}
-	manager := jobmanager.NewJobmanager(cursor, config.Accrual, &ctx)
-	handler := api.NewHandler(cursor, manager, l)
+	if err := promoteInitialAdmin(ctx, cursor, config.InitialAdmin, l); err != nil {
+		return nil, err
+	}
+	manager := jobmanager.NewJobmanager(cursor, config.Accrual, config.Workers, &ctx, l)
+	// order and balance changes reach the streams and webhooks through the
+	// outbox, so none is lost if the process stops right after a write. The
+	// relay may run on another instance than the subscribers of an event, so
+	// live updates are broadcast through the storage to all of them.
+	events := outbox.NewBroadcast(cursor, l)
+	relay := outbox.NewRelay(cursor, l, events, manager.Webhooks)
+	keys, err := newKeyring(config.JWTKeys, l)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := ratelimit.ParseLimits(config.RateLimits)
+	if err != nil {
+		return nil, err
+	}
+	handler := api.NewHandler(cursor, manager, keys, limits, l)
 	server := &http.Server{
 		Addr:    config.Address,
 		Handler: handler,
+		// ends long-lived requests such as order streams on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
 	return &App{
 		config:  config,
+		cursor:  cursor,
 		manager: manager,
+		relay:   relay,
+		events:  events,
 		Server:  server,
 		Logger:  l,
 	}, nil
 }
+
+// sweepSessions purges the sessions that can no longer be refreshed and the
+// failed logins that no longer count every SESSIONSWEEPINTERVAL seconds until
+// ctx ends.
+func (a *App) sweepSessions(ctx context.Context) {
+	ticker := time.NewTicker(config.SESSIONSWEEPINTERVAL * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := a.cursor.DeleteExpiredSessions(ctx, time.Now(), a.Logger)
+			if err != nil {
+				a.Logger.Error("Error deleting expired sessions", zap.Error(err))
+				continue
+			}
+			if deleted > 0 {
+				a.Logger.Info("Expired sessions deleted", zap.Int64("count", deleted))
+			}
+			_, err = a.cursor.DeleteStaleLoginAttempts(ctx, time.Now(), config.LOGINFAILUREWINDOW*time.Second, a.Logger)
+			if err != nil {
+				a.Logger.Error("Error deleting stale login attempts", zap.Error(err))
+			}
+		}
+	}
+}
+
+// promoteInitialAdmin makes the configured user an admin, so that a fresh
+// deployment has someone to grant the other staff roles. The user registers
+// first, a login nobody has registered yet is only warned about.
+func promoteInitialAdmin(ctx context.Context, cursor *db.Cursor, username string, l *zap.Logger) error {
+	if username == "" {
+		return nil
+	}
+	user, err := cursor.GetUserInfo(ctx, &models.UserInfo{Username: username}, l)
+	if err == errors.ErrNotFound {
+		l.Warn("Initial admin is not registered, register it and restart", zap.String("username", username))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Role == config.ROLEADMIN {
+		return nil
+	}
+	if err := cursor.SetUserRole(ctx, username, config.ROLEADMIN, l); err != nil {
+		return err
+	}
+	l.Info("Initial admin promoted", zap.String("username", username))
+	return nil
+}
+
+// newKeyring reads the configured token signing keys. Without any a random
+// key is used, so tokens are only valid until the restart.
+func newKeyring(spec string, l *zap.Logger) (*auth.Keyring, error) {
+	if spec == "" {
+		l.Warn("No token signing keys configured, using a random key")
+		return auth.NewRandomKeyring()
+	}
+	return auth.ParseKeyring(spec)
+}
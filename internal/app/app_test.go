@@ -6,15 +6,21 @@ import (
 	"encoding/json"
 	config "github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"log"
+	"net"
 	"net/http"
 	"testing"
+	"time"
 
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 func TestApp(t *testing.T) {
+	t.Setenv("DATABASE_URI", config.MEMORYSCHEME)
 	ctx := context.Background()
 	flags := config.NewCliOptions()
 	envs, err := config.NewEnvConfig()
@@ -23,6 +29,14 @@ func TestApp(t *testing.T) {
 	}
 	app, _ := NewApp(config.NewConfig(flags, envs), ctx)
 	go app.Run(ctx)
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", "localhost:8080")
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 5*time.Second, 50*time.Millisecond)
 
 	client := &http.Client{}
 
@@ -61,3 +75,26 @@ func TestApp(t *testing.T) {
 	defer response.Body.Close()
 	assert.Equal(t, 200, response.StatusCode)
 }
+
+func TestPromoteInitialAdmin(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	cursor := &db.Cursor{IDBInterface: db.NewMemoryCursor()}
+
+	assert.NoError(t, promoteInitialAdmin(ctx, cursor, "", l))
+	// the admin registers first
+	assert.NoError(t, promoteInitialAdmin(ctx, cursor, "root", l))
+
+	require.NoError(t, cursor.SaveUserInfo(ctx, &models.UserInfo{Username: "root", Password: "test"}, l))
+	assert.NoError(t, promoteInitialAdmin(ctx, cursor, "root", l))
+	assert.NoError(t, promoteInitialAdmin(ctx, cursor, "root", l))
+	user, err := cursor.GetUserInfo(ctx, &models.UserInfo{Username: "root"}, l)
+	require.NoError(t, err)
+	assert.Equal(t, config.ROLEADMIN, user.Role)
+
+	entries, err := cursor.GetAuditLog(ctx, &models.AuditQuery{Action: config.AUDITROLECHANGED}, l)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, config.SYSTEMACTOR, entries[0].Actor)
+	assert.Equal(t, "root", entries[0].Subject)
+}
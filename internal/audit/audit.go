@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+// Actor is who makes a change and where the request came from. Changes made
+// outside of a request, such as accrual updates, are made by the system.
+type Actor struct {
+	Username  string
+	IP        string
+	UserAgent string
+}
+
+type actorKey struct{}
+
+func NewContext(ctx context.Context, actor *Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// FromContext returns the actor of the request context, or the system when
+// there is none.
+func FromContext(ctx context.Context) *Actor {
+	if actor, ok := ctx.Value(actorKey{}).(*Actor); ok {
+		return actor
+	}
+	return &Actor{Username: configuration.SYSTEMACTOR}
+}
+
+// NewEntry describes the action the actor of ctx takes on subject. Before
+// and after are stored as JSON, nil leaves them out.
+func NewEntry(ctx context.Context, action string, subject string, before interface{}, after interface{}) (*models.AuditEntry, error) {
+	actor := FromContext(ctx)
+	entry := &models.AuditEntry{
+		Actor:     actor.Username,
+		Action:    action,
+		Subject:   subject,
+		IP:        actor.IP,
+		UserAgent: actor.UserAgent,
+		CreatedAt: time.Now(),
+	}
+	var err error
+	if entry.Before, err = marshal(before); err != nil {
+		return nil, err
+	}
+	if entry.After, err = marshal(after); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func marshal(value interface{}) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
@@ -0,0 +1,27 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+)
+
+func TestNewEntry(t *testing.T) {
+	entry, err := NewEntry(context.Background(), configuration.AUDITORDERSTATUS, "test", nil, map[string]string{"status": "PROCESSED"})
+	require.NoError(t, err)
+	assert.Equal(t, configuration.SYSTEMACTOR, entry.Actor)
+	assert.Nil(t, entry.Before)
+	assert.JSONEq(t, `{"status":"PROCESSED"}`, string(entry.After))
+
+	ctx := NewContext(context.Background(), &Actor{Username: "admin", IP: "10.0.0.1", UserAgent: "laptop"})
+	entry, err = NewEntry(ctx, configuration.AUDITROLECHANGED, "test", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", entry.Actor)
+	assert.Equal(t, "10.0.0.1", entry.IP)
+	assert.Equal(t, "laptop", entry.UserAgent)
+	assert.Equal(t, "test", entry.Subject)
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+)
+
+// Keyring signs access tokens with its current key and accepts tokens signed
+// with any of its keys. A key is rotated by putting the new one first and
+// dropping the old one once the tokens it signed have expired.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// Claims are what an access token says about its holder: the user in the
+// subject, the session the token was issued for and the role of the user at
+// that time.
+type Claims struct {
+	jwt.RegisteredClaims
+	Session int64  `json:"sid,omitempty"`
+	Role    string `json:"role,omitempty"`
+}
+
+// ParseKeyring reads keys written as "id:secret,id:secret". The first key
+// signs new tokens.
+func ParseKeyring(spec string) (*Keyring, error) {
+	k := &Keyring{keys: map[string][]byte{}}
+	for _, pair := range strings.Split(spec, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" || secret == "" {
+			return nil, errors.ErrInvalidKeys
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, errors.ErrInvalidKeys
+		}
+		if k.current == "" {
+			k.current = id
+		}
+		k.keys[id] = []byte(secret)
+	}
+	return k, nil
+}
+
+// NewRandomKeyring makes a single random key, tokens signed with it do not
+// survive a restart.
+func NewRandomKeyring() (*Keyring, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return ParseKeyring("random:" + hex.EncodeToString(secret))
+}
+
+func (k *Keyring) IssueToken(username string, session int64, role string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    configuration.TOKENISSUER,
+			Subject:   username,
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Session: session,
+		Role:    role,
+	})
+	token.Header["kid"] = k.current
+	return token.SignedString(k.keys[k.current])
+}
+
+// VerifyToken checks the signature and expiry of the token and returns its
+// claims.
+func (k *Keyring) VerifyToken(value string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(value, claims, func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header["kid"].(string)
+		secret, ok := k.keys[id]
+		if !ok {
+			return nil, errors.ErrInvalidToken
+		}
+		return secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(configuration.TOKENISSUER),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || claims.Subject == "" {
+		return nil, errors.ErrInvalidToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+)
+
+func TestTokens(t *testing.T) {
+	keys, err := ParseKeyring("old:first-secret")
+	require.NoError(t, err)
+	token, err := keys.IssueToken("test", 1, "user", time.Minute)
+	require.NoError(t, err)
+	claims, err := keys.VerifyToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", claims.Subject)
+	assert.Equal(t, int64(1), claims.Session)
+	assert.Equal(t, "user", claims.Role)
+
+	// a rotated keyring still accepts tokens of the old key
+	rotated, err := ParseKeyring("new:second-secret,old:first-secret")
+	require.NoError(t, err)
+	claims, err = rotated.VerifyToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", claims.Subject)
+	newToken, err := rotated.IssueToken("test", 1, "user", time.Minute)
+	require.NoError(t, err)
+	_, err = keys.VerifyToken(newToken)
+	assert.Equal(t, errors.ErrInvalidToken, err)
+
+	// and rejects them once the old key is dropped
+	retired, err := ParseKeyring("new:second-secret")
+	require.NoError(t, err)
+	_, err = retired.VerifyToken(token)
+	assert.Equal(t, errors.ErrInvalidToken, err)
+
+	expired, err := keys.IssueToken("test", 1, "user", -time.Minute)
+	require.NoError(t, err)
+	_, err = keys.VerifyToken(expired)
+	assert.Equal(t, errors.ErrInvalidToken, err)
+
+	_, err = keys.VerifyToken(token + "x")
+	assert.Equal(t, errors.ErrInvalidToken, err)
+	_, err = keys.VerifyToken("garbage")
+	assert.Equal(t, errors.ErrInvalidToken, err)
+}
+
+func TestParseKeyring(t *testing.T) {
+	for _, spec := range []string{"", "secret", "id:", ":secret", "a:x,a:y"} {
+		_, err := ParseKeyring(spec)
+		assert.Equal(t, errors.ErrInvalidKeys, err, spec)
+	}
+	random, err := NewRandomKeyring()
+	require.NoError(t, err)
+	other, err := NewRandomKeyring()
+	require.NoError(t, err)
+	token, err := random.IssueToken("test", 1, "user", time.Minute)
+	require.NoError(t, err)
+	_, err = other.VerifyToken(token)
+	assert.Equal(t, errors.ErrInvalidToken, err)
+}
+
+func TestRefreshToken(t *testing.T) {
+	token, hash, err := NewRefreshToken()
+	require.NoError(t, err)
+	other, _, err := NewRefreshToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+	assert.NotEqual(t, token, hash)
+	assert.Equal(t, hash, HashRefreshToken(token))
+}
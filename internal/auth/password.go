@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"crypto/subtle"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+)
+
+// dummyHash is compared against when the user does not exist, so a failed
+// login takes the same time whether or not the login is taken.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("gophermart"), configuration.PASSWORDCOST)
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), configuration.PASSWORDCOST)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// VerifyPassword checks the password against the stored value in constant
+// time. Values that are not bcrypt hashes are legacy plaintext passwords.
+func VerifyPassword(stored string, password string) bool {
+	if _, err := bcrypt.Cost([]byte(stored)); err != nil {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+}
+
+// NeedsRehash reports whether the stored value is plaintext or was hashed
+// with a weaker cost than the current one.
+func NeedsRehash(stored string) bool {
+	cost, err := bcrypt.Cost([]byte(stored))
+	if err != nil {
+		return true
+	}
+	return cost < configuration.PASSWORDCOST
+}
+
+// BurnPasswordCheck spends the time of a real password check.
+func BurnPasswordCheck(password string) {
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
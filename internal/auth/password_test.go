@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("test")
+	assert.NoError(t, err)
+	assert.NotEqual(t, "test", hash)
+	assert.True(t, VerifyPassword(hash, "test"))
+	assert.False(t, VerifyPassword(hash, "testtest"))
+	assert.False(t, NeedsRehash(hash))
+}
+
+func TestLegacyPasswords(t *testing.T) {
+	assert.True(t, VerifyPassword("test", "test"))
+	assert.False(t, VerifyPassword("test", "testtest"))
+	assert.True(t, NeedsRehash("test"))
+
+	weak, err := bcrypt.GenerateFromPassword([]byte("test"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	assert.True(t, VerifyPassword(string(weak), "test"))
+	assert.True(t, NeedsRehash(string(weak)))
+}
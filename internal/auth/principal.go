@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+)
+
+// Principal is who a request is made by: the user, the session it was
+// authenticated with and the roles the user has.
+type Principal struct {
+	Username string
+	Session  int64
+	Roles    []string
+}
+
+// NewPrincipal makes the principal of a user with the given role. Roles are
+// ranked, each includes the ones below it: admin, support, user.
+func NewPrincipal(username string, session int64, role string) *Principal {
+	return &Principal{
+		Username: username,
+		Session:  session,
+		Roles:    RolesOf(role),
+	}
+}
+
+// RolesOf lists the role and the roles it includes. Unknown roles are
+// ordinary users.
+func RolesOf(role string) []string {
+	switch role {
+	case configuration.ROLEADMIN:
+		return []string{configuration.ROLEUSER, configuration.ROLESUPPORT, configuration.ROLEADMIN}
+	case configuration.ROLESUPPORT:
+		return []string{configuration.ROLEUSER, configuration.ROLESUPPORT}
+	default:
+		return []string{configuration.ROLEUSER}
+	}
+}
+
+// ValidRole tells whether users can be given the role.
+func ValidRole(role string) bool {
+	return role == configuration.ROLEUSER || role == configuration.ROLESUPPORT || role == configuration.ROLEADMIN
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal the request context was authenticated
+// with, if any.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+)
+
+func TestPrincipalRoles(t *testing.T) {
+	user := NewPrincipal("test", 1, configuration.ROLEUSER)
+	support := NewPrincipal("test", 1, configuration.ROLESUPPORT)
+	admin := NewPrincipal("test", 1, configuration.ROLEADMIN)
+	unknown := NewPrincipal("test", 1, "root")
+
+	assert.True(t, user.HasRole(configuration.ROLEUSER))
+	assert.False(t, user.HasRole(configuration.ROLESUPPORT))
+	assert.True(t, support.HasRole(configuration.ROLESUPPORT))
+	assert.False(t, support.HasRole(configuration.ROLEADMIN))
+	assert.True(t, admin.HasRole(configuration.ROLESUPPORT))
+	assert.True(t, admin.HasRole(configuration.ROLEADMIN))
+	assert.Equal(t, user.Roles, unknown.Roles)
+
+	assert.True(t, ValidRole(configuration.ROLESUPPORT))
+	assert.False(t, ValidRole("root"))
+}
@@ -0,0 +1,23 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// NewRefreshToken returns a random refresh token for the client and its hash
+// for the storage.
+func NewRefreshToken() (string, string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", "", err
+	}
+	value := hex.EncodeToString(token)
+	return value, HashRefreshToken(value), nil
+}
+
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+)
+
+// AccountLoginDelay is how long logins to an account are refused after the
+// given number of recent failures. The first LOGINFREEFAILURES cost nothing,
+// then the delay doubles with each failure until LOGINMAXFAILURES lock the
+// account out for LOGINLOCKOUT.
+func AccountLoginDelay(failures int) time.Duration {
+	switch {
+	case failures >= configuration.LOGINMAXFAILURES:
+		return configuration.LOGINLOCKOUT * time.Second
+	case failures <= configuration.LOGINFREEFAILURES:
+		return 0
+	}
+	return configuration.LOGINDELAYBASE * time.Second << (failures - configuration.LOGINFREEFAILURES - 1)
+}
+
+// IPLoginDelay is how long logins from an address are refused after the
+// given number of recent failures. Many users may share an address, so it is
+// only locked out once it fails far more often than one user would.
+func IPLoginDelay(failures int) time.Duration {
+	if failures >= configuration.LOGINIPMAXFAILURES {
+		return configuration.LOGINLOCKOUT * time.Second
+	}
+	return 0
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+)
+
+func TestAccountLoginDelay(t *testing.T) {
+	lockout := configuration.LOGINLOCKOUT * time.Second
+	tests := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{configuration.LOGINFREEFAILURES, 0},
+		{configuration.LOGINFREEFAILURES + 1, time.Second},
+		{configuration.LOGINFREEFAILURES + 2, 2 * time.Second},
+		{configuration.LOGINFREEFAILURES + 3, 4 * time.Second},
+		{configuration.LOGINMAXFAILURES - 1, 32 * time.Second},
+		{configuration.LOGINMAXFAILURES, lockout},
+		{configuration.LOGINMAXFAILURES + 5, lockout},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.delay, AccountLoginDelay(tt.failures), "failures: %d", tt.failures)
+	}
+}
+
+func TestIPLoginDelay(t *testing.T) {
+	assert.Equal(t, time.Duration(0), IPLoginDelay(configuration.LOGINMAXFAILURES))
+	assert.Equal(t, configuration.LOGINLOCKOUT*time.Second, IPLoginDelay(configuration.LOGINIPMAXFAILURES))
+}
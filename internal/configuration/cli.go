@@ -1,12 +1,20 @@
 package configuration
 
-import "flag"
+import (
+	"flag"
+	"time"
+)
 
 type CLIOptions struct {
-	Address     string
-	DatabaseURI string
-	Accrual     string
-	LogLevel    string
+	Address      string
+	DatabaseURI  string
+	Accrual      string
+	LogLevel     string
+	Workers      int
+	QueryTimeout time.Duration
+	JWTKeys      string
+	RateLimits   string
+	InitialAdmin string
 }
 
 func NewCliOptions() *CLIOptions {
@@ -14,12 +22,22 @@ func NewCliOptions() *CLIOptions {
 	var accrual = flag.String("r", "", "accrual address")
 	var database = flag.String("d", "", "database address")
 	var logLevel = flag.String("l", "", "log level")
+	var workers = flag.Int("w", 0, "accrual workers count")
+	var queryTimeout = flag.Duration("t", 0, "database query timeout")
+	var jwtKeys = flag.String("k", "", "token signing keys as id:secret, the first one signs")
+	var rateLimits = flag.String("q", "", "request rate limits as group:rate/burst, rate per second")
+	var initialAdmin = flag.String("i", "", "login of a registered user made admin on start")
 	flag.Parse()
 
 	return &CLIOptions{
-		Address:     *address,
-		DatabaseURI: *accrual,
-		Accrual:     *database,
-		LogLevel:    *logLevel,
+		Address:      *address,
+		DatabaseURI:  *accrual,
+		Accrual:      *database,
+		LogLevel:     *logLevel,
+		Workers:      *workers,
+		QueryTimeout: *queryTimeout,
+		JWTKeys:      *jwtKeys,
+		RateLimits:   *rateLimits,
+		InitialAdmin: *initialAdmin,
 	}
 }
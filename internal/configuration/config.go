@@ -1,18 +1,30 @@
 package configuration
 
+import "time"
+
 type Config struct {
-	Address     string
-	DatabaseURI string
-	Accrual     string
-	LogLevel    string
+	Address      string
+	DatabaseURI  string
+	Accrual      string
+	LogLevel     string
+	Workers      int
+	QueryTimeout time.Duration
+	JWTKeys      string
+	RateLimits   string
+	InitialAdmin string
 }
 
 func NewConfig(flags *CLIOptions, envs *EnvConfig) *Config {
 	result := &Config{
-		Address:     flags.Address,
-		Accrual:     flags.Accrual,
-		DatabaseURI: flags.DatabaseURI,
-		LogLevel:    flags.LogLevel,
+		Address:      flags.Address,
+		Accrual:      flags.Accrual,
+		DatabaseURI:  flags.DatabaseURI,
+		LogLevel:     flags.LogLevel,
+		Workers:      flags.Workers,
+		QueryTimeout: flags.QueryTimeout,
+		JWTKeys:      flags.JWTKeys,
+		RateLimits:   flags.RateLimits,
+		InitialAdmin: flags.InitialAdmin,
 	}
 	if flags.Address == "" {
 		result.Address = envs.Address
@@ -26,5 +38,20 @@ func NewConfig(flags *CLIOptions, envs *EnvConfig) *Config {
 	if flags.LogLevel == "" {
 		result.LogLevel = envs.LogLevel
 	}
+	if flags.Workers == 0 {
+		result.Workers = envs.Workers
+	}
+	if flags.QueryTimeout == 0 {
+		result.QueryTimeout = envs.QueryTimeout
+	}
+	if flags.JWTKeys == "" {
+		result.JWTKeys = envs.JWTKeys
+	}
+	if flags.RateLimits == "" {
+		result.RateLimits = envs.RateLimits
+	}
+	if flags.InitialAdmin == "" {
+		result.InitialAdmin = envs.InitialAdmin
+	}
 	return result
 }
@@ -1,7 +1,10 @@
 package configuration
 
 import (
+	"flag"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -39,13 +42,27 @@ func TestConfigFlagsNotProvided(t *testing.T) {
 	}, config)
 }
 
+// resetFlags gives the test a fresh command line with the given arguments,
+// so the flags can be defined again.
+func resetFlags(t *testing.T, args ...string) {
+	commandLine, osArgs := flag.CommandLine, os.Args
+	t.Cleanup(func() {
+		flag.CommandLine, os.Args = commandLine, osArgs
+	})
+	flag.CommandLine = flag.NewFlagSet("gophermart", flag.ContinueOnError)
+	os.Args = append([]string{"gophermart"}, args...)
+}
+
 func TestConfigBothNotProvided(t *testing.T) {
+	resetFlags(t)
 	envs, _ := NewEnvConfig()
 	flags := NewCliOptions()
 	config := NewConfig(flags, envs)
 	assert.Equal(t, &Config{
-		Address:     "localhost:8080",
-		DatabaseURI: "localhost:5432",
-		Accrual:     "localhost:8081",
+		Address:      "localhost:8080",
+		Accrual:      "localhost:8081",
+		LogLevel:     "info",
+		Workers:      4,
+		QueryTimeout: time.Second,
 	}, config)
 }
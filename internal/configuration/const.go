@@ -1,9 +1,201 @@
 package configuration
 
-const IDBTIMEOUT = 1
+const MEMORYSCHEME = "memory://"
+
+const SQLITESCHEME = "sqlite://"
+
+const MIGRATIONS = "file://./migrations"
+
+const SQLITEMIGRATIONS = "file://./migrations/sqlite"
+
+const SQLITEBUSYTIMEOUT = 5
+
+const SQLITETIMEFORMAT = "2006-01-02 15:04:05.000000000-07:00"
+
+const PASSWORDCOST = 12
+
+const PASSWORDMAXLENGTH = 72
 
 const JOBTIMEOUT = 10
 
+const JOBLEASE = 30
+
+const JOBPOLLINTERVAL = 1
+
+const JOBRESCHEDULEDELAY = 2
+
+const JOBWORKERS = 4
+
+const MAXPAGELIMIT = 1000
+
+const EVENTBUFFER = 16
+
+const SSEHEARTBEAT = 15
+
+const WSPINGINTERVAL = 15
+
+const WSWRITETIMEOUT = 10
+
+const ACCRUALRETRYAFTER = 60
+
+const ACCRUALRECOVERSUCCESSES = 10
+
+const JOBBACKOFFBASE = 1
+
+const JOBBACKOFFMAX = 300
+
+const JOBMAXATTEMPTS = 10
+
+const WEBHOOKPOLLINTERVAL = 1
+
+const WEBHOOKBATCH = 16
+
+const WEBHOOKTIMEOUT = 10
+
+const WEBHOOKLEASE = 30
+
+const WEBHOOKMAXATTEMPTS = 8
+
+const OUTBOXPOLLINTERVAL = 1
+
+const OUTBOXBATCH = 100
+
+const OUTBOXLEASE = 30
+
+const SESSIONTTL = 600
+
+const ACCESSTOKENTTL = 600
+
+const REFRESHTOKENTTL = 30 * 24 * 60 * 60
+
+const SESSIONSWEEPINTERVAL = 60
+
+const LOGINFAILUREWINDOW = 15 * 60
+
+const LOGINFREEFAILURES = 3
+
+const LOGINDELAYBASE = 1
+
+const LOGINMAXFAILURES = 10
+
+const LOGINIPMAXFAILURES = 50
+
+const LOGINLOCKOUT = 15 * 60
+
+const LOGINSCOPEACCOUNT = "account"
+
+const LOGINSCOPEIP = "ip"
+
+const TOKENISSUER = "gophermart"
+
+const ROLEUSER = "user"
+
+const ROLESUPPORT = "support"
+
+const ROLEADMIN = "admin"
+
+const ADJUSTMENTAPPROVALTHRESHOLD = 1000
+
+const SYSTEMACTOR = "system"
+
 const REGISTERED = "REGISTERED"
 
 const PROCESSING = "PROCESSING"
+
+const NEW = "NEW"
+
+const INVALID = "INVALID"
+
+const PROCESSED = "PROCESSED"
+
+const CREDIT = "CREDIT"
+
+const DEBIT = "DEBIT"
+
+const ADJUSTMENT = "ADJUSTMENT"
+
+const ADJUSTMENTPENDING = "PENDING"
+
+const ADJUSTMENTAPPLIED = "APPLIED"
+
+const ADJUSTMENTREJECTED = "REJECTED"
+
+const ACCRUALCORRECTION = "ACCRUAL_CORRECTION"
+
+const GOODWILL = "GOODWILL"
+
+const FRAUDREVERSAL = "FRAUD_REVERSAL"
+
+const OTHERREASON = "OTHER"
+
+const ORDERUPDATED = "order.updated"
+
+const BALANCEUPDATED = "balance.updated"
+
+const ORDERPROCESSED = "order.processed"
+
+const ORDERINVALID = "order.invalid"
+
+const WITHDRAWALCREATED = "withdrawal.created"
+
+const DELIVERYPENDING = "PENDING"
+
+const DELIVERED = "DELIVERED"
+
+const DELIVERYFAILED = "FAILED"
+
+const AUDITREGISTERED = "user.registered"
+
+const AUDITLOGIN = "user.login"
+
+const AUDITLOGINFAILED = "user.login_failed"
+
+const AUDITLOGINLOCKED = "user.login_locked"
+
+const AUDITLOGINUNLOCKED = "user.login_unlocked"
+
+const AUDITROLECHANGED = "user.role_changed"
+
+const AUDITSESSIONCREATED = "session.created"
+
+const AUDITSESSIONREVOKED = "session.revoked"
+
+const AUDITORDERUPLOADED = "order.uploaded"
+
+const AUDITORDERSTATUS = "order.status_changed"
+
+const AUDITWITHDRAWAL = "balance.withdrawn"
+
+const AUDITBALANCEADJUSTED = "balance.adjusted"
+
+const AUDITADJUSTMENTREQUESTED = "adjustment.requested"
+
+const AUDITADJUSTMENTREVIEWED = "adjustment.reviewed"
+
+const AUDITJOBREDRIVEN = "job.redriven"
+
+const RATELIMITAUTH = "auth"
+
+const RATELIMITORDERS = "orders"
+
+const RATELIMITBALANCE = "balance"
+
+const RATELIMITAUTHRATE = 1
+
+const RATELIMITAUTHBURST = 10
+
+const RATELIMITORDERSRATE = 2
+
+const RATELIMITORDERSBURST = 10
+
+const RATELIMITBALANCERATE = 5
+
+const RATELIMITBALANCEBURST = 20
+
+const RATELIMITSWEEPINTERVAL = 60
+
+const EVENTSCHANNEL = "gophermart_events"
+
+const EVENTSPAYLOADLIMIT = 8000
+
+const EVENTSRECONNECT = 1
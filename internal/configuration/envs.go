@@ -1,14 +1,21 @@
 package configuration
 
 import (
+	"time"
+
 	"github.com/caarlos0/env/v6"
 )
 
 type EnvConfig struct {
-	Address     string `env:"RUN_ADDRESS,required" envDefault:"localhost:8080"`
-	DatabaseURI string `env:"DATABASE_URI,required" envDefault:"localhost:5432"`
-	Accrual     string `env:"ACCRUAL_SYSTEM_ADDRESS,required" envDefault:"localhost:8081"`
-	LogLevel    string `env:"LOG_LEVEL,required" envDefault:"info"`
+	Address      string        `env:"RUN_ADDRESS,required" envDefault:"localhost:8080"`
+	DatabaseURI  string        `env:"DATABASE_URI"`
+	Accrual      string        `env:"ACCRUAL_SYSTEM_ADDRESS,required" envDefault:"localhost:8081"`
+	LogLevel     string        `env:"LOG_LEVEL,required" envDefault:"info"`
+	Workers      int           `env:"ACCRUAL_WORKERS" envDefault:"4"`
+	QueryTimeout time.Duration `env:"DATABASE_QUERY_TIMEOUT" envDefault:"1s"`
+	JWTKeys      string        `env:"JWT_KEYS"`
+	RateLimits   string        `env:"RATE_LIMITS"`
+	InitialAdmin string        `env:"INITIAL_ADMIN"`
 }
 
 func NewEnvConfig() (*EnvConfig, error) {
@@ -2,6 +2,7 @@ package configuration
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -9,6 +10,8 @@ import (
 func TestEnvConfig(t *testing.T) {
 	testConfig, _ := NewEnvConfig()
 	assert.Equal(t, testConfig.Address, "localhost:8080")
-	assert.Equal(t, testConfig.DatabaseURI, "localhost:5432")
+	assert.Equal(t, testConfig.DatabaseURI, "")
 	assert.Equal(t, testConfig.Accrual, "localhost:8081")
+	assert.Equal(t, testConfig.Workers, 4)
+	assert.Equal(t, testConfig.QueryTimeout, time.Second)
 }
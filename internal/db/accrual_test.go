@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestApplyAccrual(t *testing.T) {
+	sqlite, _ := newSQLiteTestCursor(t)
+	backends := map[string]*Cursor{
+		"memory": {NewMemoryCursor()},
+		"sqlite": sqlite,
+	}
+	for name, cursor := range backends {
+		t.Run(name, func(t *testing.T) {
+			testApplyAccrual(t, cursor)
+		})
+	}
+}
+
+func testApplyAccrual(t *testing.T, cursor *Cursor) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	require.NoError(t, cursor.SaveOrder(ctx, &models.Order{Username: "test", Number: "9278923470", Status: configuration.NEW, UploadedAt: time.Now()}, l))
+
+	require.NoError(t, cursor.ApplyAccrual(ctx, "test", &models.AccrualResponse{Order: "9278923470", Status: configuration.PROCESSING}, l))
+	entries, err := cursor.GetLedger(ctx, "test", l)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	processed := &models.AccrualResponse{Order: "9278923470", Status: configuration.PROCESSED, Accrual: models.NewMoney(500)}
+	require.NoError(t, cursor.ApplyAccrual(ctx, "test", processed, l))
+	// a job retried after it applied the result credits nothing more
+	require.NoError(t, cursor.ApplyAccrual(ctx, "test", processed, l))
+	order, err := cursor.GetOrder(ctx, "test", "9278923470", l)
+	require.NoError(t, err)
+	assert.Equal(t, configuration.PROCESSED, order.Status)
+	balance, err := cursor.GetUserBalance(ctx, "test", l)
+	require.NoError(t, err)
+	assert.Equal(t, models.NewMoney(500), balance.Current)
+	entries, err = cursor.GetLedger(ctx, "test", l)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "accrual", entries[0].Reason)
+
+	// nothing is credited for an order the user does not have
+	require.NoError(t, cursor.ApplyAccrual(ctx, "other", processed, l))
+	entries, err = cursor.GetLedger(ctx, "other", l)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+	"go.uber.org/zap"
+)
+
+// adjustmentEntry is the ledger entry of an applied adjustment. The reason
+// code leads the reason, so the user's statement shows both.
+func adjustmentEntry(adjustment *models.Adjustment, appliedAt time.Time) *models.LedgerEntry {
+	return &models.LedgerEntry{
+		User:      adjustment.User,
+		Kind:      configuration.ADJUSTMENT,
+		Amount:    adjustment.Amount,
+		Reason:    adjustment.ReasonCode + ": " + adjustment.Reason,
+		CreatedAt: appliedAt,
+	}
+}
+
+func scanAdjustment(row scanner) (*models.Adjustment, error) {
+	var a models.Adjustment
+	err := row.Scan(&a.ID, &a.User, &a.Amount, &a.ReasonCode, &a.Reason, &a.Status,
+		&a.RequestedBy, &a.ReviewedBy, &a.CreatedAt, &a.ReviewedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// SaveAdjustment records a manual balance change. An APPLIED adjustment is
+// written to the ledger in the same transaction, a PENDING one waits for
+// ReviewAdjustment.
+func (c *IDBCursor) SaveAdjustment(ctx context.Context, adjustment *models.Adjustment, logger *zap.Logger) error {
+	return c.saveAdjustment(ctx, LockBalance, adjustment, logger)
+}
+
+func (c *IDBCursor) saveAdjustment(ctx context.Context, lockQuery string, adjustment *models.Adjustment, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	return c.withTx(ctx, logger, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, SaveAdjustment, adjustment.User, adjustment.Amount, adjustment.ReasonCode,
+			adjustment.Reason, adjustment.Status, adjustment.RequestedBy, adjustment.CreatedAt).Scan(&adjustment.ID)
+		if err != nil {
+			logger.Error("error during saving adjustment", zap.Error(err))
+			return err
+		}
+		if err := c.appendAudit(ctx, tx, configuration.AUDITADJUSTMENTREQUESTED, adjustment.User, nil, adjustment, logger); err != nil {
+			return err
+		}
+		if adjustment.Status != configuration.ADJUSTMENTAPPLIED {
+			return nil
+		}
+		return c.applyAdjustment(ctx, tx, lockQuery, adjustment, adjustment.CreatedAt, logger)
+	})
+}
+
+// applyAdjustment writes the adjustment to the ledger. Taking points back
+// must not leave the balance negative, so the balances row is locked first.
+func (c *IDBCursor) applyAdjustment(ctx context.Context, tx *sql.Tx, lockQuery string, adjustment *models.Adjustment, appliedAt time.Time, logger *zap.Logger) error {
+	balance := &models.Balance{User: adjustment.User}
+	if err := scanBalance(tx.QueryRowContext(ctx, lockQuery, adjustment.User), balance); err != nil {
+		logger.Error("error during locking balance", zap.Error(err))
+		return err
+	}
+	if balance.Current+adjustment.Amount < 0 {
+		return errors.ErrInsufficientFunds
+	}
+	before := *balance
+	if err := c.appendLedgerEntry(ctx, tx, adjustmentEntry(adjustment, appliedAt), balance, logger); err != nil {
+		return err
+	}
+	return c.appendAudit(ctx, tx, configuration.AUDITBALANCEADJUSTED, adjustment.User, &before, balance, logger)
+}
+
+// GetAdjustments lists the adjustments with the status, or all of them when
+// the status is empty, newest first.
+func (c *IDBCursor) GetAdjustments(ctx context.Context, status string, logger *zap.Logger) ([]*models.Adjustment, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	var rows *sql.Rows
+	var err error
+	if status == "" {
+		rows, err = c.DB.QueryContext(ctx, GetAdjustments)
+	} else {
+		rows, err = c.DB.QueryContext(ctx, GetAdjustmentsByStatus, status)
+	}
+	if err != nil {
+		logger.Error("error during getting adjustments from db", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	adjustments := []*models.Adjustment{}
+	for rows.Next() {
+		a, err := scanAdjustment(rows)
+		if err != nil {
+			logger.Error("error scanning adjustment from db", zap.Error(err))
+			return adjustments, err
+		}
+		adjustments = append(adjustments, a)
+	}
+	if err = rows.Err(); err != nil {
+		return adjustments, err
+	}
+	return adjustments, nil
+}
+
+// ReviewAdjustment approves or rejects a pending adjustment, review carries
+// its ID, the new status and the reviewer. The one who requested the
+// adjustment cannot review it. On success review is filled with the stored
+// adjustment.
+func (c *IDBCursor) ReviewAdjustment(ctx context.Context, review *models.Adjustment, logger *zap.Logger) error {
+	return c.reviewAdjustment(ctx, LockAdjustment, LockBalance, review, logger)
+}
+
+func (c *IDBCursor) reviewAdjustment(ctx context.Context, lockQuery string, lockBalanceQuery string, review *models.Adjustment, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	return c.withTx(ctx, logger, func(tx *sql.Tx) error {
+		found, err := scanAdjustment(tx.QueryRowContext(ctx, lockQuery, review.ID))
+		if err == sql.ErrNoRows {
+			return errors.ErrNotFound
+		}
+		if err != nil {
+			logger.Error("error during locking adjustment", zap.Error(err))
+			return err
+		}
+		if found.Status != configuration.ADJUSTMENTPENDING {
+			return errors.ErrNotPending
+		}
+		if found.RequestedBy == review.ReviewedBy {
+			return errors.ErrSelfApproval
+		}
+		pending := *found
+		reviewedAt := time.Now()
+		found.Status, found.ReviewedBy, found.ReviewedAt = review.Status, review.ReviewedBy, &reviewedAt
+		if found.Status == configuration.ADJUSTMENTAPPLIED {
+			if err := c.applyAdjustment(ctx, tx, lockBalanceQuery, found, reviewedAt, logger); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, ReviewAdjustment, found.ID, found.Status, found.ReviewedBy, reviewedAt); err != nil {
+			logger.Error("error during reviewing adjustment", zap.Error(err))
+			return err
+		}
+		*review = *found
+		return c.appendAudit(ctx, tx, configuration.AUDITADJUSTMENTREVIEWED, found.User, &pending, found, logger)
+	})
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestAdjustments(t *testing.T) {
+	sqlite, _ := newSQLiteTestCursor(t)
+	backends := map[string]*Cursor{
+		"memory": {NewMemoryCursor()},
+		"sqlite": sqlite,
+	}
+	for name, cursor := range backends {
+		t.Run(name, func(t *testing.T) {
+			testAdjustments(t, cursor)
+		})
+	}
+}
+
+func testAdjustments(t *testing.T, cursor *Cursor) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	adjustment := func(amount float64, status string) *models.Adjustment {
+		return &models.Adjustment{
+			User:        "test",
+			Amount:      models.NewMoney(amount),
+			ReasonCode:  configuration.GOODWILL,
+			Reason:      "outage",
+			Status:      status,
+			RequestedBy: "admin",
+			CreatedAt:   time.Now(),
+		}
+	}
+
+	applied := adjustment(10, configuration.ADJUSTMENTAPPLIED)
+	require.NoError(t, cursor.SaveAdjustment(ctx, applied, l))
+	assert.NotZero(t, applied.ID)
+	assert.Equal(t, errors.ErrInsufficientFunds,
+		cursor.SaveAdjustment(ctx, adjustment(-20, configuration.ADJUSTMENTAPPLIED), l))
+
+	pending := adjustment(-5, configuration.ADJUSTMENTPENDING)
+	require.NoError(t, cursor.SaveAdjustment(ctx, pending, l))
+	balance, err := cursor.GetUserBalance(ctx, "test", l)
+	require.NoError(t, err)
+	assert.Equal(t, models.NewMoney(10), balance.Current)
+
+	found, err := cursor.GetAdjustments(ctx, configuration.ADJUSTMENTPENDING, l)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, pending.ID, found[0].ID)
+	assert.Equal(t, models.NewMoney(-5), found[0].Amount)
+	assert.Nil(t, found[0].ReviewedAt)
+	found, err = cursor.GetAdjustments(ctx, "", l)
+	require.NoError(t, err)
+	assert.Len(t, found, 2)
+
+	review := &models.Adjustment{ID: pending.ID, Status: configuration.ADJUSTMENTAPPLIED, ReviewedBy: "admin"}
+	assert.Equal(t, errors.ErrSelfApproval, cursor.ReviewAdjustment(ctx, review, l))
+	review.ReviewedBy = "other"
+	require.NoError(t, cursor.ReviewAdjustment(ctx, review, l))
+	assert.Equal(t, configuration.ADJUSTMENTAPPLIED, review.Status)
+	assert.Equal(t, "test", review.User)
+	assert.NotNil(t, review.ReviewedAt)
+	review = &models.Adjustment{ID: pending.ID, Status: configuration.ADJUSTMENTREJECTED, ReviewedBy: "other"}
+	assert.Equal(t, errors.ErrNotPending, cursor.ReviewAdjustment(ctx, review, l))
+	review.ID = 42
+	assert.Equal(t, errors.ErrNotFound, cursor.ReviewAdjustment(ctx, review, l))
+
+	balance, err = cursor.GetUserBalance(ctx, "test", l)
+	require.NoError(t, err)
+	assert.Equal(t, models.NewMoney(5), balance.Current)
+	entries, err := cursor.GetLedger(ctx, "test", l)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "GOODWILL: outage", entries[1].Reason)
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/audit"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+	"go.uber.org/zap"
+)
+
+// AppendAudit writes an entry to the audit log. Changes to balances, orders
+// and roles are audited by the storage itself in the transaction making
+// them, this is for the actions that change nothing stored, such as logins.
+func (c *IDBCursor) AppendAudit(ctx context.Context, entry *models.AuditEntry, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	return c.insertAudit(ctx, c.DB.QueryRowContext, entry, logger)
+}
+
+// appendAudit records the action of the actor of ctx in the transaction
+// that takes it, so the change and its audit entry are stored together.
+func (c *IDBCursor) appendAudit(ctx context.Context, tx *sql.Tx, action string, subject string, before interface{}, after interface{}, logger *zap.Logger) error {
+	entry, err := audit.NewEntry(ctx, action, subject, before, after)
+	if err != nil {
+		logger.Error("error during encoding audit entry", zap.Error(err))
+		return err
+	}
+	return c.insertAudit(ctx, tx.QueryRowContext, entry, logger)
+}
+
+func (c *IDBCursor) insertAudit(ctx context.Context, queryRow func(context.Context, string, ...interface{}) *sql.Row, entry *models.AuditEntry, logger *zap.Logger) error {
+	err := queryRow(ctx, AppendAudit, entry.Actor, entry.Action, entry.Subject, entry.IP, entry.UserAgent,
+		string(entry.Before), string(entry.After), entry.CreatedAt).Scan(&entry.ID)
+	if err != nil {
+		logger.Error("error during appending audit entry", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetAuditLog returns the page of the audit log selected by query, newest
+// first.
+func (c *IDBCursor) GetAuditLog(ctx context.Context, query *models.AuditQuery, logger *zap.Logger) ([]*models.AuditEntry, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	statement, args := auditPageQuery(query)
+	rows, err := c.DB.QueryContext(ctx, statement, args...)
+	if err != nil {
+		logger.Error("error during getting audit log from db", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []*models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		var before, after string
+		err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Subject, &e.IP, &e.UserAgent, &before, &after, &e.CreatedAt)
+		if err != nil {
+			logger.Error("error scanning audit entry from db", zap.Error(err))
+			return entries, err
+		}
+		if before != "" {
+			e.Before = []byte(before)
+		}
+		if after != "" {
+			e.After = []byte(after)
+		}
+		entries = append(entries, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+	return entries, nil
+}
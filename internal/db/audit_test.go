@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/audit"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestAuditLog(t *testing.T) {
+	sqlite, _ := newSQLiteTestCursor(t)
+	backends := map[string]*Cursor{
+		"memory": {NewMemoryCursor()},
+		"sqlite": sqlite,
+	}
+	for name, cursor := range backends {
+		t.Run(name, func(t *testing.T) {
+			testAuditLog(t, cursor)
+		})
+	}
+
+	// rows cannot be changed or removed behind the storage's back
+	raw := sqlite.IDBInterface.(*SQLiteCursor).DB
+	_, err := raw.Exec(`UPDATE audit_log SET actor='nobody';`)
+	assert.Error(t, err)
+	_, err = raw.Exec(`DELETE FROM audit_log;`)
+	assert.Error(t, err)
+}
+
+func testAuditLog(t *testing.T, cursor *Cursor) {
+	l := zap.NewNop()
+	ctx := audit.NewContext(context.Background(), &audit.Actor{Username: "test", IP: "10.0.0.1", UserAgent: "phone"})
+
+	require.NoError(t, cursor.AppendAudit(ctx, &models.AuditEntry{
+		Actor:     "test",
+		Action:    configuration.AUDITLOGIN,
+		Subject:   "test",
+		CreatedAt: time.Now(),
+	}, l))
+	require.NoError(t, cursor.SaveOrder(ctx, &models.Order{Username: "test", Number: "9278923470", Status: configuration.NEW, UploadedAt: time.Now()}, l))
+	// the accrual update is made by the system
+	require.NoError(t, cursor.UpdateOrder(context.Background(), "test", &models.AccrualResponse{
+		Order:   "9278923470",
+		Status:  configuration.PROCESSED,
+		Accrual: models.NewMoney(100),
+	}, l))
+	_, err := cursor.AppendLedgerEntry(ctx, &models.LedgerEntry{User: "test", Kind: configuration.CREDIT, Amount: models.NewMoney(100), Order: "9278923470", CreatedAt: time.Now()}, l)
+	require.NoError(t, err)
+	_, err = cursor.Withdraw(ctx, &models.Withdrawal{User: "test", Order: "2377225624", Sum: models.NewMoney(40), ProcessedAt: time.Now()}, l)
+	require.NoError(t, err)
+
+	entries, err := cursor.GetAuditLog(ctx, &models.AuditQuery{}, l)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	actions := []string{entries[0].Action, entries[1].Action, entries[2].Action}
+	assert.Equal(t, []string{configuration.AUDITWITHDRAWAL, configuration.AUDITORDERSTATUS, configuration.AUDITLOGIN}, actions)
+
+	withdrawal := entries[0]
+	assert.Equal(t, "test", withdrawal.Actor)
+	assert.Equal(t, "test", withdrawal.Subject)
+	assert.Equal(t, "10.0.0.1", withdrawal.IP)
+	assert.Equal(t, "phone", withdrawal.UserAgent)
+	before, after := &models.Balance{}, &models.Balance{}
+	require.NoError(t, json.Unmarshal(withdrawal.Before, before))
+	require.NoError(t, json.Unmarshal(withdrawal.After, after))
+	assert.Equal(t, models.NewMoney(100), before.Current)
+	assert.Equal(t, models.NewMoney(60), after.Current)
+	assert.Equal(t, models.NewMoney(40), after.Withdrawn)
+
+	transition := entries[1]
+	assert.Equal(t, configuration.SYSTEMACTOR, transition.Actor)
+	order := &models.Order{}
+	require.NoError(t, json.Unmarshal(transition.Before, order))
+	assert.Equal(t, configuration.NEW, order.Status)
+	require.NoError(t, json.Unmarshal(transition.After, order))
+	assert.Equal(t, configuration.PROCESSED, order.Status)
+	assert.Nil(t, entries[2].Before)
+
+	entries, err = cursor.GetAuditLog(ctx, &models.AuditQuery{Actor: configuration.SYSTEMACTOR}, l)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	entries, err = cursor.GetAuditLog(ctx, &models.AuditQuery{Action: configuration.AUDITLOGIN, Subject: "test"}, l)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	entries, err = cursor.GetAuditLog(ctx, &models.AuditQuery{To: time.Now().Add(-time.Hour)}, l)
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+
+	page, err := cursor.GetAuditLog(ctx, &models.AuditQuery{Limit: 2}, l)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	next := &models.PageCursor{Time: page[1].CreatedAt, Key: strconv.FormatInt(page[1].ID, 10)}
+	page, err = cursor.GetAuditLog(ctx, &models.AuditQuery{Limit: 2, After: next}, l)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, configuration.AUDITLOGIN, page[0].Action)
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"go.uber.org/zap"
+	"strings"
 	"time"
 
 	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
@@ -17,29 +18,83 @@ import (
 )
 
 type IDBInterface interface {
-	SaveUserInfo(*models.UserInfo, *zap.Logger) error
-	GetUserInfo(*models.UserInfo, *zap.Logger) (*models.UserInfo, error)
-	SaveSession(string, *models.Session, *zap.Logger) error
-	GetSession(string, *zap.Logger) (*models.Session, error)
-	GetOrder(string, string, *zap.Logger) (*models.Order, error)
-	SaveOrder(*models.Order, *zap.Logger) error
-	GetOrders(string, *zap.Logger) ([]*models.Order, error)
-	GetUsernameByToken(string, *zap.Logger) (string, error)
-	GetUserBalance(string, *zap.Logger) (*models.Balance, error)
-	UpdateUserBalance(string, *models.Balance, *zap.Logger) (*models.Balance, error)
-	GetWithdrawals(string, *zap.Logger) ([]*models.Withdrawal, error)
-	SaveWithdrawal(*models.Withdrawal, *zap.Logger) error
-	SaveUserBalance(string, *models.Balance, *zap.Logger) (*models.Balance, error)
-	UpdateOrder(string, *models.AccrualResponse, *zap.Logger) error
-	GetAllOrders() ([]*models.Order, error)
+	SaveUserInfo(context.Context, *models.UserInfo, *zap.Logger) error
+	GetUserInfo(context.Context, *models.UserInfo, *zap.Logger) (*models.UserInfo, error)
+	UpdateUserPassword(context.Context, string, string, *zap.Logger) error
+	SetUserRole(context.Context, string, string, *zap.Logger) error
+	SaveSession(context.Context, string, *models.Session, *zap.Logger) error
+	GetSession(context.Context, string, *zap.Logger) (*models.Session, error)
+	RefreshSession(context.Context, string, *models.Session, *zap.Logger) error
+	GetSessions(context.Context, string, *zap.Logger) ([]*models.Session, error)
+	GetSessionByID(context.Context, string, int64, *zap.Logger) (*models.Session, error)
+	DeleteSession(context.Context, string, int64, *zap.Logger) error
+	DeleteExpiredSessions(context.Context, time.Time, *zap.Logger) (int64, error)
+	GetOrder(context.Context, string, string, *zap.Logger) (*models.Order, error)
+	SaveOrder(context.Context, *models.Order, *zap.Logger) error
+	GetOrders(context.Context, string, *models.OrderQuery, *zap.Logger) ([]*models.Order, error)
+	GetUsernameByToken(context.Context, string, *zap.Logger) (string, error)
+	GetUserBalance(context.Context, string, *zap.Logger) (*models.Balance, error)
+	GetWithdrawals(context.Context, string, *models.WithdrawalQuery, *zap.Logger) ([]*models.Withdrawal, error)
+	SaveWithdrawal(context.Context, *models.Withdrawal, *zap.Logger) error
+	SaveUserBalance(context.Context, string, *models.Balance, *zap.Logger) (*models.Balance, error)
+	UpdateOrder(context.Context, string, *models.AccrualResponse, *zap.Logger) error
+	ApplyAccrual(context.Context, string, *models.AccrualResponse, *zap.Logger) error
+	GetAllOrders(context.Context) ([]*models.Order, error)
+	EnqueueJob(context.Context, *models.AccrualJob, *zap.Logger) error
+	ClaimJobs(context.Context, int, time.Duration, *zap.Logger) ([]*models.AccrualJob, error)
+	CompleteJob(context.Context, string, *zap.Logger) error
+	RescheduleJob(context.Context, string, time.Time, *zap.Logger) error
+	FailJob(context.Context, string, string, time.Time, *zap.Logger) error
+	BuryJob(context.Context, string, string, *zap.Logger) error
+	GetDeadJobs(context.Context, *zap.Logger) ([]*models.AccrualJob, error)
+	RedriveJob(context.Context, string, *zap.Logger) error
+	AppendLedgerEntry(context.Context, *models.LedgerEntry, *zap.Logger) (*models.Balance, error)
+	GetLedger(context.Context, string, *zap.Logger) ([]*models.LedgerEntry, error)
+	Withdraw(context.Context, *models.Withdrawal, *zap.Logger) (*models.Balance, error)
+	SaveWebhook(context.Context, *models.Webhook, *zap.Logger) error
+	GetWebhooks(context.Context, string, *zap.Logger) ([]*models.Webhook, error)
+	DeleteWebhook(context.Context, string, int64, *zap.Logger) error
+	EnqueueDeliveries(context.Context, string, *models.WebhookEvent, []byte, *zap.Logger) error
+	ClaimDeliveries(context.Context, int, time.Duration, *zap.Logger) ([]*models.WebhookDelivery, error)
+	SaveDeliveryAttempt(context.Context, *models.WebhookDelivery, *zap.Logger) error
+	GetDeliveries(context.Context, string, int64, *zap.Logger) ([]*models.WebhookDelivery, error)
+	ClaimOutbox(context.Context, int, time.Duration, *zap.Logger) ([]*models.OutboxEvent, error)
+	DeleteOutboxEvent(context.Context, int64, *zap.Logger) error
+	BroadcastEvent(context.Context, *models.OutboxEvent, *zap.Logger) error
+	ListenEvents(context.Context, func(context.Context, *models.OutboxEvent) error, *zap.Logger) error
+	SaveAdjustment(context.Context, *models.Adjustment, *zap.Logger) error
+	GetAdjustments(context.Context, string, *zap.Logger) ([]*models.Adjustment, error)
+	ReviewAdjustment(context.Context, *models.Adjustment, *zap.Logger) error
+	AppendAudit(context.Context, *models.AuditEntry, *zap.Logger) error
+	GetAuditLog(context.Context, *models.AuditQuery, *zap.Logger) ([]*models.AuditEntry, error)
+	GetLoginAttempts(context.Context, string, string, *zap.Logger) (*models.LoginAttempts, error)
+	RecordLoginAttempt(context.Context, *models.LoginAttempts, time.Duration, *zap.Logger) (bool, error)
+	ReleaseLoginAttempt(context.Context, string, string, *zap.Logger) error
+	LockLogin(context.Context, *models.LoginAttempts, *zap.Logger) error
+	ResetLoginAttempts(context.Context, string, string, *zap.Logger) error
+	DeleteStaleLoginAttempts(context.Context, time.Time, time.Duration, *zap.Logger) (int64, error)
 }
 
 type Cursor struct {
 	IDBInterface
 }
 
-func GetCursor(url string, logger *zap.Logger) (*Cursor, error) {
-	cursor, err := NewCursor(url, logger)
+// GetCursor picks the storage backend by DATABASE_URI. An empty URI or the
+// memory:// scheme keeps everything in memory, sqlite:// opens a SQLite file
+// and anything else is Postgres.
+func GetCursor(url string, timeout time.Duration, logger *zap.Logger) (*Cursor, error) {
+	if url == "" || strings.HasPrefix(url, configuration.MEMORYSCHEME) {
+		logger.Info("Using in-memory storage")
+		return &Cursor{NewMemoryCursor()}, nil
+	}
+	if strings.HasPrefix(url, configuration.SQLITESCHEME) {
+		cursor, err := NewSQLiteCursor(url, timeout, logger)
+		if err != nil {
+			return nil, err
+		}
+		return &Cursor{cursor}, nil
+	}
+	cursor, err := NewCursor(url, timeout, logger)
 	if err != nil {
 		return nil, err
 	}
@@ -48,20 +103,23 @@ func GetCursor(url string, logger *zap.Logger) (*Cursor, error) {
 
 type IDBCursor struct {
 	IDBInterface
-	DB      *sql.DB
-	Context context.Context
+	DB *sql.DB
+	// Timeout bounds every query on top of the caller's context. Zero means
+	// queries only end with the caller's context.
+	Timeout time.Duration
 	Logger  *zap.Logger
 }
 
-func RunMigrations(databaseURL string, logger *zap.Logger) error {
+func RunMigrations(sourceURL string, databaseURL string, logger *zap.Logger) error {
 	m, err := migrate.New(
-		"file://./migrations",
+		sourceURL,
 		databaseURL)
 	if err != nil {
 		logger.Info("Error creating migration: ", zap.String("", err.Error()))
 		return errors.ErrDatabaseMigration
 	}
-	if err := m.Up(); err != nil {
+	defer m.Close()
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
 		logger.Info("Error executing migration: ", zap.String("", err.Error()))
 		return errors.ErrDatabaseMigration
 	}
@@ -69,7 +127,7 @@ func RunMigrations(databaseURL string, logger *zap.Logger) error {
 	return nil
 }
 
-func NewCursor(IDBURL string, logger *zap.Logger) (*IDBCursor, error) {
+func NewCursor(IDBURL string, timeout time.Duration, logger *zap.Logger) (*IDBCursor, error) {
 	db, err := sql.Open("pgx", IDBURL)
 	if err != nil {
 		logger.Info("Unable to connect to database: ", zap.String("", err.Error()))
@@ -77,19 +135,47 @@ func NewCursor(IDBURL string, logger *zap.Logger) (*IDBCursor, error) {
 	}
 	n := &IDBCursor{
 		DB:      db,
-		Context: context.Background(),
+		Timeout: timeout,
+		Logger:  logger,
 	}
-	if err := n.Ping(logger); err != nil {
+	if err := n.Ping(context.Background(), logger); err != nil {
 		logger.Info("DB ping error", zap.String("", err.Error()))
 		return nil, err
 	}
-	err = RunMigrations(IDBURL, logger)
+	err = RunMigrations(configuration.MIGRATIONS, IDBURL, logger)
 	if err != nil {
 		return nil, err
 	}
 	return n, nil
 }
 
+// queryContext derives the context for a single query or transaction.
+func (c *IDBCursor) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.Timeout)
+}
+
+func (c *IDBCursor) withTx(ctx context.Context, logger *zap.Logger, fn func(tx *sql.Tx) error) error {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Error("error starting transaction", zap.Error(err))
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			logger.Error("error rolling back transaction", zap.Error(rbErr))
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("error committing transaction", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
 func (c *IDBCursor) Close() {
 	err := c.DB.Close()
 	if err != nil {
@@ -97,8 +183,8 @@ func (c *IDBCursor) Close() {
 	}
 }
 
-func (c *IDBCursor) Ping(logger *zap.Logger) error {
-	ctx, cancel := context.WithTimeout(c.Context, configuration.IDBTIMEOUT*time.Second)
+func (c *IDBCursor) Ping(ctx context.Context, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
 	defer cancel()
 	if err := c.DB.PingContext(ctx); err != nil {
 		logger.Info("Ping error, database unreachable?", zap.String("", err.Error()))
@@ -107,8 +193,12 @@ func (c *IDBCursor) Ping(logger *zap.Logger) error {
 	return nil
 }
 
-func (c *IDBCursor) SaveSession(id string, session *models.Session, logger *zap.Logger) error {
-	_, err := c.DB.ExecContext(c.Context, SaveSession, session.Username, session.Token, session.ExpiresAt)
+func (c *IDBCursor) SaveSession(ctx context.Context, id string, session *models.Session, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	prepareSession(session)
+	err := c.DB.QueryRowContext(ctx, SaveSession, session.Username, session.Token, session.ExpiresAt, session.RefreshToken,
+		session.RefreshExpiresAt, session.CreatedAt, session.UserAgent, session.IP).Scan(&session.ID)
 	if err != nil {
 		logger.Info("error inserting row to db: ", zap.String("", err.Error()))
 		return err
@@ -116,8 +206,10 @@ func (c *IDBCursor) SaveSession(id string, session *models.Session, logger *zap.
 	return nil
 }
 
-func (c *IDBCursor) SaveUserInfo(info *models.UserInfo, logger *zap.Logger) error {
-	_, err := c.DB.ExecContext(c.Context, SaveUserInfo, info.Username, info.Password)
+func (c *IDBCursor) SaveUserInfo(ctx context.Context, info *models.UserInfo, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	_, err := c.DB.ExecContext(ctx, SaveUserInfo, info.Username, info.Password, userRole(info))
 	if err != nil {
 		logger.Info("error inserting row into Userinfo: %e", zap.String("", err.Error()))
 		return err
@@ -125,14 +217,28 @@ func (c *IDBCursor) SaveUserInfo(info *models.UserInfo, logger *zap.Logger) erro
 	return nil
 }
 
-func (c *IDBCursor) GetUserInfo(info *models.UserInfo, logger *zap.Logger) (*models.UserInfo, error) {
+// userRole is the role a new user gets, users are ordinary unless told
+// otherwise.
+func userRole(info *models.UserInfo) string {
+	if info.Role == "" {
+		return configuration.ROLEUSER
+	}
+	return info.Role
+}
+
+func (c *IDBCursor) GetUserInfo(ctx context.Context, info *models.UserInfo, logger *zap.Logger) (*models.UserInfo, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
 	var row *sql.Row
-	if row = c.DB.QueryRowContext(c.Context, GetUserInfo, info.Username); row.Err() != nil {
+	if row = c.DB.QueryRowContext(ctx, GetUserInfo, info.Username); row.Err() != nil {
 		logger.Info("error during getting user info from db: %e", zap.Error(row.Err()))
 		return nil, row.Err()
 	}
 	foundInfo := &models.UserInfo{}
-	err := row.Scan(&foundInfo.Username, &foundInfo.Password)
+	err := row.Scan(&foundInfo.Username, &foundInfo.Password, &foundInfo.Role)
+	if err == sql.ErrNoRows {
+		return nil, errors.ErrNotFound
+	}
 	if err != nil {
 		logger.Info("error scanning userinfo from db", zap.String("", err.Error()))
 		return nil, err
@@ -140,9 +246,45 @@ func (c *IDBCursor) GetUserInfo(info *models.UserInfo, logger *zap.Logger) (*mod
 	return foundInfo, nil
 }
 
-func (c *IDBCursor) GetOrder(username string, number string, logger *zap.Logger) (*models.Order, error) {
+func (c *IDBCursor) UpdateUserPassword(ctx context.Context, username string, hash string, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	_, err := c.DB.ExecContext(ctx, UpdatePassword, username, hash)
+	if err != nil {
+		logger.Error("error during updating user password", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// SetUserRole changes the role of the user and audits the change.
+func (c *IDBCursor) SetUserRole(ctx context.Context, username string, role string, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	return c.withTx(ctx, logger, func(tx *sql.Tx) error {
+		before := &models.UserInfo{}
+		err := tx.QueryRowContext(ctx, GetUserInfo, username).Scan(&before.Username, &before.Password, &before.Role)
+		if err == sql.ErrNoRows {
+			return errors.ErrNotFound
+		}
+		if err != nil {
+			logger.Error("error during getting user info from db", zap.Error(err))
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, SetUserRole, username, role); err != nil {
+			logger.Error("error during setting user role", zap.Error(err))
+			return err
+		}
+		return c.appendAudit(ctx, tx, configuration.AUDITROLECHANGED, username,
+			&models.RolePost{Role: before.Role}, &models.RolePost{Role: role}, logger)
+	})
+}
+
+func (c *IDBCursor) GetOrder(ctx context.Context, username string, number string, logger *zap.Logger) (*models.Order, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
 	var row *sql.Row
-	if row = c.DB.QueryRowContext(c.Context, GetOrder, username, number); row.Err() != nil {
+	if row = c.DB.QueryRowContext(ctx, GetOrder, username, number); row.Err() != nil {
 		logger.Info("error during getting order from db", zap.Error(row.Err()))
 		return nil, row.Err()
 	}
@@ -159,8 +301,10 @@ func (c *IDBCursor) GetOrder(username string, number string, logger *zap.Logger)
 	return foundOrder, nil
 }
 
-func (c *IDBCursor) SaveOrder(order *models.Order, logger *zap.Logger) error {
-	_, err := c.DB.ExecContext(c.Context, SaveOrder, order.Username, order.Number, order.Status, order.Accrual, order.UploadedAt)
+func (c *IDBCursor) SaveOrder(ctx context.Context, order *models.Order, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	_, err := c.DB.ExecContext(ctx, SaveOrder, order.Username, order.Number, order.Status, order.Accrual, order.UploadedAt)
 	if err != nil {
 		logger.Error("error during saving order to db", zap.Error(err))
 		return err
@@ -168,12 +312,21 @@ func (c *IDBCursor) SaveOrder(order *models.Order, logger *zap.Logger) error {
 	return nil
 }
 
-func (c *IDBCursor) GetOrders(username string, logger *zap.Logger) ([]*models.Order, error) {
-	rows, err := c.DB.QueryContext(c.Context, GetOrders, username)
+// GetOrders returns the page of the user's orders selected by query. A nil
+// query returns all of them, newest first.
+func (c *IDBCursor) GetOrders(ctx context.Context, username string, query *models.OrderQuery, logger *zap.Logger) ([]*models.Order, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	if query == nil {
+		query = &models.OrderQuery{}
+	}
+	statement, args := ordersPageQuery(username, query)
+	rows, err := c.DB.QueryContext(ctx, statement, args...)
 	if err != nil {
 		logger.Error("error during getting orders from db", zap.Error(err))
 		return nil, err
 	}
+	defer rows.Close()
 	if rows.Err() != nil {
 		logger.Error("error during getting orders from db", zap.Error(rows.Err()))
 		return nil, rows.Err()
@@ -190,9 +343,11 @@ func (c *IDBCursor) GetOrders(username string, logger *zap.Logger) ([]*models.Or
 	return foundOrders, nil
 }
 
-func (c *IDBCursor) GetUsernameByToken(token string, logger *zap.Logger) (string, error) {
+func (c *IDBCursor) GetUsernameByToken(ctx context.Context, token string, logger *zap.Logger) (string, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
 	var row *sql.Row
-	if row = c.DB.QueryRowContext(c.Context, GetSessionUser, token); row.Err() != nil {
+	if row = c.DB.QueryRowContext(ctx, GetSessionUser, token); row.Err() != nil {
 		logger.Error("error during getting current session user from db", zap.Error(row.Err()))
 		return "", row.Err()
 	}
@@ -205,9 +360,11 @@ func (c *IDBCursor) GetUsernameByToken(token string, logger *zap.Logger) (string
 	return foundSession.Username, nil
 }
 
-func (c *IDBCursor) GetUserBalance(username string, logger *zap.Logger) (*models.Balance, error) {
+func (c *IDBCursor) GetUserBalance(ctx context.Context, username string, logger *zap.Logger) (*models.Balance, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
 	var row *sql.Row
-	if row = c.DB.QueryRowContext(c.Context, GetBalance, username); row.Err() != nil {
+	if row = c.DB.QueryRowContext(ctx, GetBalance, username); row.Err() != nil {
 		logger.Error("error during getting user balance from db", zap.Error(row.Err()))
 		return nil, row.Err()
 	}
@@ -224,8 +381,10 @@ func (c *IDBCursor) GetUserBalance(username string, logger *zap.Logger) (*models
 	return foundBalance, nil
 }
 
-func (c *IDBCursor) SaveUserBalance(username string, newBalance *models.Balance, logger *zap.Logger) (*models.Balance, error) {
-	_, err := c.DB.ExecContext(c.Context, SaveBalance, username, newBalance.Current, newBalance.Withdrawn)
+func (c *IDBCursor) SaveUserBalance(ctx context.Context, username string, newBalance *models.Balance, logger *zap.Logger) (*models.Balance, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	_, err := c.DB.ExecContext(ctx, SaveBalance, username, newBalance.Current, newBalance.Withdrawn)
 	if err != nil {
 		logger.Error("error during saving balance for user", zap.Error(err))
 		return nil, err
@@ -235,23 +394,22 @@ func (c *IDBCursor) SaveUserBalance(username string, newBalance *models.Balance,
 	return newBalance, nil
 }
 
-func (c *IDBCursor) UpdateUserBalance(username string, newBalance *models.Balance, logger *zap.Logger) (*models.Balance, error) {
-	_, err := c.DB.ExecContext(c.Context, UpdateBalance, newBalance.Current, newBalance.Withdrawn, username)
-	if err != nil {
-		logger.Error("error during updating balance", zap.Error(err))
-		return nil, err
+// GetWithdrawals returns the page of the user's withdrawals selected by
+// query. A nil query returns all of them, newest first.
+func (c *IDBCursor) GetWithdrawals(ctx context.Context, username string, query *models.WithdrawalQuery, logger *zap.Logger) ([]*models.Withdrawal, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	if query == nil {
+		query = &models.WithdrawalQuery{}
 	}
-	logger.Info("Balance updated for user", zap.String("", username))
-	return newBalance, nil
-}
-
-func (c *IDBCursor) GetWithdrawals(username string, logger *zap.Logger) ([]*models.Withdrawal, error) {
-	rows, err := c.DB.QueryContext(c.Context, GetWithdrawals, username)
+	statement, args := withdrawalsPageQuery(username, query)
+	rows, err := c.DB.QueryContext(ctx, statement, args...)
 
 	if err != nil {
 		logger.Error("error during getting withdrawals from db: %e", zap.Error(err))
 		return nil, err
 	}
+	defer rows.Close()
 	if rows.Err() != nil {
 		logger.Error("error during getting withdrawals from db: %e", zap.Error(rows.Err()))
 		return nil, rows.Err()
@@ -271,39 +429,121 @@ func (c *IDBCursor) GetWithdrawals(username string, logger *zap.Logger) ([]*mode
 	return foundWithdrawals, nil
 }
 
-func (c *IDBCursor) SaveWithdrawal(withdrawal *models.Withdrawal, logger *zap.Logger) error {
-	_, err := c.DB.ExecContext(c.Context, SaveWithdrawal, withdrawal.User, withdrawal.Order, withdrawal.Sum, withdrawal.ProcessedAt)
+func (c *IDBCursor) SaveWithdrawal(ctx context.Context, withdrawal *models.Withdrawal, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	return c.withTx(ctx, logger, func(tx *sql.Tx) error {
+		return c.saveWithdrawal(ctx, tx, withdrawal, logger)
+	})
+}
+
+func (c *IDBCursor) saveWithdrawal(ctx context.Context, tx *sql.Tx, withdrawal *models.Withdrawal, logger *zap.Logger) error {
+	_, err := tx.ExecContext(ctx, SaveWithdrawal, withdrawal.User, withdrawal.Order, withdrawal.Sum, withdrawal.ProcessedAt)
 	if err != nil {
 		logger.Error("error during saving withdrawal to db", zap.Error(err))
 		return err
 	}
-	return nil
+	return c.appendOutbox(ctx, tx, withdrawal.User, configuration.WITHDRAWALCREATED, withdrawal, logger)
+}
+
+// UpdateOrder stores the accrual result and records the order events in the
+// same transaction when the status or accrual actually changed. Status
+// transitions are audited as well.
+func (c *IDBCursor) UpdateOrder(ctx context.Context, username string, from *models.AccrualResponse, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	return c.withTx(ctx, logger, func(tx *sql.Tx) error {
+		err := c.updateOrder(ctx, tx, username, from, logger)
+		if err == errors.ErrNotFound {
+			return nil
+		}
+		return err
+	})
 }
 
-func (c *IDBCursor) UpdateOrder(username string, from *models.AccrualResponse, logger *zap.Logger) error {
+// ApplyAccrual stores the accrual result like UpdateOrder and credits the
+// accrual of a processed order in the same transaction, so an order is never
+// left processed but not credited.
+func (c *IDBCursor) ApplyAccrual(ctx context.Context, username string, from *models.AccrualResponse, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	return c.withTx(ctx, logger, func(tx *sql.Tx) error {
+		err := c.updateOrder(ctx, tx, username, from, logger)
+		if err == errors.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		entry := accrualEntry(username, from)
+		if entry == nil {
+			return nil
+		}
+		return c.appendLedgerEntry(ctx, tx, entry, &models.Balance{User: username}, logger)
+	})
+}
+
+// updateOrder stores the accrual result of the user's order, ErrNotFound if
+// there is no such order.
+func (c *IDBCursor) updateOrder(ctx context.Context, tx *sql.Tx, username string, from *models.AccrualResponse, logger *zap.Logger) error {
 	var status string
 	if from.Status == configuration.REGISTERED {
 		status = configuration.PROCESSING
 	} else {
 		status = from.Status
 	}
-	_, err := c.DB.ExecContext(c.Context, UpdateOrder, status, from.Accrual, username, from.Order)
+	before := &models.Order{}
+	err := tx.QueryRowContext(ctx, GetOrder, username, from.Order).
+		Scan(&before.Username, &before.Number, &before.Status, &before.Accrual, &before.UploadedAt)
+	if err == sql.ErrNoRows {
+		return errors.ErrNotFound
+	}
 	if err != nil {
+		logger.Error("error during getting order from db", zap.Error(err))
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, UpdateOrder, status, from.Accrual, username, from.Order); err != nil {
 		logger.Error("error during updating order: %e", zap.Error(err))
 		return err
 	}
-	return nil
+	after := *before
+	after.Status, after.Accrual = status, from.Accrual
+	for _, eventType := range orderEvents(before, &after) {
+		if err := c.appendOutbox(ctx, tx, username, eventType, &after, logger); err != nil {
+			return err
+		}
+	}
+	if before.Status == after.Status {
+		return nil
+	}
+	return c.appendAudit(ctx, tx, configuration.AUDITORDERSTATUS, username, before, &after, logger)
 }
 
-func (c *IDBCursor) GetSession(token string, logger *zap.Logger) (*models.Session, error) {
+// accrualEntry is the credit of a processed order's accrual, nil for the
+// results that credit nothing.
+func accrualEntry(username string, from *models.AccrualResponse) *models.LedgerEntry {
+	if from.Status != configuration.PROCESSED || from.Accrual <= 0 {
+		return nil
+	}
+	return &models.LedgerEntry{
+		User:      username,
+		Kind:      configuration.CREDIT,
+		Amount:    from.Accrual,
+		Order:     from.Order,
+		Reason:    "accrual",
+		CreatedAt: time.Now(),
+	}
+}
+
+func (c *IDBCursor) GetSession(ctx context.Context, token string, logger *zap.Logger) (*models.Session, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
 	var row *sql.Row
-	if row = c.DB.QueryRowContext(c.Context, GetSession, token); row.Err() != nil {
+	if row = c.DB.QueryRowContext(ctx, GetSession, token); row.Err() != nil {
 		logger.Error("error during getting user session from db: %e", zap.Error(row.Err()))
 		return nil, row.Err()
 	}
-	foundSession := &models.Session{}
-
-	err := row.Scan(&foundSession.Username, &foundSession.Token, &foundSession.ExpiresAt)
+	foundSession, err := scanSession(row)
 	if err != nil {
 		logger.Error("error scanning session from db", zap.String("", err.Error()))
 		return nil, err
@@ -311,13 +551,129 @@ func (c *IDBCursor) GetSession(token string, logger *zap.Logger) (*models.Sessio
 	return foundSession, nil
 }
 
-func (c *IDBCursor) GetAllOrders() ([]*models.Order, error) {
-	rows, err := c.DB.QueryContext(c.Context, GetAllOrders)
+// RefreshSession swaps the tokens of the session holding the refresh token
+// for those in session, so each refresh token works only once. The address
+// is updated, the rest of the session is read into session.
+func (c *IDBCursor) RefreshSession(ctx context.Context, refreshToken string, session *models.Session, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	err := c.DB.QueryRowContext(ctx, RefreshSession, refreshToken, session.Token, session.ExpiresAt, session.RefreshToken,
+		session.RefreshExpiresAt, session.IP, time.Now()).Scan(&session.ID, &session.Username, &session.CreatedAt, &session.UserAgent, &session.Role)
+	if err == sql.ErrNoRows {
+		return errors.ErrNotFound
+	}
+	if err != nil {
+		logger.Error("error during refreshing session", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetSessionByID returns the session of the user with the current role of
+// the user, as long as the session can still be refreshed. Revoked and
+// ended sessions are ErrNotFound.
+func (c *IDBCursor) GetSessionByID(ctx context.Context, username string, id int64, logger *zap.Logger) (*models.Session, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	session, err := scanSession(c.DB.QueryRowContext(ctx, GetSessionByID, username, id, time.Now()))
+	if err == sql.ErrNoRows {
+		return nil, errors.ErrNotFound
+	}
+	if err != nil {
+		logger.Error("error during getting session from db", zap.Error(err))
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetSessions lists the sessions of the user that can still be refreshed,
+// newest first.
+func (c *IDBCursor) GetSessions(ctx context.Context, username string, logger *zap.Logger) ([]*models.Session, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	rows, err := c.DB.QueryContext(ctx, GetSessions, username, time.Now())
+	if err != nil {
+		logger.Error("error during getting sessions from db", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	foundSessions := []*models.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			logger.Error("error scanning session from db", zap.Error(err))
+			return foundSessions, err
+		}
+		foundSessions = append(foundSessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		return foundSessions, err
+	}
+	return foundSessions, nil
+}
+
+func (c *IDBCursor) DeleteSession(ctx context.Context, username string, id int64, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	res, err := c.DB.ExecContext(ctx, DeleteSession, username, id)
+	if err != nil {
+		logger.Error("error during deleting session", zap.Error(err))
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteExpiredSessions purges the sessions that can no longer be refreshed
+// at now and returns how many there were.
+func (c *IDBCursor) DeleteExpiredSessions(ctx context.Context, now time.Time, logger *zap.Logger) (int64, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	res, err := c.DB.ExecContext(ctx, DeleteExpiredSessions, now)
+	if err != nil {
+		logger.Error("error during deleting expired sessions", zap.Error(err))
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row scanner) (*models.Session, error) {
+	var s models.Session
+	err := row.Scan(&s.ID, &s.Username, &s.Token, &s.ExpiresAt, &s.RefreshToken, &s.RefreshExpiresAt, &s.CreatedAt, &s.UserAgent, &s.IP, &s.Role)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// prepareSession fills in what callers may leave out: the creation time,
+// and the refresh expiry of a session that has no refresh token.
+func prepareSession(session *models.Session) {
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+	if session.RefreshToken == "" || session.RefreshExpiresAt.Before(session.ExpiresAt) {
+		session.RefreshExpiresAt = session.ExpiresAt
+	}
+}
+
+func (c *IDBCursor) GetAllOrders(ctx context.Context) ([]*models.Order, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	rows, err := c.DB.QueryContext(ctx, GetAllOrders)
 
 	if err != nil {
 		c.Logger.Error("error during getting all orders from db: %e", zap.String("", err.Error()))
 		return nil, err
 	}
+	defer rows.Close()
 	if rows.Err() != nil {
 		c.Logger.Error("error during getting all orders from db: %e", zap.Error(rows.Err()))
 		return nil, rows.Err()
@@ -333,3 +689,422 @@ func (c *IDBCursor) GetAllOrders() ([]*models.Order, error) {
 	}
 	return foundOrders, nil
 }
+
+func (c *IDBCursor) EnqueueJob(ctx context.Context, job *models.AccrualJob, logger *zap.Logger) error {
+	return c.enqueueJob(ctx, EnqueueJob, job, logger)
+}
+
+func (c *IDBCursor) enqueueJob(ctx context.Context, query string, job *models.AccrualJob, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	_, err := c.DB.ExecContext(ctx, query, job.Number, job.Username, job.CreatedAt)
+	if err != nil {
+		logger.Error("error during enqueueing accrual job", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ClaimJobs locks up to limit free jobs for the given lease. Rows locked by
+// concurrent claimers are skipped, so several instances can share the queue.
+func (c *IDBCursor) ClaimJobs(ctx context.Context, limit int, lease time.Duration, logger *zap.Logger) ([]*models.AccrualJob, error) {
+	return c.claimJobs(ctx, ClaimJobs, limit, lease, logger)
+}
+
+func (c *IDBCursor) claimJobs(ctx context.Context, query string, limit int, lease time.Duration, logger *zap.Logger) ([]*models.AccrualJob, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	now := time.Now()
+	rows, err := c.DB.QueryContext(ctx, query, now, now.Add(lease), limit)
+	if err != nil {
+		logger.Error("error during claiming accrual jobs", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	claimedJobs := []*models.AccrualJob{}
+	for rows.Next() {
+		var j models.AccrualJob
+		if err = rows.Scan(&j.Number, &j.Username, &j.CreatedAt, &j.LockedUntil, &j.NextAttemptAt, &j.Attempts, &j.LastError); err != nil {
+			logger.Error("error scanning accrual job from db", zap.Error(err))
+			return claimedJobs, err
+		}
+		claimedJobs = append(claimedJobs, &j)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Error("error during claiming accrual jobs", zap.Error(err))
+		return claimedJobs, err
+	}
+	return claimedJobs, nil
+}
+
+func (c *IDBCursor) CompleteJob(ctx context.Context, number string, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	_, err := c.DB.ExecContext(ctx, CompleteJob, number)
+	if err != nil {
+		logger.Error("error during completing accrual job", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// RescheduleJob unlocks the job and makes it due again at the given time.
+func (c *IDBCursor) RescheduleJob(ctx context.Context, number string, at time.Time, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	_, err := c.DB.ExecContext(ctx, RescheduleJob, number, at)
+	if err != nil {
+		logger.Error("error during rescheduling accrual job", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// FailJob records a failed attempt and schedules the next one.
+func (c *IDBCursor) FailJob(ctx context.Context, number string, lastError string, at time.Time, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	_, err := c.DB.ExecContext(ctx, FailJob, number, at, lastError)
+	if err != nil {
+		logger.Error("error during failing accrual job", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// BuryJob moves a job that exhausted its retry budget to the dead-letter table.
+func (c *IDBCursor) BuryJob(ctx context.Context, number string, lastError string, logger *zap.Logger) error {
+	return c.buryJob(ctx, BuryJob, number, lastError, logger)
+}
+
+func (c *IDBCursor) buryJob(ctx context.Context, query string, number string, lastError string, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	return c.withTx(ctx, logger, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, query, number, lastError, time.Now()); err != nil {
+			logger.Error("error during burying accrual job", zap.Error(err))
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, CompleteJob, number); err != nil {
+			logger.Error("error during burying accrual job", zap.Error(err))
+			return err
+		}
+		return nil
+	})
+}
+
+func (c *IDBCursor) GetDeadJobs(ctx context.Context, logger *zap.Logger) ([]*models.AccrualJob, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	rows, err := c.DB.QueryContext(ctx, GetDeadJobs)
+	if err != nil {
+		logger.Error("error during getting dead accrual jobs", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	deadJobs := []*models.AccrualJob{}
+	for rows.Next() {
+		var j models.AccrualJob
+		if err = rows.Scan(&j.Number, &j.Username, &j.CreatedAt, &j.Attempts, &j.LastError, &j.FailedAt); err != nil {
+			logger.Error("error scanning dead accrual job from db", zap.Error(err))
+			return deadJobs, err
+		}
+		deadJobs = append(deadJobs, &j)
+	}
+	if err = rows.Err(); err != nil {
+		return deadJobs, err
+	}
+	return deadJobs, nil
+}
+
+// RedriveJob puts a dead job back into the queue with a fresh retry budget.
+func (c *IDBCursor) RedriveJob(ctx context.Context, number string, logger *zap.Logger) error {
+	return c.redriveJob(ctx, RedriveJob, number, logger)
+}
+
+func (c *IDBCursor) redriveJob(ctx context.Context, query string, number string, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	return c.withTx(ctx, logger, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, query, number, time.Now()); err != nil {
+			logger.Error("error during redriving accrual job", zap.Error(err))
+			return err
+		}
+		res, err := tx.ExecContext(ctx, DeleteDeadJob, number)
+		if err != nil {
+			logger.Error("error during redriving accrual job", zap.Error(err))
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errors.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// AppendLedgerEntry records the entry and applies it to the balances row in
+// the same transaction. A repeated credit for an already credited order is
+// ignored, so accruals are never counted twice.
+func (c *IDBCursor) AppendLedgerEntry(ctx context.Context, entry *models.LedgerEntry, logger *zap.Logger) (*models.Balance, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	balance := &models.Balance{User: entry.User}
+	err := c.withTx(ctx, logger, func(tx *sql.Tx) error {
+		return c.appendLedgerEntry(ctx, tx, entry, balance, logger)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+func (c *IDBCursor) appendLedgerEntry(ctx context.Context, tx *sql.Tx, entry *models.LedgerEntry, balance *models.Balance, logger *zap.Logger) error {
+	err := tx.QueryRowContext(ctx, AppendLedger,
+		entry.User, entry.Kind, entry.Amount, entry.Order, entry.Reason, entry.CreatedAt).Scan(&entry.ID)
+	if err == sql.ErrNoRows {
+		logger.Info("Ledger entry recorded already", zap.String("", entry.Order))
+		return scanBalance(tx.QueryRowContext(ctx, GetBalance, entry.User), balance)
+	}
+	if err != nil {
+		logger.Error("error during appending ledger entry", zap.Error(err))
+		return err
+	}
+	current, withdrawn := LedgerDeltas(entry)
+	if err := scanBalance(tx.QueryRowContext(ctx, ApplyLedger, entry.User, current, withdrawn), balance); err != nil {
+		logger.Error("error during applying ledger entry to balance", zap.Error(err))
+		return err
+	}
+	return c.appendOutbox(ctx, tx, entry.User, configuration.BALANCEUPDATED, balance, logger)
+}
+
+// Withdraw spends points in a single transaction. The balances row is locked
+// first, so concurrent withdrawals are serialized and cannot overdraw it.
+func (c *IDBCursor) Withdraw(ctx context.Context, withdrawal *models.Withdrawal, logger *zap.Logger) (*models.Balance, error) {
+	return c.withdraw(ctx, LockBalance, withdrawal, logger)
+}
+
+func (c *IDBCursor) withdraw(ctx context.Context, lockQuery string, withdrawal *models.Withdrawal, logger *zap.Logger) (*models.Balance, error) {
+	// a withdrawal of nothing or less would credit the balance
+	if withdrawal.Sum <= 0 {
+		return nil, errors.ErrValidation
+	}
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	balance := &models.Balance{User: withdrawal.User}
+	err := c.withTx(ctx, logger, func(tx *sql.Tx) error {
+		if err := scanBalance(tx.QueryRowContext(ctx, lockQuery, withdrawal.User), balance); err != nil {
+			logger.Error("error during locking balance", zap.Error(err))
+			return err
+		}
+		if balance.Current < withdrawal.Sum {
+			return errors.ErrInsufficientFunds
+		}
+		before := *balance
+		if err := c.saveWithdrawal(ctx, tx, withdrawal, logger); err != nil {
+			return err
+		}
+		err := c.appendLedgerEntry(ctx, tx, &models.LedgerEntry{
+			User:      withdrawal.User,
+			Kind:      configuration.DEBIT,
+			Amount:    -withdrawal.Sum,
+			Order:     withdrawal.Order,
+			Reason:    "withdrawal",
+			CreatedAt: withdrawal.ProcessedAt,
+		}, balance, logger)
+		if err != nil {
+			return err
+		}
+		return c.appendAudit(ctx, tx, configuration.AUDITWITHDRAWAL, withdrawal.User, &before, balance, logger)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+func (c *IDBCursor) GetLedger(ctx context.Context, username string, logger *zap.Logger) ([]*models.LedgerEntry, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	rows, err := c.DB.QueryContext(ctx, GetLedger, username)
+	if err != nil {
+		logger.Error("error during getting ledger from db", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []*models.LedgerEntry{}
+	for rows.Next() {
+		var e models.LedgerEntry
+		if err = rows.Scan(&e.ID, &e.User, &e.Kind, &e.Amount, &e.Order, &e.Reason, &e.CreatedAt); err != nil {
+			logger.Error("error scanning ledger entry from db", zap.Error(err))
+			return entries, err
+		}
+		entries = append(entries, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+	return entries, nil
+}
+
+// LedgerDeltas returns how the entry changes the current and withdrawn
+// amounts of a balance.
+func LedgerDeltas(entry *models.LedgerEntry) (models.Money, models.Money) {
+	if entry.Kind == configuration.DEBIT {
+		return entry.Amount, -entry.Amount
+	}
+	return entry.Amount, 0
+}
+
+func scanBalance(row *sql.Row, balance *models.Balance) error {
+	err := row.Scan(&balance.User, &balance.Current, &balance.Withdrawn)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	return err
+}
+
+func (c *IDBCursor) SaveWebhook(ctx context.Context, webhook *models.Webhook, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	err := c.DB.QueryRowContext(ctx, SaveWebhook, webhook.User, webhook.URL, webhook.Secret, webhook.CreatedAt).Scan(&webhook.ID)
+	if err != nil {
+		logger.Error("error during saving webhook", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (c *IDBCursor) GetWebhooks(ctx context.Context, username string, logger *zap.Logger) ([]*models.Webhook, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	rows, err := c.DB.QueryContext(ctx, GetWebhooks, username)
+	if err != nil {
+		logger.Error("error during getting webhooks from db", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	foundWebhooks := []*models.Webhook{}
+	for rows.Next() {
+		var w models.Webhook
+		if err := rows.Scan(&w.ID, &w.User, &w.URL, &w.Secret, &w.CreatedAt); err != nil {
+			logger.Error("error scanning webhook from db", zap.Error(err))
+			return foundWebhooks, err
+		}
+		foundWebhooks = append(foundWebhooks, &w)
+	}
+	if err = rows.Err(); err != nil {
+		return foundWebhooks, err
+	}
+	return foundWebhooks, nil
+}
+
+// DeleteWebhook removes the user's webhook together with its delivery log.
+func (c *IDBCursor) DeleteWebhook(ctx context.Context, username string, id int64, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	return c.withTx(ctx, logger, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, DeleteDeliveries, username, id); err != nil {
+			logger.Error("error during deleting webhook deliveries", zap.Error(err))
+			return err
+		}
+		res, err := tx.ExecContext(ctx, DeleteWebhook, username, id)
+		if err != nil {
+			logger.Error("error during deleting webhook", zap.Error(err))
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errors.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// EnqueueDeliveries schedules the event for every webhook of the user.
+func (c *IDBCursor) EnqueueDeliveries(ctx context.Context, username string, event *models.WebhookEvent, payload []byte, logger *zap.Logger) error {
+	return c.enqueueDeliveries(ctx, EnqueueDeliveries, username, event, payload, logger)
+}
+
+func (c *IDBCursor) enqueueDeliveries(ctx context.Context, query string, username string, event *models.WebhookEvent, payload []byte, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	_, err := c.DB.ExecContext(ctx, query, username, event.ID, event.Type, string(payload), event.CreatedAt)
+	if err != nil {
+		logger.Error("error during enqueueing webhook deliveries", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ClaimDeliveries locks up to limit pending deliveries that are due for the
+// lease duration, so that no other dispatcher sends them meanwhile.
+func (c *IDBCursor) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration, logger *zap.Logger) ([]*models.WebhookDelivery, error) {
+	return c.claimDeliveries(ctx, ClaimDeliveries, limit, lease, logger)
+}
+
+func (c *IDBCursor) claimDeliveries(ctx context.Context, query string, limit int, lease time.Duration, logger *zap.Logger) ([]*models.WebhookDelivery, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	now := time.Now()
+	rows, err := c.DB.QueryContext(ctx, query, now, now.Add(lease), limit)
+	if err != nil {
+		logger.Error("error during claiming webhook deliveries", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	claimed := []*models.WebhookDelivery{}
+	for rows.Next() {
+		d := models.WebhookDelivery{LockedUntil: now.Add(lease)}
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Secret, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.CreatedAt)
+		if err != nil {
+			logger.Error("error scanning webhook delivery from db", zap.Error(err))
+			return claimed, err
+		}
+		claimed = append(claimed, &d)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Error("error during claiming webhook deliveries", zap.Error(err))
+		return claimed, err
+	}
+	return claimed, nil
+}
+
+// SaveDeliveryAttempt stores the outcome of an attempt and unlocks the
+// delivery.
+func (c *IDBCursor) SaveDeliveryAttempt(ctx context.Context, d *models.WebhookDelivery, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	_, err := c.DB.ExecContext(ctx, SaveDeliveryAttempt,
+		d.ID, d.Status, d.Attempts, d.ResponseCode, d.LastError, d.NextAttemptAt, d.DeliveredAt)
+	if err != nil {
+		logger.Error("error during saving webhook delivery attempt", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetDeliveries returns the delivery log of the user's webhook, newest first.
+func (c *IDBCursor) GetDeliveries(ctx context.Context, username string, webhookID int64, logger *zap.Logger) ([]*models.WebhookDelivery, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	rows, err := c.DB.QueryContext(ctx, GetDeliveries, username, webhookID)
+	if err != nil {
+		logger.Error("error during getting webhook deliveries from db", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	foundDeliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		var d models.WebhookDelivery
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.EventID, &d.EventType, &d.Status, &d.Attempts,
+			&d.ResponseCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+		if err != nil {
+			logger.Error("error scanning webhook delivery from db", zap.Error(err))
+			return foundDeliveries, err
+		}
+		foundDeliveries = append(foundDeliveries, &d)
+	}
+	if err = rows.Err(); err != nil {
+		return foundDeliveries, err
+	}
+	return foundDeliveries, nil
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+	"go.uber.org/zap"
+)
+
+// GetLoginAttempts returns the recent failed logins of the account or
+// address. Without any the attempts are zero and the login is not locked.
+func (c *IDBCursor) GetLoginAttempts(ctx context.Context, scope string, key string, logger *zap.Logger) (*models.LoginAttempts, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	attempts := &models.LoginAttempts{Scope: scope, Key: key}
+	var lockedUntil sql.NullTime
+	err := c.DB.QueryRowContext(ctx, GetLoginAttempts, scope, key).
+		Scan(&attempts.Scope, &attempts.Key, &attempts.Failures, &attempts.LastFailureAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return attempts, nil
+	}
+	if err != nil {
+		logger.Error("error during getting login attempts from db", zap.Error(err))
+		return nil, err
+	}
+	attempts.LockedUntil = lockedUntil.Time
+	return attempts, nil
+}
+
+// RecordLoginAttempt counts a login attempted at attempts.LastFailureAt
+// before its password is checked and sets attempts.Failures to the number of
+// attempts in a row, attempts older than window being forgotten. Counting
+// first makes parallel attempts see distinct numbers. While the login is
+// locked the attempt is not counted, false is returned and attempts holds
+// the lock.
+func (c *IDBCursor) RecordLoginAttempt(ctx context.Context, attempts *models.LoginAttempts, window time.Duration, logger *zap.Logger) (bool, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	err := c.DB.QueryRowContext(ctx, RecordLoginAttempt, attempts.Scope, attempts.Key, attempts.LastFailureAt,
+		attempts.LastFailureAt.Add(-window)).Scan(&attempts.Failures)
+	if err == sql.ErrNoRows {
+		locked, err := c.GetLoginAttempts(ctx, attempts.Scope, attempts.Key, logger)
+		if err != nil {
+			return false, err
+		}
+		*attempts = *locked
+		return false, nil
+	}
+	if err != nil {
+		logger.Error("error during recording login attempt", zap.Error(err))
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseLoginAttempt takes back an attempt counted by RecordLoginAttempt
+// that did not fail, because it succeeded or was refused.
+func (c *IDBCursor) ReleaseLoginAttempt(ctx context.Context, scope string, key string, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	if _, err := c.DB.ExecContext(ctx, ReleaseLoginAttempt, scope, key); err != nil {
+		logger.Error("error during releasing login attempt", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// LockLogin refuses logins to the account or from the address until
+// attempts.LockedUntil. A longer lock already in place is kept.
+func (c *IDBCursor) LockLogin(ctx context.Context, attempts *models.LoginAttempts, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	if _, err := c.DB.ExecContext(ctx, LockLogin, attempts.Scope, attempts.Key, attempts.LockedUntil); err != nil {
+		logger.Error("error during locking login", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ResetLoginAttempts forgets the failed logins and lifts the lock, after a
+// successful login or when an admin unlocks the account.
+func (c *IDBCursor) ResetLoginAttempts(ctx context.Context, scope string, key string, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	if _, err := c.DB.ExecContext(ctx, ResetLoginAttempts, scope, key); err != nil {
+		logger.Error("error during resetting login attempts", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// DeleteStaleLoginAttempts removes the failures older than window that no
+// longer lock anything.
+func (c *IDBCursor) DeleteStaleLoginAttempts(ctx context.Context, now time.Time, window time.Duration, logger *zap.Logger) (int64, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	res, err := c.DB.ExecContext(ctx, DeleteStaleLoginAttempts, now.Add(-window), now)
+	if err != nil {
+		logger.Error("error during deleting stale login attempts", zap.Error(err))
+		return 0, err
+	}
+	return res.RowsAffected()
+}
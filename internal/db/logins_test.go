@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestLoginAttempts(t *testing.T) {
+	sqlite, _ := newSQLiteTestCursor(t)
+	backends := map[string]*Cursor{
+		"memory": {NewMemoryCursor()},
+		"sqlite": sqlite,
+	}
+	for name, cursor := range backends {
+		t.Run(name, func(t *testing.T) {
+			testLoginAttempts(t, cursor)
+		})
+	}
+}
+
+func testLoginAttempts(t *testing.T, cursor *Cursor) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	now := time.Now()
+	fail := func(key string, at time.Time) int {
+		attempts := &models.LoginAttempts{Scope: configuration.LOGINSCOPEACCOUNT, Key: key, LastFailureAt: at}
+		counted, err := cursor.RecordLoginAttempt(ctx, attempts, time.Hour, l)
+		require.NoError(t, err)
+		require.True(t, counted)
+		return attempts.Failures
+	}
+
+	attempts, err := cursor.GetLoginAttempts(ctx, configuration.LOGINSCOPEACCOUNT, "test", l)
+	require.NoError(t, err)
+	assert.Equal(t, 0, attempts.Failures)
+	assert.True(t, attempts.LockedUntil.IsZero())
+
+	// failures older than the window are forgotten
+	assert.Equal(t, 1, fail("test", now.Add(-3*time.Hour)))
+	assert.Equal(t, 1, fail("test", now.Add(-time.Minute)))
+	assert.Equal(t, 2, fail("test", now))
+	assert.Equal(t, 1, fail("other", now.Add(-2*time.Hour)))
+
+	require.NoError(t, cursor.LockLogin(ctx, &models.LoginAttempts{
+		Scope:       configuration.LOGINSCOPEACCOUNT,
+		Key:         "test",
+		LockedUntil: now.Add(time.Minute),
+	}, l))
+	// a shorter lock does not lift a longer one
+	require.NoError(t, cursor.LockLogin(ctx, &models.LoginAttempts{
+		Scope:       configuration.LOGINSCOPEACCOUNT,
+		Key:         "test",
+		LockedUntil: now.Add(time.Second),
+	}, l))
+	// attempts on a locked login are not counted
+	attempts = &models.LoginAttempts{Scope: configuration.LOGINSCOPEACCOUNT, Key: "test", LastFailureAt: now}
+	counted, err := cursor.RecordLoginAttempt(ctx, attempts, time.Hour, l)
+	require.NoError(t, err)
+	assert.False(t, counted)
+	assert.Equal(t, 2, attempts.Failures)
+	assert.True(t, now.Add(time.Minute).Equal(attempts.LockedUntil))
+	attempts, err = cursor.GetLoginAttempts(ctx, configuration.LOGINSCOPEACCOUNT, "test", l)
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts.Failures)
+	assert.True(t, now.Add(time.Minute).Equal(attempts.LockedUntil))
+
+	require.NoError(t, cursor.ReleaseLoginAttempt(ctx, configuration.LOGINSCOPEACCOUNT, "test", l))
+	attempts, err = cursor.GetLoginAttempts(ctx, configuration.LOGINSCOPEACCOUNT, "test", l)
+	require.NoError(t, err)
+	assert.Equal(t, 1, attempts.Failures)
+	// the same key of another scope is counted apart
+	attempts, err = cursor.GetLoginAttempts(ctx, configuration.LOGINSCOPEIP, "test", l)
+	require.NoError(t, err)
+	assert.Equal(t, 0, attempts.Failures)
+
+	deleted, err := cursor.DeleteStaleLoginAttempts(ctx, now, time.Hour, l)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	require.NoError(t, cursor.ResetLoginAttempts(ctx, configuration.LOGINSCOPEACCOUNT, "test", l))
+	attempts, err = cursor.GetLoginAttempts(ctx, configuration.LOGINSCOPEACCOUNT, "test", l)
+	require.NoError(t, err)
+	assert.Equal(t, 0, attempts.Failures)
+	assert.True(t, attempts.LockedUntil.IsZero())
+}
+
+func TestLoginAttemptsConcurrent(t *testing.T) {
+	sqlite, _ := newSQLiteTestCursor(t)
+	backends := map[string]*Cursor{
+		"memory": {NewMemoryCursor()},
+		"sqlite": sqlite,
+	}
+	for name, cursor := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			l := zap.NewNop()
+			now := time.Now()
+			const n = 20
+
+			// every parallel attempt gets a number of its own
+			var wg sync.WaitGroup
+			seen := make(chan int, n)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					attempts := &models.LoginAttempts{Scope: configuration.LOGINSCOPEACCOUNT, Key: "test", LastFailureAt: now}
+					counted, err := cursor.RecordLoginAttempt(ctx, attempts, time.Hour, l)
+					assert.NoError(t, err)
+					assert.True(t, counted)
+					seen <- attempts.Failures
+				}()
+			}
+			wg.Wait()
+			close(seen)
+			numbers := map[int]bool{}
+			for failures := range seen {
+				numbers[failures] = true
+			}
+			assert.Len(t, numbers, n)
+			for i := 1; i <= n; i++ {
+				assert.True(t, numbers[i], i)
+			}
+		})
+	}
+}
@@ -0,0 +1,1016 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/audit"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+type loginKey struct {
+	scope string
+	key   string
+}
+
+// MemoryCursor keeps all data in process memory. It mirrors the behaviour of
+// IDBCursor and is used for local runs without Postgres and in tests.
+type MemoryCursor struct {
+	mu          sync.RWMutex
+	users       map[string]*models.UserInfo
+	sessions    map[string]*models.Session
+	orders      []*models.Order
+	balances    map[string]*models.Balance
+	withdrawals []*models.Withdrawal
+	ledger      []*models.LedgerEntry
+	jobs        map[string]*models.AccrualJob
+	deadJobs    map[string]*models.AccrualJob
+	webhooks    []*models.Webhook
+	deliveries  []*models.WebhookDelivery
+	outbox      []*models.OutboxEvent
+	adjustments []*models.Adjustment
+	audit       []*models.AuditEntry
+	logins      map[loginKey]*models.LoginAttempts
+	lastID      int64
+	events      localEvents
+}
+
+func NewMemoryCursor() *MemoryCursor {
+	return &MemoryCursor{
+		users:    make(map[string]*models.UserInfo),
+		sessions: make(map[string]*models.Session),
+		balances: make(map[string]*models.Balance),
+		jobs:     make(map[string]*models.AccrualJob),
+		deadJobs: make(map[string]*models.AccrualJob),
+		logins:   make(map[loginKey]*models.LoginAttempts),
+	}
+}
+
+func (m *MemoryCursor) SaveUserInfo(ctx context.Context, info *models.UserInfo, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[info.Username]; ok {
+		return errors.ErrAlreadyExists
+	}
+	saved := *info
+	saved.Role = userRole(info)
+	m.users[info.Username] = &saved
+	return nil
+}
+
+func (m *MemoryCursor) GetUserInfo(ctx context.Context, info *models.UserInfo, logger *zap.Logger) (*models.UserInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	found, ok := m.users[info.Username]
+	if !ok {
+		return nil, errors.ErrNotFound
+	}
+	result := *found
+	return &result, nil
+}
+
+func (m *MemoryCursor) UpdateUserPassword(ctx context.Context, username string, hash string, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[username]; ok {
+		user.Password = hash
+	}
+	return nil
+}
+
+func (m *MemoryCursor) SetUserRole(ctx context.Context, username string, role string, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		return errors.ErrNotFound
+	}
+	before := user.Role
+	user.Role = role
+	m.appendAudit(ctx, configuration.AUDITROLECHANGED, username, &models.RolePost{Role: before}, &models.RolePost{Role: role})
+	return nil
+}
+
+// roleOf is the current role of the user, as sessions report it.
+func (m *MemoryCursor) roleOf(username string) string {
+	if user, ok := m.users[username]; ok {
+		return user.Role
+	}
+	return configuration.ROLEUSER
+}
+
+func (m *MemoryCursor) SaveSession(ctx context.Context, id string, session *models.Session, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[session.Token]; ok {
+		return errors.ErrAlreadyExists
+	}
+	prepareSession(session)
+	m.lastID++
+	session.ID = m.lastID
+	saved := *session
+	m.sessions[session.Token] = &saved
+	return nil
+}
+
+func (m *MemoryCursor) GetSession(ctx context.Context, token string, logger *zap.Logger) (*models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	found, ok := m.sessions[token]
+	if !ok {
+		return nil, errors.ErrNotFound
+	}
+	result := *found
+	result.Role = m.roleOf(found.Username)
+	return &result, nil
+}
+
+func (m *MemoryCursor) RefreshSession(ctx context.Context, refreshToken string, session *models.Session, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for token, found := range m.sessions {
+		if found.RefreshToken != refreshToken || !found.RefreshExpiresAt.After(time.Now()) {
+			continue
+		}
+		delete(m.sessions, token)
+		found.Token = session.Token
+		found.ExpiresAt = session.ExpiresAt
+		found.RefreshToken = session.RefreshToken
+		found.RefreshExpiresAt = session.RefreshExpiresAt
+		found.IP = session.IP
+		m.sessions[found.Token] = found
+		session.ID = found.ID
+		session.Username = found.Username
+		session.CreatedAt = found.CreatedAt
+		session.UserAgent = found.UserAgent
+		session.Role = m.roleOf(found.Username)
+		return nil
+	}
+	return errors.ErrNotFound
+}
+
+func (m *MemoryCursor) GetSessions(ctx context.Context, username string, logger *zap.Logger) ([]*models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := []*models.Session{}
+	for _, session := range m.sessions {
+		if session.Username == username && session.RefreshExpiresAt.After(time.Now()) {
+			found := *session
+			found.Role = m.roleOf(username)
+			result = append(result, &found)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.After(result[j].CreatedAt)
+		}
+		return result[i].ID > result[j].ID
+	})
+	return result, nil
+}
+
+func (m *MemoryCursor) GetSessionByID(ctx context.Context, username string, id int64, logger *zap.Logger) (*models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, session := range m.sessions {
+		if session.Username == username && session.ID == id && session.RefreshExpiresAt.After(time.Now()) {
+			found := *session
+			found.Role = m.roleOf(username)
+			return &found, nil
+		}
+	}
+	return nil, errors.ErrNotFound
+}
+
+func (m *MemoryCursor) DeleteSession(ctx context.Context, username string, id int64, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for token, session := range m.sessions {
+		if session.Username == username && session.ID == id {
+			delete(m.sessions, token)
+			return nil
+		}
+	}
+	return errors.ErrNotFound
+}
+
+func (m *MemoryCursor) DeleteExpiredSessions(ctx context.Context, now time.Time, logger *zap.Logger) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for token, session := range m.sessions {
+		if !session.RefreshExpiresAt.After(now) {
+			delete(m.sessions, token)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *MemoryCursor) GetUsernameByToken(ctx context.Context, token string, logger *zap.Logger) (string, error) {
+	session, err := m.GetSession(ctx, token, logger)
+	if err != nil {
+		return "", err
+	}
+	return session.Username, nil
+}
+
+func (m *MemoryCursor) GetOrder(ctx context.Context, username string, number string, logger *zap.Logger) (*models.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, order := range m.orders {
+		if order.Username == username && order.Number == number {
+			result := *order
+			return &result, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryCursor) SaveOrder(ctx context.Context, order *models.Order, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.orders {
+		if existing.Number == order.Number {
+			return errors.ErrAlreadyExists
+		}
+	}
+	saved := *order
+	m.orders = append(m.orders, &saved)
+	return nil
+}
+
+func (m *MemoryCursor) GetOrders(ctx context.Context, username string, query *models.OrderQuery, logger *zap.Logger) ([]*models.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if query == nil {
+		query = &models.OrderQuery{}
+	}
+	foundOrders := []*models.Order{}
+	for _, order := range m.orders {
+		if order.Username == username && orderMatches(order, query) {
+			result := *order
+			foundOrders = append(foundOrders, &result)
+		}
+	}
+	sort.SliceStable(foundOrders, func(i, j int) bool {
+		return orderPrecedes(foundOrders[i], foundOrders[j].UploadedAt, foundOrders[j].Number, query.Ascending)
+	})
+	if query.Limit > 0 && len(foundOrders) > query.Limit {
+		foundOrders = foundOrders[:query.Limit]
+	}
+	return foundOrders, nil
+}
+
+func orderMatches(order *models.Order, query *models.OrderQuery) bool {
+	if len(query.Statuses) > 0 {
+		found := false
+		for _, status := range query.Statuses {
+			found = found || order.Status == status
+		}
+		if !found {
+			return false
+		}
+	}
+	if !query.From.IsZero() && order.UploadedAt.Before(query.From) {
+		return false
+	}
+	if !query.To.IsZero() && !order.UploadedAt.Before(query.To) {
+		return false
+	}
+	if query.After != nil && !orderPrecedes(&models.Order{UploadedAt: query.After.Time, Number: query.After.Key}, order.UploadedAt, order.Number, query.Ascending) {
+		return false
+	}
+	return true
+}
+
+// orderPrecedes reports whether the order comes before the position given by
+// uploadedAt and number, using the ordering of ordersPageQuery.
+func orderPrecedes(order *models.Order, uploadedAt time.Time, number string, ascending bool) bool {
+	if !order.UploadedAt.Equal(uploadedAt) {
+		return order.UploadedAt.Before(uploadedAt) == ascending
+	}
+	return order.Number < number
+}
+
+func (m *MemoryCursor) GetAllOrders(ctx context.Context) ([]*models.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	foundOrders := make([]*models.Order, 0, len(m.orders))
+	for _, order := range m.orders {
+		result := *order
+		foundOrders = append(foundOrders, &result)
+	}
+	return foundOrders, nil
+}
+
+func (m *MemoryCursor) UpdateOrder(ctx context.Context, username string, from *models.AccrualResponse, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updateOrder(ctx, username, from)
+	return nil
+}
+
+func (m *MemoryCursor) ApplyAccrual(ctx context.Context, username string, from *models.AccrualResponse, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.updateOrder(ctx, username, from) {
+		return nil
+	}
+	if entry := accrualEntry(username, from); entry != nil {
+		m.appendLedgerEntry(entry)
+	}
+	return nil
+}
+
+// updateOrder reports whether the order was found.
+func (m *MemoryCursor) updateOrder(ctx context.Context, username string, from *models.AccrualResponse) bool {
+	status := from.Status
+	if status == configuration.REGISTERED {
+		status = configuration.PROCESSING
+	}
+	found := false
+	for _, order := range m.orders {
+		if order.Username == username && order.Number == from.Order {
+			before := *order
+			order.Status = status
+			order.Accrual = from.Accrual
+			for _, eventType := range orderEvents(&before, order) {
+				m.appendOutbox(username, eventType, order)
+			}
+			if before.Status != order.Status {
+				m.appendAudit(ctx, configuration.AUDITORDERSTATUS, username, &before, order)
+			}
+			found = true
+		}
+	}
+	return found
+}
+
+func (m *MemoryCursor) GetUserBalance(ctx context.Context, username string, logger *zap.Logger) (*models.Balance, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	found, ok := m.balances[username]
+	if !ok {
+		return &models.Balance{}, nil
+	}
+	result := *found
+	return &result, nil
+}
+
+func (m *MemoryCursor) SaveUserBalance(ctx context.Context, username string, newBalance *models.Balance, logger *zap.Logger) (*models.Balance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.balances[username]; ok {
+		return nil, errors.ErrAlreadyExists
+	}
+	saved := *newBalance
+	saved.User = username
+	m.balances[username] = &saved
+	newBalance.User = username
+	return newBalance, nil
+}
+
+func (m *MemoryCursor) GetWithdrawals(ctx context.Context, username string, query *models.WithdrawalQuery, logger *zap.Logger) ([]*models.Withdrawal, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if query == nil {
+		query = &models.WithdrawalQuery{}
+	}
+	foundWithdrawals := []*models.Withdrawal{}
+	for _, w := range m.withdrawals {
+		if w.User == username && withdrawalMatches(w, query) {
+			result := *w
+			foundWithdrawals = append(foundWithdrawals, &result)
+		}
+	}
+	sort.SliceStable(foundWithdrawals, func(i, j int) bool {
+		return withdrawalPrecedes(foundWithdrawals[i], foundWithdrawals[j].ProcessedAt, foundWithdrawals[j].Order)
+	})
+	if query.Limit > 0 && len(foundWithdrawals) > query.Limit {
+		foundWithdrawals = foundWithdrawals[:query.Limit]
+	}
+	return foundWithdrawals, nil
+}
+
+func withdrawalMatches(w *models.Withdrawal, query *models.WithdrawalQuery) bool {
+	if !query.From.IsZero() && w.ProcessedAt.Before(query.From) {
+		return false
+	}
+	if !query.To.IsZero() && !w.ProcessedAt.Before(query.To) {
+		return false
+	}
+	if query.After != nil && !withdrawalPrecedes(&models.Withdrawal{ProcessedAt: query.After.Time, Order: query.After.Key}, w.ProcessedAt, w.Order) {
+		return false
+	}
+	return true
+}
+
+// withdrawalPrecedes reports whether the withdrawal comes before the position
+// given by processedAt and order, using the ordering of withdrawalsPageQuery.
+func withdrawalPrecedes(w *models.Withdrawal, processedAt time.Time, order string) bool {
+	if !w.ProcessedAt.Equal(processedAt) {
+		return w.ProcessedAt.After(processedAt)
+	}
+	return w.Order < order
+}
+
+func (m *MemoryCursor) SaveWithdrawal(ctx context.Context, withdrawal *models.Withdrawal, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveWithdrawal(withdrawal)
+}
+
+func (m *MemoryCursor) saveWithdrawal(withdrawal *models.Withdrawal) error {
+	for _, w := range m.withdrawals {
+		if w.Order == withdrawal.Order {
+			return errors.ErrAlreadyExists
+		}
+	}
+	saved := *withdrawal
+	m.withdrawals = append(m.withdrawals, &saved)
+	m.appendOutbox(withdrawal.User, configuration.WITHDRAWALCREATED, withdrawal)
+	return nil
+}
+
+func (m *MemoryCursor) AppendLedgerEntry(ctx context.Context, entry *models.LedgerEntry, logger *zap.Logger) (*models.Balance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.appendLedgerEntry(entry), nil
+}
+
+func (m *MemoryCursor) appendLedgerEntry(entry *models.LedgerEntry) *models.Balance {
+	balance, ok := m.balances[entry.User]
+	if !ok {
+		balance = &models.Balance{User: entry.User}
+		m.balances[entry.User] = balance
+	}
+	if entry.Kind == configuration.CREDIT {
+		for _, e := range m.ledger {
+			if e.Kind == configuration.CREDIT && e.Order == entry.Order {
+				result := *balance
+				return &result
+			}
+		}
+	}
+	entry.ID = int64(len(m.ledger) + 1)
+	saved := *entry
+	m.ledger = append(m.ledger, &saved)
+	current, withdrawn := LedgerDeltas(entry)
+	balance.Current += current
+	balance.Withdrawn += withdrawn
+	result := *balance
+	m.appendOutbox(entry.User, configuration.BALANCEUPDATED, &result)
+	return &result
+}
+
+func (m *MemoryCursor) GetLedger(ctx context.Context, username string, logger *zap.Logger) ([]*models.LedgerEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := []*models.LedgerEntry{}
+	for _, e := range m.ledger {
+		if e.User == username {
+			result := *e
+			entries = append(entries, &result)
+		}
+	}
+	return entries, nil
+}
+
+func (m *MemoryCursor) Withdraw(ctx context.Context, withdrawal *models.Withdrawal, logger *zap.Logger) (*models.Balance, error) {
+	if withdrawal.Sum <= 0 {
+		return nil, errors.ErrValidation
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	balance, ok := m.balances[withdrawal.User]
+	if !ok || balance.Current < withdrawal.Sum {
+		return nil, errors.ErrInsufficientFunds
+	}
+	if err := m.saveWithdrawal(withdrawal); err != nil {
+		return nil, err
+	}
+	before := *balance
+	after := m.appendLedgerEntry(&models.LedgerEntry{
+		User:      withdrawal.User,
+		Kind:      configuration.DEBIT,
+		Amount:    -withdrawal.Sum,
+		Order:     withdrawal.Order,
+		Reason:    "withdrawal",
+		CreatedAt: withdrawal.ProcessedAt,
+	})
+	m.appendAudit(ctx, configuration.AUDITWITHDRAWAL, withdrawal.User, &before, after)
+	return after, nil
+}
+
+func (m *MemoryCursor) EnqueueJob(ctx context.Context, job *models.AccrualJob, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[job.Number]; ok {
+		return nil
+	}
+	if _, ok := m.deadJobs[job.Number]; ok {
+		return nil
+	}
+	m.jobs[job.Number] = &models.AccrualJob{
+		Number:        job.Number,
+		Username:      job.Username,
+		CreatedAt:     job.CreatedAt,
+		NextAttemptAt: job.CreatedAt,
+	}
+	return nil
+}
+
+func (m *MemoryCursor) ClaimJobs(ctx context.Context, limit int, lease time.Duration, logger *zap.Logger) ([]*models.AccrualJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	due := []*models.AccrualJob{}
+	for _, job := range m.jobs {
+		if job.NextAttemptAt.After(now) || job.LockedUntil.After(now) {
+			continue
+		}
+		due = append(due, job)
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	claimedJobs := make([]*models.AccrualJob, 0, len(due))
+	for _, job := range due {
+		job.LockedUntil = now.Add(lease)
+		result := *job
+		claimedJobs = append(claimedJobs, &result)
+	}
+	return claimedJobs, nil
+}
+
+func (m *MemoryCursor) CompleteJob(ctx context.Context, number string, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, number)
+	return nil
+}
+
+func (m *MemoryCursor) RescheduleJob(ctx context.Context, number string, at time.Time, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[number]; ok {
+		job.LockedUntil = time.Time{}
+		job.NextAttemptAt = at
+	}
+	return nil
+}
+
+func (m *MemoryCursor) FailJob(ctx context.Context, number string, lastError string, at time.Time, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[number]; ok {
+		job.LockedUntil = time.Time{}
+		job.NextAttemptAt = at
+		job.Attempts++
+		job.LastError = lastError
+	}
+	return nil
+}
+
+func (m *MemoryCursor) BuryJob(ctx context.Context, number string, lastError string, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[number]
+	if !ok {
+		return nil
+	}
+	delete(m.jobs, number)
+	if _, ok := m.deadJobs[number]; ok {
+		return nil
+	}
+	m.deadJobs[number] = &models.AccrualJob{
+		Number:    job.Number,
+		Username:  job.Username,
+		CreatedAt: job.CreatedAt,
+		Attempts:  job.Attempts + 1,
+		LastError: lastError,
+		FailedAt:  time.Now(),
+	}
+	return nil
+}
+
+func (m *MemoryCursor) GetDeadJobs(ctx context.Context, logger *zap.Logger) ([]*models.AccrualJob, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	deadJobs := make([]*models.AccrualJob, 0, len(m.deadJobs))
+	for _, job := range m.deadJobs {
+		result := *job
+		deadJobs = append(deadJobs, &result)
+	}
+	sort.Slice(deadJobs, func(i, j int) bool {
+		return deadJobs[i].FailedAt.Before(deadJobs[j].FailedAt)
+	})
+	return deadJobs, nil
+}
+
+func (m *MemoryCursor) RedriveJob(ctx context.Context, number string, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.deadJobs[number]
+	if !ok {
+		return errors.ErrNotFound
+	}
+	delete(m.deadJobs, number)
+	if _, ok := m.jobs[number]; ok {
+		return nil
+	}
+	m.jobs[number] = &models.AccrualJob{
+		Number:        job.Number,
+		Username:      job.Username,
+		CreatedAt:     job.CreatedAt,
+		NextAttemptAt: time.Now(),
+	}
+	return nil
+}
+
+func (m *MemoryCursor) SaveWebhook(ctx context.Context, webhook *models.Webhook, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastID++
+	webhook.ID = m.lastID
+	saved := *webhook
+	m.webhooks = append(m.webhooks, &saved)
+	return nil
+}
+
+func (m *MemoryCursor) GetWebhooks(ctx context.Context, username string, logger *zap.Logger) ([]*models.Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	foundWebhooks := []*models.Webhook{}
+	for _, w := range m.webhooks {
+		if w.User == username {
+			result := *w
+			foundWebhooks = append(foundWebhooks, &result)
+		}
+	}
+	return foundWebhooks, nil
+}
+
+func (m *MemoryCursor) DeleteWebhook(ctx context.Context, username string, id int64, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, w := range m.webhooks {
+		if w.User != username || w.ID != id {
+			continue
+		}
+		m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+		kept := m.deliveries[:0]
+		for _, d := range m.deliveries {
+			if d.WebhookID != id {
+				kept = append(kept, d)
+			}
+		}
+		m.deliveries = kept
+		return nil
+	}
+	return errors.ErrNotFound
+}
+
+func (m *MemoryCursor) EnqueueDeliveries(ctx context.Context, username string, event *models.WebhookEvent, payload []byte, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, w := range m.webhooks {
+		if w.User != username {
+			continue
+		}
+		m.lastID++
+		m.deliveries = append(m.deliveries, &models.WebhookDelivery{
+			ID:            m.lastID,
+			WebhookID:     w.ID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			Payload:       string(payload),
+			Status:        configuration.DELIVERYPENDING,
+			CreatedAt:     event.CreatedAt,
+			NextAttemptAt: event.CreatedAt,
+		})
+	}
+	return nil
+}
+
+func (m *MemoryCursor) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration, logger *zap.Logger) ([]*models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	due := []*models.WebhookDelivery{}
+	for _, d := range m.deliveries {
+		if d.Status != configuration.DELIVERYPENDING || d.NextAttemptAt.After(now) || d.LockedUntil.After(now) {
+			continue
+		}
+		due = append(due, d)
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	claimed := make([]*models.WebhookDelivery, 0, len(due))
+	for _, d := range due {
+		d.LockedUntil = now.Add(lease)
+		result := *d
+		if webhook := m.webhook(d.WebhookID); webhook != nil {
+			result.URL, result.Secret = webhook.URL, webhook.Secret
+		}
+		claimed = append(claimed, &result)
+	}
+	return claimed, nil
+}
+
+func (m *MemoryCursor) webhook(id int64) *models.Webhook {
+	for _, w := range m.webhooks {
+		if w.ID == id {
+			return w
+		}
+	}
+	return nil
+}
+
+func (m *MemoryCursor) SaveDeliveryAttempt(ctx context.Context, delivery *models.WebhookDelivery, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range m.deliveries {
+		if d.ID != delivery.ID {
+			continue
+		}
+		d.Status = delivery.Status
+		d.Attempts = delivery.Attempts
+		d.ResponseCode = delivery.ResponseCode
+		d.LastError = delivery.LastError
+		d.NextAttemptAt = delivery.NextAttemptAt
+		d.DeliveredAt = delivery.DeliveredAt
+		d.LockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (m *MemoryCursor) GetDeliveries(ctx context.Context, username string, webhookID int64, logger *zap.Logger) ([]*models.WebhookDelivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	webhook := m.webhook(webhookID)
+	foundDeliveries := []*models.WebhookDelivery{}
+	if webhook == nil || webhook.User != username {
+		return foundDeliveries, nil
+	}
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			result := *m.deliveries[i]
+			result.URL, result.Secret, result.Payload = webhook.URL, "", ""
+			foundDeliveries = append(foundDeliveries, &result)
+		}
+	}
+	return foundDeliveries, nil
+}
+
+// appendOutbox records the event while the change is still under the lock.
+// The models passed here always marshal, so the error is not checked.
+func (m *MemoryCursor) appendOutbox(username string, eventType string, data interface{}) {
+	event, _ := newOutboxEvent(username, eventType, data)
+	m.lastID++
+	event.ID = m.lastID
+	m.outbox = append(m.outbox, event)
+}
+
+func (m *MemoryCursor) ClaimOutbox(ctx context.Context, limit int, lease time.Duration, logger *zap.Logger) ([]*models.OutboxEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	claimed := []*models.OutboxEvent{}
+	for _, e := range m.outbox {
+		if len(claimed) == limit {
+			break
+		}
+		if e.LockedUntil.After(now) {
+			continue
+		}
+		e.LockedUntil = now.Add(lease)
+		result := *e
+		claimed = append(claimed, &result)
+	}
+	return claimed, nil
+}
+
+func (m *MemoryCursor) DeleteOutboxEvent(ctx context.Context, id int64, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range m.outbox {
+		if e.ID == id {
+			m.outbox = append(m.outbox[:i], m.outbox[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *MemoryCursor) BroadcastEvent(ctx context.Context, event *models.OutboxEvent, logger *zap.Logger) error {
+	return m.events.BroadcastEvent(ctx, event, logger)
+}
+
+func (m *MemoryCursor) ListenEvents(ctx context.Context, handle func(context.Context, *models.OutboxEvent) error, logger *zap.Logger) error {
+	return m.events.ListenEvents(ctx, handle, logger)
+}
+
+func (m *MemoryCursor) SaveAdjustment(ctx context.Context, adjustment *models.Adjustment, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	applied := adjustment.Status == configuration.ADJUSTMENTAPPLIED
+	if applied && m.overdraws(adjustment) {
+		return errors.ErrInsufficientFunds
+	}
+	adjustment.ID = int64(len(m.adjustments) + 1)
+	saved := *adjustment
+	m.adjustments = append(m.adjustments, &saved)
+	m.appendAudit(ctx, configuration.AUDITADJUSTMENTREQUESTED, adjustment.User, nil, adjustment)
+	if applied {
+		m.applyAdjustment(ctx, adjustment, adjustment.CreatedAt)
+	}
+	return nil
+}
+
+// overdraws tells whether applying the adjustment would leave the balance
+// negative.
+func (m *MemoryCursor) overdraws(adjustment *models.Adjustment) bool {
+	var current models.Money
+	if balance, ok := m.balances[adjustment.User]; ok {
+		current = balance.Current
+	}
+	return current+adjustment.Amount < 0
+}
+
+func (m *MemoryCursor) applyAdjustment(ctx context.Context, adjustment *models.Adjustment, appliedAt time.Time) {
+	before := models.Balance{User: adjustment.User}
+	if balance, ok := m.balances[adjustment.User]; ok {
+		before = *balance
+	}
+	after := m.appendLedgerEntry(adjustmentEntry(adjustment, appliedAt))
+	m.appendAudit(ctx, configuration.AUDITBALANCEADJUSTED, adjustment.User, &before, after)
+}
+
+func (m *MemoryCursor) GetAdjustments(ctx context.Context, status string, logger *zap.Logger) ([]*models.Adjustment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	adjustments := []*models.Adjustment{}
+	for i := len(m.adjustments) - 1; i >= 0; i-- {
+		if status == "" || m.adjustments[i].Status == status {
+			result := *m.adjustments[i]
+			adjustments = append(adjustments, &result)
+		}
+	}
+	return adjustments, nil
+}
+
+func (m *MemoryCursor) ReviewAdjustment(ctx context.Context, review *models.Adjustment, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if review.ID < 1 || review.ID > int64(len(m.adjustments)) {
+		return errors.ErrNotFound
+	}
+	found := m.adjustments[review.ID-1]
+	if found.Status != configuration.ADJUSTMENTPENDING {
+		return errors.ErrNotPending
+	}
+	if found.RequestedBy == review.ReviewedBy {
+		return errors.ErrSelfApproval
+	}
+	applied := review.Status == configuration.ADJUSTMENTAPPLIED
+	if applied && m.overdraws(found) {
+		return errors.ErrInsufficientFunds
+	}
+	pending := *found
+	reviewedAt := time.Now()
+	found.Status, found.ReviewedBy, found.ReviewedAt = review.Status, review.ReviewedBy, &reviewedAt
+	if applied {
+		m.applyAdjustment(ctx, found, reviewedAt)
+	}
+	*review = *found
+	m.appendAudit(ctx, configuration.AUDITADJUSTMENTREVIEWED, found.User, &pending, found)
+	return nil
+}
+
+func (m *MemoryCursor) AppendAudit(ctx context.Context, entry *models.AuditEntry, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastID++
+	entry.ID = m.lastID
+	saved := *entry
+	m.audit = append(m.audit, &saved)
+	return nil
+}
+
+// appendAudit records the action while the change is still under the lock.
+// As with appendOutbox the values always marshal.
+func (m *MemoryCursor) appendAudit(ctx context.Context, action string, subject string, before interface{}, after interface{}) {
+	entry, _ := audit.NewEntry(ctx, action, subject, before, after)
+	m.lastID++
+	entry.ID = m.lastID
+	m.audit = append(m.audit, entry)
+}
+
+func (m *MemoryCursor) GetAuditLog(ctx context.Context, query *models.AuditQuery, logger *zap.Logger) ([]*models.AuditEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var after int64
+	if query.After != nil {
+		after, _ = strconv.ParseInt(query.After.Key, 10, 64)
+	}
+	entries := []*models.AuditEntry{}
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		e := m.audit[i]
+		if query.Limit > 0 && len(entries) == query.Limit {
+			break
+		}
+		if (query.Actor != "" && e.Actor != query.Actor) || (query.Subject != "" && e.Subject != query.Subject) ||
+			(query.Action != "" && e.Action != query.Action) || (after > 0 && e.ID >= after) ||
+			(!query.From.IsZero() && e.CreatedAt.Before(query.From)) || (!query.To.IsZero() && !e.CreatedAt.Before(query.To)) {
+			continue
+		}
+		result := *e
+		entries = append(entries, &result)
+	}
+	return entries, nil
+}
+
+func (m *MemoryCursor) GetLoginAttempts(ctx context.Context, scope string, key string, logger *zap.Logger) (*models.LoginAttempts, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if found, ok := m.logins[loginKey{scope, key}]; ok {
+		result := *found
+		return &result, nil
+	}
+	return &models.LoginAttempts{Scope: scope, Key: key}, nil
+}
+
+func (m *MemoryCursor) RecordLoginAttempt(ctx context.Context, attempts *models.LoginAttempts, window time.Duration, logger *zap.Logger) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found, ok := m.logins[loginKey{attempts.Scope, attempts.Key}]
+	if !ok {
+		found = &models.LoginAttempts{Scope: attempts.Scope, Key: attempts.Key}
+		m.logins[loginKey{attempts.Scope, attempts.Key}] = found
+	}
+	if found.LockedUntil.After(attempts.LastFailureAt) {
+		*attempts = *found
+		return false, nil
+	}
+	if found.LastFailureAt.Before(attempts.LastFailureAt.Add(-window)) {
+		found.Failures = 0
+	}
+	found.Failures++
+	found.LastFailureAt = attempts.LastFailureAt
+	attempts.Failures = found.Failures
+	return true, nil
+}
+
+func (m *MemoryCursor) ReleaseLoginAttempt(ctx context.Context, scope string, key string, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if found, ok := m.logins[loginKey{scope, key}]; ok && found.Failures > 0 {
+		found.Failures--
+	}
+	return nil
+}
+
+func (m *MemoryCursor) LockLogin(ctx context.Context, attempts *models.LoginAttempts, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if found, ok := m.logins[loginKey{attempts.Scope, attempts.Key}]; ok && found.LockedUntil.Before(attempts.LockedUntil) {
+		found.LockedUntil = attempts.LockedUntil
+	}
+	return nil
+}
+
+func (m *MemoryCursor) ResetLoginAttempts(ctx context.Context, scope string, key string, logger *zap.Logger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.logins, loginKey{scope, key})
+	return nil
+}
+
+func (m *MemoryCursor) DeleteStaleLoginAttempts(ctx context.Context, now time.Time, window time.Duration, logger *zap.Logger) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for key, attempts := range m.logins {
+		if attempts.LastFailureAt.Before(now.Add(-window)) && attempts.LockedUntil.Before(now) {
+			delete(m.logins, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
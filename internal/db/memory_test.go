@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestGetCursorMemory(t *testing.T) {
+	l := zap.NewNop()
+	for _, url := range []string{"", configuration.MEMORYSCHEME} {
+		cursor, err := GetCursor(url, 0, l)
+		assert.NoError(t, err)
+		_, ok := cursor.IDBInterface.(*MemoryCursor)
+		assert.True(t, ok)
+	}
+}
+
+func TestMemoryUsersAndSessions(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	m := NewMemoryCursor()
+
+	assert.NoError(t, m.SaveUserInfo(ctx, &models.UserInfo{Username: "test", Password: "test"}, l))
+	assert.Equal(t, errors.ErrAlreadyExists, m.SaveUserInfo(ctx, &models.UserInfo{Username: "test", Password: "other"}, l))
+
+	_, err := m.GetUserInfo(ctx, &models.UserInfo{Username: "missing"}, l)
+	assert.Equal(t, errors.ErrNotFound, err)
+
+	assert.NoError(t, m.SaveSession(ctx, "token", &models.Session{
+		Username:  "test",
+		Token:     "token",
+		ExpiresAt: time.Now().Add(time.Minute),
+	}, l))
+	username, err := m.GetUsernameByToken(ctx, "token", l)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", username)
+	_, err = m.GetUsernameByToken(ctx, "missing", l)
+	assert.Equal(t, errors.ErrNotFound, err)
+}
+
+func TestMemoryLedger(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	m := NewMemoryCursor()
+
+	credit := &models.LedgerEntry{
+		User:   "test",
+		Kind:   configuration.CREDIT,
+		Amount: models.NewMoney(100),
+		Order:  "9278923470",
+	}
+	_, err := m.AppendLedgerEntry(ctx, credit, l)
+	assert.NoError(t, err)
+	balance, err := m.AppendLedgerEntry(ctx, credit, l)
+	assert.NoError(t, err)
+	assert.Equal(t, models.NewMoney(100), balance.Current)
+
+	_, err = m.Withdraw(ctx, &models.Withdrawal{User: "test", Order: "2377225624", Sum: models.NewMoney(150)}, l)
+	assert.Equal(t, errors.ErrInsufficientFunds, err)
+	for _, sum := range []models.Money{0, models.NewMoney(-50)} {
+		_, err = m.Withdraw(ctx, &models.Withdrawal{User: "test", Order: "2377225624", Sum: sum}, l)
+		assert.Equal(t, errors.ErrValidation, err)
+	}
+
+	balance, err = m.Withdraw(ctx, &models.Withdrawal{User: "test", Order: "2377225624", Sum: models.NewMoney(40.5)}, l)
+	assert.NoError(t, err)
+	assert.Equal(t, models.NewMoney(59.5), balance.Current)
+	assert.Equal(t, models.NewMoney(40.5), balance.Withdrawn)
+
+	entries, err := m.GetLedger(ctx, "test", l)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
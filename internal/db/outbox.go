@@ -0,0 +1,222 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+	"go.uber.org/zap"
+)
+
+// orderEvents lists the outbox events of an order changing from before to
+// after. Any change of status or accrual is an update, reaching a final
+// status is announced on its own as well.
+func orderEvents(before *models.Order, after *models.Order) []string {
+	if before.Status == after.Status && before.Accrual == after.Accrual {
+		return nil
+	}
+	events := []string{configuration.ORDERUPDATED}
+	if before.Status != after.Status {
+		switch after.Status {
+		case configuration.PROCESSED:
+			events = append(events, configuration.ORDERPROCESSED)
+		case configuration.INVALID:
+			events = append(events, configuration.ORDERINVALID)
+		}
+	}
+	return events
+}
+
+func newOutboxEvent(username string, eventType string, data interface{}) (*models.OutboxEvent, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &models.OutboxEvent{
+		User:      username,
+		Type:      eventType,
+		Payload:   string(payload),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// appendOutbox records the event in the transaction of the change.
+func (c *IDBCursor) appendOutbox(ctx context.Context, tx *sql.Tx, username string, eventType string, data interface{}, logger *zap.Logger) error {
+	event, err := newOutboxEvent(username, eventType, data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, AppendOutbox, event.User, event.Type, event.Payload, event.CreatedAt)
+	if err != nil {
+		logger.Error("error during appending outbox event", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ClaimOutbox locks up to limit of the oldest events for the lease duration,
+// so that no other relay dispatches them meanwhile. Events come in the order
+// they were recorded.
+func (c *IDBCursor) ClaimOutbox(ctx context.Context, limit int, lease time.Duration, logger *zap.Logger) ([]*models.OutboxEvent, error) {
+	return c.claimOutbox(ctx, ClaimOutbox, limit, lease, logger)
+}
+
+func (c *IDBCursor) claimOutbox(ctx context.Context, query string, limit int, lease time.Duration, logger *zap.Logger) ([]*models.OutboxEvent, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	now := time.Now()
+	rows, err := c.DB.QueryContext(ctx, query, now, now.Add(lease), limit)
+	if err != nil {
+		logger.Error("error during claiming outbox events", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	claimed := []*models.OutboxEvent{}
+	for rows.Next() {
+		e := models.OutboxEvent{LockedUntil: now.Add(lease)}
+		if err := rows.Scan(&e.ID, &e.User, &e.Type, &e.Payload, &e.CreatedAt); err != nil {
+			logger.Error("error scanning outbox event from db", zap.Error(err))
+			return claimed, err
+		}
+		claimed = append(claimed, &e)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Error("error during claiming outbox events", zap.Error(err))
+		return claimed, err
+	}
+	// RETURNING does not keep the order of the subquery
+	sort.Slice(claimed, func(i, j int) bool {
+		return claimed[i].ID < claimed[j].ID
+	})
+	return claimed, nil
+}
+
+func (c *IDBCursor) DeleteOutboxEvent(ctx context.Context, id int64, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	_, err := c.DB.ExecContext(ctx, DeleteOutbox, id)
+	if err != nil {
+		logger.Error("error during deleting outbox event", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// BroadcastEvent notifies the listeners of every instance sharing the
+// database of the event. Notifications are not stored, a listener that is
+// not connected misses them. Events too large for a notification are only
+// logged, the live subscribers miss those as well.
+func (c *IDBCursor) BroadcastEvent(ctx context.Context, event *models.OutboxEvent, logger *zap.Logger) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if len(payload) >= configuration.EVENTSPAYLOADLIMIT {
+		logger.Warn("Outbox event is too large to broadcast", zap.Int64("event", event.ID), zap.Int("size", len(payload)))
+		return nil
+	}
+	if _, err := c.DB.ExecContext(ctx, NotifyEvent, configuration.EVENTSCHANNEL, string(payload)); err != nil {
+		logger.Error("error during broadcasting outbox event", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ListenEvents calls handle with the events broadcast by any instance until
+// ctx ends. A lost connection is reopened after EVENTSRECONNECT seconds, the
+// events broadcast in the meantime are missed.
+func (c *IDBCursor) ListenEvents(ctx context.Context, handle func(context.Context, *models.OutboxEvent) error, logger *zap.Logger) error {
+	for {
+		err := c.listenEvents(ctx, handle, logger)
+		if ctx.Err() != nil {
+			return nil
+		}
+		logger.Error("Lost the connection listening for events, reconnecting", zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(configuration.EVENTSRECONNECT * time.Second):
+		}
+	}
+}
+
+func (c *IDBCursor) listenEvents(ctx context.Context, handle func(context.Context, *models.OutboxEvent) error, logger *zap.Logger) error {
+	conn, err := c.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		pgxConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.ErrDatabaseSQLQuery
+		}
+		// the connection keeps listening after this, so it never goes back
+		// to the pool
+		if _, err := pgxConn.Conn().Exec(ctx, "LISTEN "+configuration.EVENTSCHANNEL); err != nil {
+			return fmt.Errorf("%w: %v", driver.ErrBadConn, err)
+		}
+		for {
+			notification, err := pgxConn.Conn().WaitForNotification(ctx)
+			if err != nil {
+				return fmt.Errorf("%w: %v", driver.ErrBadConn, err)
+			}
+			event := &models.OutboxEvent{}
+			if err := json.Unmarshal([]byte(notification.Payload), event); err != nil {
+				logger.Error("Error decoding broadcast event", zap.Error(err))
+				continue
+			}
+			if err := handle(ctx, event); err != nil {
+				logger.Error("Error handling broadcast event", zap.Int64("event", event.ID), zap.Error(err))
+			}
+		}
+	})
+}
+
+// localEvents broadcasts the events inside the process, for the storages
+// that only one instance uses.
+type localEvents struct {
+	mu       sync.RWMutex
+	lastID   int
+	handlers map[int]func(context.Context, *models.OutboxEvent) error
+}
+
+func (e *localEvents) BroadcastEvent(ctx context.Context, event *models.OutboxEvent, logger *zap.Logger) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, handle := range e.handlers {
+		if err := handle(ctx, event); err != nil {
+			logger.Error("Error handling broadcast event", zap.Int64("event", event.ID), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (e *localEvents) ListenEvents(ctx context.Context, handle func(context.Context, *models.OutboxEvent) error, logger *zap.Logger) error {
+	e.mu.Lock()
+	if e.handlers == nil {
+		e.handlers = make(map[int]func(context.Context, *models.OutboxEvent) error)
+	}
+	e.lastID++
+	id := e.lastID
+	e.handlers[id] = handle
+	e.mu.Unlock()
+
+	<-ctx.Done()
+	e.mu.Lock()
+	delete(e.handlers, id)
+	e.mu.Unlock()
+	return nil
+}
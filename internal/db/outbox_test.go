@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOutbox(t *testing.T) {
+	sqlite, _ := newSQLiteTestCursor(t)
+	backends := map[string]*Cursor{
+		"memory": {NewMemoryCursor()},
+		"sqlite": sqlite,
+	}
+	for name, cursor := range backends {
+		t.Run(name, func(t *testing.T) {
+			testOutbox(t, cursor)
+		})
+	}
+}
+
+func testOutbox(t *testing.T, cursor *Cursor) {
+	ctx := context.Background()
+	l := zap.NewNop()
+
+	require.NoError(t, cursor.SaveOrder(ctx, &models.Order{Username: "test", Number: "9278923470", Status: configuration.NEW, UploadedAt: time.Now()}, l))
+	require.NoError(t, cursor.UpdateOrder(ctx, "test", &models.AccrualResponse{Order: "9278923470", Status: configuration.PROCESSING}, l))
+	// an unchanged order is not an event
+	require.NoError(t, cursor.UpdateOrder(ctx, "test", &models.AccrualResponse{Order: "9278923470", Status: configuration.PROCESSING}, l))
+	require.NoError(t, cursor.UpdateOrder(ctx, "test", &models.AccrualResponse{Order: "9278923470", Status: configuration.PROCESSED, Accrual: models.NewMoney(500)}, l))
+	_, err := cursor.AppendLedgerEntry(ctx, &models.LedgerEntry{User: "test", Kind: configuration.CREDIT, Amount: models.NewMoney(500), Order: "9278923470", CreatedAt: time.Now()}, l)
+	require.NoError(t, err)
+	_, err = cursor.Withdraw(ctx, &models.Withdrawal{User: "test", Order: "2377225624", Sum: models.NewMoney(100), ProcessedAt: time.Now()}, l)
+	require.NoError(t, err)
+
+	claimed, err := cursor.ClaimOutbox(ctx, 10, 200*time.Millisecond, l)
+	require.NoError(t, err)
+	types := []string{}
+	for _, event := range claimed {
+		assert.Equal(t, "test", event.User)
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{
+		configuration.ORDERUPDATED,
+		configuration.ORDERUPDATED,
+		configuration.ORDERPROCESSED,
+		configuration.BALANCEUPDATED,
+		configuration.WITHDRAWALCREATED,
+		configuration.BALANCEUPDATED,
+	}, types)
+	assert.Contains(t, claimed[2].Payload, `"9278923470"`)
+	assert.Contains(t, claimed[4].Payload, `"2377225624"`)
+
+	// leased events are not handed out twice
+	claimedAgain, err := cursor.ClaimOutbox(ctx, 10, time.Minute, l)
+	require.NoError(t, err)
+	assert.Empty(t, claimedAgain)
+
+	for _, event := range claimed[:3] {
+		require.NoError(t, cursor.DeleteOutboxEvent(ctx, event.ID, l))
+	}
+	// the rest comes back once the lease is over
+	time.Sleep(300 * time.Millisecond)
+	claimedAgain, err = cursor.ClaimOutbox(ctx, 2, time.Minute, l)
+	require.NoError(t, err)
+	require.Len(t, claimedAgain, 2)
+	assert.Equal(t, claimed[3].ID, claimedAgain[0].ID)
+	assert.Equal(t, claimed[4].ID, claimedAgain[1].ID)
+}
+
+func TestLocalEvents(t *testing.T) {
+	l := zap.NewNop()
+	events := &localEvents{}
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan *models.OutboxEvent, 1)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		events.ListenEvents(ctx, func(ctx context.Context, e *models.OutboxEvent) error {
+			received <- e
+			return nil
+		}, l)
+	}()
+	require.Eventually(t, func() bool {
+		events.mu.RLock()
+		defer events.mu.RUnlock()
+		return len(events.handlers) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, events.BroadcastEvent(ctx, &models.OutboxEvent{ID: 1, User: "test", Type: configuration.ORDERUPDATED}, l))
+	assert.Equal(t, int64(1), (<-received).ID)
+
+	cancel()
+	<-stopped
+	assert.Empty(t, events.handlers)
+	require.NoError(t, events.BroadcastEvent(context.Background(), &models.OutboxEvent{ID: 2}, l))
+	assert.Empty(t, received)
+}
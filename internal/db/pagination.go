@@ -0,0 +1,117 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+// ordersPageQuery extends GetOrders with the filters, keyset condition and
+// limit of the query. Equal upload times are always ordered by number, so the
+// last order of a page is a stable position to continue from.
+func ordersPageQuery(username string, query *models.OrderQuery) (string, []interface{}) {
+	var b strings.Builder
+	args := []interface{}{username}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	b.WriteString(GetOrders)
+	if len(query.Statuses) > 0 {
+		placeholders := make([]string, len(query.Statuses))
+		for i, status := range query.Statuses {
+			placeholders[i] = arg(status)
+		}
+		fmt.Fprintf(&b, " AND _status IN (%s)", strings.Join(placeholders, ", "))
+	}
+	if !query.From.IsZero() {
+		fmt.Fprintf(&b, " AND uploaded_at >= %s", arg(query.From))
+	}
+	if !query.To.IsZero() {
+		fmt.Fprintf(&b, " AND uploaded_at < %s", arg(query.To))
+	}
+	direction, comparison := "DESC", "<"
+	if query.Ascending {
+		direction, comparison = "ASC", ">"
+	}
+	if query.After != nil {
+		at, number := arg(query.After.Time), arg(query.After.Key)
+		fmt.Fprintf(&b, " AND (uploaded_at %s %s OR (uploaded_at = %s AND _number > %s))", comparison, at, at, number)
+	}
+	fmt.Fprintf(&b, " ORDER BY uploaded_at %s, _number", direction)
+	if query.Limit > 0 {
+		fmt.Fprintf(&b, " LIMIT %s", arg(query.Limit))
+	}
+	b.WriteString(";")
+	return b.String(), args
+}
+
+// withdrawalsPageQuery extends GetWithdrawals the same way ordersPageQuery
+// does, always newest first.
+func withdrawalsPageQuery(username string, query *models.WithdrawalQuery) (string, []interface{}) {
+	var b strings.Builder
+	args := []interface{}{username}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	b.WriteString(GetWithdrawals)
+	if !query.From.IsZero() {
+		fmt.Fprintf(&b, " AND processed_at >= %s", arg(query.From))
+	}
+	if !query.To.IsZero() {
+		fmt.Fprintf(&b, " AND processed_at < %s", arg(query.To))
+	}
+	if query.After != nil {
+		at, order := arg(query.After.Time), arg(query.After.Key)
+		fmt.Fprintf(&b, " AND (processed_at < %s OR (processed_at = %s AND _order > %s))", at, at, order)
+	}
+	b.WriteString(" ORDER BY processed_at DESC, _order")
+	if query.Limit > 0 {
+		fmt.Fprintf(&b, " LIMIT %s", arg(query.Limit))
+	}
+	b.WriteString(";")
+	return b.String(), args
+}
+
+// auditPageQuery extends GetAuditLog with the filters of the query. Entries
+// are appended in order, so the ID alone is the position to continue from.
+func auditPageQuery(query *models.AuditQuery) (string, []interface{}) {
+	var b strings.Builder
+	args := []interface{}{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	b.WriteString(GetAuditLog)
+	if query.Actor != "" {
+		fmt.Fprintf(&b, " AND actor = %s", arg(query.Actor))
+	}
+	if query.Subject != "" {
+		fmt.Fprintf(&b, " AND subject = %s", arg(query.Subject))
+	}
+	if query.Action != "" {
+		fmt.Fprintf(&b, " AND action = %s", arg(query.Action))
+	}
+	if !query.From.IsZero() {
+		fmt.Fprintf(&b, " AND created_at >= %s", arg(query.From))
+	}
+	if !query.To.IsZero() {
+		fmt.Fprintf(&b, " AND created_at < %s", arg(query.To))
+	}
+	if query.After != nil {
+		id, _ := strconv.ParseInt(query.After.Key, 10, 64)
+		fmt.Fprintf(&b, " AND id < %s", arg(id))
+	}
+	b.WriteString(" ORDER BY id DESC")
+	if query.Limit > 0 {
+		fmt.Fprintf(&b, " LIMIT %s", arg(query.Limit))
+	}
+	b.WriteString(";")
+	return b.String(), args
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOrdersPage(t *testing.T) {
+	sqlite, _ := newSQLiteTestCursor(t)
+	backends := map[string]*Cursor{
+		"memory": {NewMemoryCursor()},
+		"sqlite": sqlite,
+	}
+	for name, cursor := range backends {
+		t.Run(name, func(t *testing.T) {
+			testOrdersPage(t, cursor)
+		})
+	}
+}
+
+func TestWithdrawalsPage(t *testing.T) {
+	sqlite, _ := newSQLiteTestCursor(t)
+	backends := map[string]*Cursor{
+		"memory": {NewMemoryCursor()},
+		"sqlite": sqlite,
+	}
+	for name, cursor := range backends {
+		t.Run(name, func(t *testing.T) {
+			testWithdrawalsPage(t, cursor)
+		})
+	}
+}
+
+func testOrdersPage(t *testing.T, cursor *Cursor) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	start := time.Date(2020, 12, 9, 16, 9, 53, 0, time.Local)
+	orders := []*models.Order{
+		{Number: "1", Status: configuration.NEW, UploadedAt: start},
+		{Number: "2", Status: configuration.PROCESSED, UploadedAt: start},
+		{Number: "3", Status: configuration.INVALID, UploadedAt: start.Add(time.Hour)},
+		{Number: "4", Status: configuration.PROCESSED, UploadedAt: start.Add(2 * time.Hour)},
+		{Number: "5", Status: configuration.NEW, UploadedAt: start.Add(3 * time.Hour)},
+	}
+	for _, order := range orders {
+		order.Username = "test"
+		require.NoError(t, cursor.SaveOrder(ctx, order, l))
+	}
+	require.NoError(t, cursor.SaveOrder(ctx, &models.Order{Username: "other", Number: "6", Status: configuration.NEW, UploadedAt: start}, l))
+
+	numbers := func(query *models.OrderQuery) []string {
+		found, err := cursor.GetOrders(ctx, "test", query, l)
+		require.NoError(t, err)
+		result := []string{}
+		for _, order := range found {
+			result = append(result, order.Number)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"5", "4", "3", "1", "2"}, numbers(nil))
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, numbers(&models.OrderQuery{Ascending: true}))
+
+	page := numbers(&models.OrderQuery{Limit: 2})
+	assert.Equal(t, []string{"5", "4"}, page)
+	page = numbers(&models.OrderQuery{Limit: 2, After: &models.PageCursor{Time: start.Add(2 * time.Hour), Key: "4"}})
+	assert.Equal(t, []string{"3", "1"}, page)
+	page = numbers(&models.OrderQuery{Limit: 2, After: &models.PageCursor{Time: start, Key: "1"}})
+	assert.Equal(t, []string{"2"}, page)
+
+	assert.Equal(t, []string{"4", "2"}, numbers(&models.OrderQuery{Statuses: []string{configuration.PROCESSED}}))
+	assert.Equal(t, []string{"4", "3"}, numbers(&models.OrderQuery{From: start.Add(time.Hour), To: start.Add(3 * time.Hour)}))
+}
+
+func testWithdrawalsPage(t *testing.T, cursor *Cursor) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	start := time.Date(2020, 12, 9, 16, 9, 53, 0, time.Local)
+	withdrawals := []*models.Withdrawal{
+		{Order: "1", ProcessedAt: start},
+		{Order: "2", ProcessedAt: start},
+		{Order: "3", ProcessedAt: start.Add(time.Hour)},
+		{Order: "4", ProcessedAt: start.Add(2 * time.Hour)},
+	}
+	for _, w := range withdrawals {
+		w.User = "test"
+		w.Sum = models.NewMoney(1)
+		require.NoError(t, cursor.SaveWithdrawal(ctx, w, l))
+	}
+	require.NoError(t, cursor.SaveWithdrawal(ctx, &models.Withdrawal{User: "other", Order: "5", Sum: models.NewMoney(1), ProcessedAt: start}, l))
+
+	orders := func(query *models.WithdrawalQuery) []string {
+		found, err := cursor.GetWithdrawals(ctx, "test", query, l)
+		require.NoError(t, err)
+		result := []string{}
+		for _, w := range found {
+			result = append(result, w.Order)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"4", "3", "1", "2"}, orders(nil))
+	assert.Equal(t, []string{"4", "3"}, orders(&models.WithdrawalQuery{Limit: 2}))
+	assert.Equal(t, []string{"1", "2"}, orders(&models.WithdrawalQuery{Limit: 2, After: &models.PageCursor{Time: start.Add(time.Hour), Key: "3"}}))
+	assert.Equal(t, []string{"2"}, orders(&models.WithdrawalQuery{After: &models.PageCursor{Time: start, Key: "1"}}))
+	assert.Equal(t, []string{"3", "1", "2"}, orders(&models.WithdrawalQuery{To: start.Add(2 * time.Hour)}))
+	assert.Equal(t, []string{"4", "3"}, orders(&models.WithdrawalQuery{From: start.Add(time.Hour)}))
+}
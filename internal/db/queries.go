@@ -1,19 +1,129 @@
 package db
 
 const (
-	SaveSession    string = `INSERT INTO _sessions VALUES ($1, $2, $3);`
-	GetUserInfo           = `SELECT * FROM userinfo WHERE username=$1;`
+	SaveSession    string = `INSERT INTO _sessions (username, token, expires_at, refresh_token, refresh_expires_at, created_at, user_agent, ip) VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8) RETURNING id;`
+	GetUserInfo           = `SELECT username, _password, _role FROM userinfo WHERE username=$1;`
 	GetOrder              = `SELECT * FROM orders WHERE username=$1 AND _number=$2;`
 	SaveOrder             = `INSERT INTO orders VALUES ($1, $2, $3, $4, $5);`
-	GetOrders             = `SELECT * FROM orders WHERE username=$1;`
+	GetOrders             = `SELECT username, _number, _status, accrual, uploaded_at FROM orders WHERE username=$1`
 	GetSessionUser        = `SELECT username FROM _sessions WHERE token=$1;`
 	GetBalance            = `SELECT * FROM balances WHERE username=$1;`
-	UpdateBalance  string = `UPDATE balances SET _current=$1, withdrawn=$2 WHERE username=$3;`
-	GetWithdrawals        = `SELECT * FROM withdrawal WHERE username=$1;`
+	LockBalance           = `SELECT * FROM balances WHERE username=$1 FOR UPDATE;`
+	GetWithdrawals        = `SELECT username, _order, _sum, processed_at FROM withdrawal WHERE username=$1`
 	SaveWithdrawal        = `INSERT INTO withdrawal VALUES ($1, $2, $3, $4);`
 	UpdateOrder           = `UPDATE orders SET _status=$1, accrual=$2 WHERE username=$3 AND _number=$4;`
-	GetSession            = `SELECT * FROM _sessions WHERE token=$1;`
-	GetAllOrders          = `SELECT * FROM orders;`
-	SaveUserInfo          = `INSERT INTO userinfo VALUES ($1, $2);`
-	SaveBalance           = `INSERT INTO balances VALUES ($1, $2, $3);`
+	GetSession            = `SELECT id, username, token, expires_at, COALESCE(refresh_token, ''), refresh_expires_at, created_at, user_agent, ip, COALESCE((SELECT _role FROM userinfo WHERE userinfo.username=_sessions.username), 'user') FROM _sessions WHERE token=$1;`
+	GetSessionByID        = `SELECT id, username, token, expires_at, COALESCE(refresh_token, ''), refresh_expires_at, created_at, user_agent, ip,
+	COALESCE((SELECT _role FROM userinfo WHERE userinfo.username=_sessions.username), 'user') FROM _sessions
+WHERE username=$1 AND id=$2 AND refresh_expires_at > $3;`
+	GetAllOrders   = `SELECT * FROM orders;`
+	SaveUserInfo   = `INSERT INTO userinfo (username, _password, _role) VALUES ($1, $2, $3);`
+	UpdatePassword = `UPDATE userinfo SET _password=$2 WHERE username=$1;`
+	SetUserRole    = `UPDATE userinfo SET _role=$2 WHERE username=$1;`
+	SaveBalance    = `INSERT INTO balances VALUES ($1, $2, $3);`
+	EnqueueJob     = `INSERT INTO accrual_jobs (_number, username, created_at, next_attempt_at)
+SELECT $1::varchar, $2::varchar, $3::timestamp, $3::timestamp
+WHERE NOT EXISTS (SELECT 1 FROM accrual_dead_jobs WHERE _number=$1)
+ON CONFLICT (_number) DO NOTHING;`
+	ClaimJobs = `UPDATE accrual_jobs SET locked_until=$2
+WHERE _number IN (
+	SELECT _number FROM accrual_jobs
+	WHERE next_attempt_at <= $1 AND (locked_until IS NULL OR locked_until < $1)
+	ORDER BY next_attempt_at
+	LIMIT $3
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING _number, username, created_at, locked_until, next_attempt_at, attempts, COALESCE(last_error, '');`
+	CompleteJob   = `DELETE FROM accrual_jobs WHERE _number=$1;`
+	RescheduleJob = `UPDATE accrual_jobs SET locked_until=NULL, next_attempt_at=$2 WHERE _number=$1;`
+	FailJob       = `UPDATE accrual_jobs SET locked_until=NULL, next_attempt_at=$2, attempts=attempts+1, last_error=$3 WHERE _number=$1;`
+	BuryJob       = `INSERT INTO accrual_dead_jobs
+SELECT _number, username, created_at, attempts+1, $2::text, $3::timestamp FROM accrual_jobs WHERE _number=$1
+ON CONFLICT (_number) DO NOTHING;`
+	GetDeadJobs = `SELECT _number, username, created_at, attempts, COALESCE(last_error, ''), failed_at FROM accrual_dead_jobs ORDER BY failed_at;`
+	RedriveJob  = `INSERT INTO accrual_jobs (_number, username, created_at, next_attempt_at)
+SELECT _number, username, created_at, $2::timestamp FROM accrual_dead_jobs WHERE _number=$1
+ON CONFLICT (_number) DO NOTHING;`
+	DeleteDeadJob = `DELETE FROM accrual_dead_jobs WHERE _number=$1;`
+	AppendLedger  = `INSERT INTO ledger (username, kind, amount, _order, reason, created_at)
+VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)
+ON CONFLICT DO NOTHING
+RETURNING id;`
+	ApplyLedger = `INSERT INTO balances VALUES ($1, $2, $3)
+ON CONFLICT (username) DO UPDATE SET _current=balances._current+EXCLUDED._current, withdrawn=balances.withdrawn+EXCLUDED.withdrawn
+RETURNING username, _current, withdrawn;`
+	GetLedger         = `SELECT id, username, kind, amount, COALESCE(_order, ''), COALESCE(reason, ''), created_at FROM ledger WHERE username=$1 ORDER BY id;`
+	SaveWebhook       = `INSERT INTO webhooks (username, url, secret, created_at) VALUES ($1, $2, $3, $4) RETURNING id;`
+	GetWebhooks       = `SELECT id, username, url, secret, created_at FROM webhooks WHERE username=$1 ORDER BY id;`
+	DeleteWebhook     = `DELETE FROM webhooks WHERE username=$1 AND id=$2;`
+	DeleteDeliveries  = `DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE username=$1 AND id=$2);`
+	EnqueueDeliveries = `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, created_at, next_attempt_at)
+SELECT id, $2::varchar, $3::varchar, $4::text, $5::timestamp, $5::timestamp FROM webhooks WHERE username=$1;`
+	ClaimDeliveries = `UPDATE webhook_deliveries SET locked_until=$2
+WHERE id IN (
+	SELECT id FROM webhook_deliveries
+	WHERE status='PENDING' AND next_attempt_at <= $1 AND (locked_until IS NULL OR locked_until < $1)
+	ORDER BY next_attempt_at
+	LIMIT $3
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING id, webhook_id,
+	(SELECT url FROM webhooks WHERE webhooks.id=webhook_deliveries.webhook_id),
+	(SELECT secret FROM webhooks WHERE webhooks.id=webhook_deliveries.webhook_id),
+	event_id, event_type, payload, status, attempts, created_at;`
+	SaveDeliveryAttempt = `UPDATE webhook_deliveries
+SET status=$2, attempts=$3, response_code=NULLIF($4, 0), last_error=NULLIF($5, ''), next_attempt_at=$6, delivered_at=$7, locked_until=NULL
+WHERE id=$1;`
+	AppendOutbox = `INSERT INTO outbox (username, event_type, payload, created_at) VALUES ($1, $2, $3, $4);`
+	ClaimOutbox  = `UPDATE outbox SET locked_until=$2
+WHERE id IN (
+	SELECT id FROM outbox
+	WHERE locked_until IS NULL OR locked_until < $1
+	ORDER BY id
+	LIMIT $3
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING id, username, event_type, payload, created_at;`
+	DeleteOutbox   = `DELETE FROM outbox WHERE id=$1;`
+	NotifyEvent    = `SELECT pg_notify($1, $2);`
+	RefreshSession = `UPDATE _sessions SET token=$2, expires_at=$3, refresh_token=$4, refresh_expires_at=$5, ip=$6
+WHERE refresh_token=$1 AND refresh_expires_at > $7
+RETURNING id, username, created_at, user_agent, COALESCE((SELECT _role FROM userinfo WHERE userinfo.username=_sessions.username), 'user');`
+	GetSessions = `SELECT id, username, token, expires_at, COALESCE(refresh_token, ''), refresh_expires_at, created_at, user_agent, ip,
+	COALESCE((SELECT _role FROM userinfo WHERE userinfo.username=_sessions.username), 'user') FROM _sessions
+WHERE username=$1 AND refresh_expires_at > $2
+ORDER BY created_at DESC, id DESC;`
+	DeleteSession         = `DELETE FROM _sessions WHERE username=$1 AND id=$2;`
+	DeleteExpiredSessions = `DELETE FROM _sessions WHERE refresh_expires_at <= $1;`
+	GetDeliveries         = `SELECT d.id, d.webhook_id, w.url, d.event_id, d.event_type, d.status, d.attempts,
+	COALESCE(d.response_code, 0), COALESCE(d.last_error, ''), d.created_at, d.delivered_at
+FROM webhook_deliveries d JOIN webhooks w ON w.id=d.webhook_id
+WHERE w.username=$1 AND d.webhook_id=$2
+ORDER BY d.id DESC;`
+	SaveAdjustment = `INSERT INTO adjustments (username, amount, reason_code, reason, status, requested_by, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;`
+	GetAdjustments = `SELECT id, username, amount, reason_code, reason, status, requested_by, COALESCE(reviewed_by, ''), created_at, reviewed_at
+FROM adjustments ORDER BY id DESC;`
+	GetAdjustmentsByStatus = `SELECT id, username, amount, reason_code, reason, status, requested_by, COALESCE(reviewed_by, ''), created_at, reviewed_at
+FROM adjustments WHERE status=$1 ORDER BY id DESC;`
+	GetAdjustment = `SELECT id, username, amount, reason_code, reason, status, requested_by, COALESCE(reviewed_by, ''), created_at, reviewed_at
+FROM adjustments WHERE id=$1;`
+	LockAdjustment = `SELECT id, username, amount, reason_code, reason, status, requested_by, COALESCE(reviewed_by, ''), created_at, reviewed_at
+FROM adjustments WHERE id=$1 FOR UPDATE;`
+	ReviewAdjustment = `UPDATE adjustments SET status=$2, reviewed_by=$3, reviewed_at=$4 WHERE id=$1;`
+	AppendAudit      = `INSERT INTO audit_log (actor, action, subject, ip, user_agent, before_value, after_value, created_at)
+VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8) RETURNING id;`
+	GetAuditLog = `SELECT id, actor, action, COALESCE(subject, ''), COALESCE(ip, ''), COALESCE(user_agent, ''),
+	COALESCE(before_value, ''), COALESCE(after_value, ''), created_at
+FROM audit_log WHERE TRUE`
+	GetLoginAttempts   = `SELECT scope, _key, failures, last_failure_at, locked_until FROM login_attempts WHERE scope=$1 AND _key=$2;`
+	RecordLoginAttempt = `INSERT INTO login_attempts (scope, _key, failures, last_failure_at) VALUES ($1, $2, 1, $3)
+ON CONFLICT (scope, _key) DO UPDATE
+SET failures=CASE WHEN login_attempts.last_failure_at < $4 THEN 1 ELSE login_attempts.failures+1 END, last_failure_at=EXCLUDED.last_failure_at
+WHERE login_attempts.locked_until IS NULL OR login_attempts.locked_until <= $3
+RETURNING failures;`
+	ReleaseLoginAttempt      = `UPDATE login_attempts SET failures=failures-1 WHERE scope=$1 AND _key=$2 AND failures > 0;`
+	LockLogin                = `UPDATE login_attempts SET locked_until=$3 WHERE scope=$1 AND _key=$2 AND (locked_until IS NULL OR locked_until < $3);`
+	ResetLoginAttempts       = `DELETE FROM login_attempts WHERE scope=$1 AND _key=$2;`
+	DeleteStaleLoginAttempts = `DELETE FROM login_attempts WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $2);`
 )
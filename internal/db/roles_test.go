@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRoles(t *testing.T) {
+	sqlite, _ := newSQLiteTestCursor(t)
+	backends := map[string]*Cursor{
+		"memory": {NewMemoryCursor()},
+		"sqlite": sqlite,
+	}
+	for name, cursor := range backends {
+		t.Run(name, func(t *testing.T) {
+			testRoles(t, cursor)
+		})
+	}
+}
+
+func testRoles(t *testing.T, cursor *Cursor) {
+	ctx := context.Background()
+	l := zap.NewNop()
+
+	require.NoError(t, cursor.SaveUserInfo(ctx, &models.UserInfo{Username: "test", Password: "test"}, l))
+	info, err := cursor.GetUserInfo(ctx, &models.UserInfo{Username: "test"}, l)
+	require.NoError(t, err)
+	assert.Equal(t, configuration.ROLEUSER, info.Role)
+	_, err = cursor.GetUserInfo(ctx, &models.UserInfo{Username: "nobody"}, l)
+	assert.Equal(t, errors.ErrNotFound, err)
+
+	require.NoError(t, cursor.SaveSession(ctx, "token", &models.Session{Username: "test", Token: "token", ExpiresAt: time.Now().Add(time.Minute)}, l))
+	require.NoError(t, cursor.SetUserRole(ctx, "test", configuration.ROLEADMIN, l))
+	assert.Equal(t, errors.ErrNotFound, cursor.SetUserRole(ctx, "nobody", configuration.ROLEADMIN, l))
+
+	info, err = cursor.GetUserInfo(ctx, &models.UserInfo{Username: "test"}, l)
+	require.NoError(t, err)
+	assert.Equal(t, configuration.ROLEADMIN, info.Role)
+	// sessions pick the new role up without logging in again
+	session, err := cursor.GetSession(ctx, "token", l)
+	require.NoError(t, err)
+	assert.Equal(t, configuration.ROLEADMIN, session.Role)
+}
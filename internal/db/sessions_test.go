@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSessions(t *testing.T) {
+	sqlite, _ := newSQLiteTestCursor(t)
+	backends := map[string]*Cursor{
+		"memory": {NewMemoryCursor()},
+		"sqlite": sqlite,
+	}
+	for name, cursor := range backends {
+		t.Run(name, func(t *testing.T) {
+			testSessions(t, cursor)
+		})
+	}
+}
+
+func testSessions(t *testing.T, cursor *Cursor) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	now := time.Now()
+
+	laptop := &models.Session{
+		Username:         "test",
+		Token:            "laptop",
+		ExpiresAt:        now.Add(time.Minute),
+		RefreshToken:     "laptop-refresh",
+		RefreshExpiresAt: now.Add(time.Hour),
+		CreatedAt:        now.Add(-time.Minute),
+		UserAgent:        "laptop",
+		IP:               "10.0.0.1",
+	}
+	phone := &models.Session{
+		Username:         "test",
+		Token:            "phone",
+		ExpiresAt:        now.Add(time.Minute),
+		RefreshToken:     "phone-refresh",
+		RefreshExpiresAt: now.Add(time.Hour),
+		CreatedAt:        now,
+		UserAgent:        "phone",
+	}
+	// a plain session ends with its access token
+	legacy := &models.Session{Username: "test", Token: "legacy", ExpiresAt: now.Add(-time.Second)}
+	other := &models.Session{Username: "other", Token: "other", ExpiresAt: now.Add(time.Minute)}
+	for _, session := range []*models.Session{laptop, phone, legacy, other} {
+		require.NoError(t, cursor.SaveSession(ctx, session.Token, session, l))
+		assert.NotZero(t, session.ID)
+	}
+
+	found, err := cursor.GetSession(ctx, "laptop", l)
+	require.NoError(t, err)
+	assert.Equal(t, laptop.ID, found.ID)
+	assert.Equal(t, "10.0.0.1", found.IP)
+	assert.Equal(t, "laptop-refresh", found.RefreshToken)
+
+	found, err = cursor.GetSessionByID(ctx, "test", phone.ID, l)
+	require.NoError(t, err)
+	assert.Equal(t, "phone", found.Token)
+	assert.Equal(t, "user", found.Role)
+	_, err = cursor.GetSessionByID(ctx, "test", other.ID, l)
+	assert.Equal(t, errors.ErrNotFound, err)
+	_, err = cursor.GetSessionByID(ctx, "test", legacy.ID, l)
+	assert.Equal(t, errors.ErrNotFound, err)
+
+	sessions, err := cursor.GetSessions(ctx, "test", l)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, phone.ID, sessions[0].ID)
+	assert.Equal(t, laptop.ID, sessions[1].ID)
+
+	refreshed := &models.Session{
+		Token:            "laptop-2",
+		ExpiresAt:        now.Add(2 * time.Minute),
+		RefreshToken:     "laptop-refresh-2",
+		RefreshExpiresAt: now.Add(2 * time.Hour),
+		IP:               "10.0.0.2",
+	}
+	require.NoError(t, cursor.RefreshSession(ctx, "laptop-refresh", refreshed, l))
+	assert.Equal(t, laptop.ID, refreshed.ID)
+	assert.Equal(t, "test", refreshed.Username)
+	assert.Equal(t, "laptop", refreshed.UserAgent)
+	assert.Equal(t, errors.ErrNotFound, cursor.RefreshSession(ctx, "laptop-refresh", &models.Session{Token: "laptop-3"}, l))
+	username, err := cursor.GetUsernameByToken(ctx, "laptop-2", l)
+	require.NoError(t, err)
+	assert.Equal(t, "test", username)
+	_, err = cursor.GetSession(ctx, "laptop", l)
+	assert.Error(t, err)
+
+	assert.Equal(t, errors.ErrNotFound, cursor.DeleteSession(ctx, "test", other.ID, l))
+	require.NoError(t, cursor.DeleteSession(ctx, "test", phone.ID, l))
+	_, err = cursor.GetSessionByID(ctx, "test", phone.ID, l)
+	assert.Equal(t, errors.ErrNotFound, err)
+	sessions, err = cursor.GetSessions(ctx, "test", l)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "10.0.0.2", sessions[0].IP)
+
+	deleted, err := cursor.DeleteExpiredSessions(ctx, now, l)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	_, err = cursor.GetSession(ctx, "legacy", l)
+	assert.Error(t, err)
+	deleted, err = cursor.DeleteExpiredSessions(ctx, now.Add(3*time.Hour), l)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+	"go.uber.org/zap"
+
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"modernc.org/sqlite"
+)
+
+const (
+	SQLiteEnqueueJob = `INSERT INTO accrual_jobs (_number, username, created_at, next_attempt_at)
+SELECT $1, $2, $3, $3
+WHERE NOT EXISTS (SELECT 1 FROM accrual_dead_jobs WHERE _number=$1)
+ON CONFLICT (_number) DO NOTHING;`
+	SQLiteClaimJobs = `UPDATE accrual_jobs SET locked_until=$2
+WHERE _number IN (
+	SELECT _number FROM accrual_jobs
+	WHERE next_attempt_at <= $1 AND (locked_until IS NULL OR locked_until < $1)
+	ORDER BY next_attempt_at
+	LIMIT $3
+)
+RETURNING _number, username, created_at, locked_until, next_attempt_at, attempts, COALESCE(last_error, '');`
+	SQLiteBuryJob = `INSERT INTO accrual_dead_jobs
+SELECT _number, username, created_at, attempts+1, $2, $3 FROM accrual_jobs WHERE _number=$1
+ON CONFLICT (_number) DO NOTHING;`
+	SQLiteRedriveJob = `INSERT INTO accrual_jobs (_number, username, created_at, next_attempt_at)
+SELECT _number, username, created_at, $2 FROM accrual_dead_jobs WHERE _number=$1
+ON CONFLICT (_number) DO NOTHING;`
+	SQLiteEnqueueDeliveries = `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, created_at, next_attempt_at)
+SELECT id, $2, $3, $4, $5, $5 FROM webhooks WHERE username=$1;`
+	SQLiteClaimDeliveries = `UPDATE webhook_deliveries SET locked_until=$2
+WHERE id IN (
+	SELECT id FROM webhook_deliveries
+	WHERE status='PENDING' AND next_attempt_at <= $1 AND (locked_until IS NULL OR locked_until < $1)
+	ORDER BY next_attempt_at
+	LIMIT $3
+)
+RETURNING id, webhook_id,
+	(SELECT url FROM webhooks WHERE webhooks.id=webhook_deliveries.webhook_id),
+	(SELECT secret FROM webhooks WHERE webhooks.id=webhook_deliveries.webhook_id),
+	event_id, event_type, payload, status, attempts, created_at;`
+	SQLiteClaimOutbox = `UPDATE outbox SET locked_until=$2
+WHERE id IN (
+	SELECT id FROM outbox
+	WHERE locked_until IS NULL OR locked_until < $1
+	ORDER BY id
+	LIMIT $3
+)
+RETURNING id, username, event_type, payload, created_at;`
+)
+
+// SQLiteCursor keeps the data in a SQLite file using a pure-Go driver. It
+// shares the Postgres queries except the ones relying on casts or row locks.
+type SQLiteCursor struct {
+	*IDBCursor
+	events localEvents
+}
+
+// NewSQLiteCursor opens the database given as sqlite://path/to/file.db and
+// runs the migrations from migrations/sqlite.
+func NewSQLiteCursor(IDBURL string, timeout time.Duration, logger *zap.Logger) (*SQLiteCursor, error) {
+	db := sql.OpenDB(sqliteConnector{dsn: sqliteDSN(IDBURL)})
+	// SQLite has a single writer anyway. One connection serializes the
+	// transactions, which is what row locks give us on Postgres.
+	db.SetMaxOpenConns(1)
+	n := &SQLiteCursor{IDBCursor: &IDBCursor{
+		DB:      db,
+		Timeout: timeout,
+		Logger:  logger,
+	}}
+	if err := n.Ping(context.Background(), logger); err != nil {
+		logger.Info("DB ping error", zap.String("", err.Error()))
+		return nil, err
+	}
+	err := RunMigrations(configuration.SQLITEMIGRATIONS, IDBURL, logger)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// sqliteDSN turns the sqlite:// URI into a driver DSN.
+func sqliteDSN(url string) string {
+	dsn := strings.TrimPrefix(url, configuration.SQLITESCHEME)
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	timeout := configuration.SQLITEBUSYTIMEOUT * time.Second
+	return fmt.Sprintf("%s%s_pragma=busy_timeout(%d)", dsn, separator, timeout.Milliseconds())
+}
+
+// sqliteConn is what database/sql uses of a driver connection.
+type sqliteConn interface {
+	driver.Conn
+	driver.Pinger
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+}
+
+// utcConn stores timestamps as SQLITETIMEFORMAT strings in UTC. SQLite has
+// no time type and compares them as text, which only orders them when they
+// all have the same zone and width.
+type utcConn struct {
+	sqliteConn
+}
+
+func (c utcConn) CheckNamedValue(value *driver.NamedValue) error {
+	if t, ok := value.Value.(time.Time); ok {
+		value.Value = t.UTC().Format(configuration.SQLITETIMEFORMAT)
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+type sqliteConnector struct {
+	dsn string
+}
+
+func (c sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return utcConn{conn.(sqliteConn)}, nil
+}
+
+func (c sqliteConnector) Driver() driver.Driver {
+	return &sqlite.Driver{}
+}
+
+func (c *SQLiteCursor) EnqueueJob(ctx context.Context, job *models.AccrualJob, logger *zap.Logger) error {
+	return c.enqueueJob(ctx, SQLiteEnqueueJob, job, logger)
+}
+
+func (c *SQLiteCursor) ClaimJobs(ctx context.Context, limit int, lease time.Duration, logger *zap.Logger) ([]*models.AccrualJob, error) {
+	return c.claimJobs(ctx, SQLiteClaimJobs, limit, lease, logger)
+}
+
+func (c *SQLiteCursor) BuryJob(ctx context.Context, number string, lastError string, logger *zap.Logger) error {
+	return c.buryJob(ctx, SQLiteBuryJob, number, lastError, logger)
+}
+
+func (c *SQLiteCursor) RedriveJob(ctx context.Context, number string, logger *zap.Logger) error {
+	return c.redriveJob(ctx, SQLiteRedriveJob, number, logger)
+}
+
+func (c *SQLiteCursor) Withdraw(ctx context.Context, withdrawal *models.Withdrawal, logger *zap.Logger) (*models.Balance, error) {
+	return c.withdraw(ctx, GetBalance, withdrawal, logger)
+}
+
+func (c *SQLiteCursor) EnqueueDeliveries(ctx context.Context, username string, event *models.WebhookEvent, payload []byte, logger *zap.Logger) error {
+	return c.enqueueDeliveries(ctx, SQLiteEnqueueDeliveries, username, event, payload, logger)
+}
+
+func (c *SQLiteCursor) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration, logger *zap.Logger) ([]*models.WebhookDelivery, error) {
+	return c.claimDeliveries(ctx, SQLiteClaimDeliveries, limit, lease, logger)
+}
+
+// BroadcastEvent hands the event to the listeners of this process, a SQLite
+// file is not shared by several instances.
+func (c *SQLiteCursor) BroadcastEvent(ctx context.Context, event *models.OutboxEvent, logger *zap.Logger) error {
+	return c.events.BroadcastEvent(ctx, event, logger)
+}
+
+func (c *SQLiteCursor) ListenEvents(ctx context.Context, handle func(context.Context, *models.OutboxEvent) error, logger *zap.Logger) error {
+	return c.events.ListenEvents(ctx, handle, logger)
+}
+
+func (c *SQLiteCursor) ClaimOutbox(ctx context.Context, limit int, lease time.Duration, logger *zap.Logger) ([]*models.OutboxEvent, error) {
+	return c.claimOutbox(ctx, SQLiteClaimOutbox, limit, lease, logger)
+}
+
+func (c *SQLiteCursor) SaveAdjustment(ctx context.Context, adjustment *models.Adjustment, logger *zap.Logger) error {
+	return c.saveAdjustment(ctx, GetBalance, adjustment, logger)
+}
+
+func (c *SQLiteCursor) ReviewAdjustment(ctx context.Context, review *models.Adjustment, logger *zap.Logger) error {
+	return c.reviewAdjustment(ctx, GetAdjustment, GetBalance, review, logger)
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// chdirRoot moves to the repository root for the test, migrations are
+// looked up relative to it.
+func chdirRoot(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir("../.."))
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func newSQLiteTestCursor(t *testing.T) (*Cursor, string) {
+	chdirRoot(t)
+	url := configuration.SQLITESCHEME + filepath.Join(t.TempDir(), "gophermart.db")
+	cursor, err := GetCursor(url, 0, zap.NewNop())
+	require.NoError(t, err)
+	_, ok := cursor.IDBInterface.(*SQLiteCursor)
+	require.True(t, ok)
+	return cursor, url
+}
+
+func TestSQLiteOrders(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	cursor, url := newSQLiteTestCursor(t)
+
+	assert.NoError(t, cursor.SaveUserInfo(ctx, &models.UserInfo{Username: "test", Password: "test"}, l))
+	assert.Error(t, cursor.SaveUserInfo(ctx, &models.UserInfo{Username: "test", Password: "test"}, l))
+
+	uploadedAt := time.Now().Truncate(time.Second)
+	assert.NoError(t, cursor.SaveOrder(ctx, &models.Order{
+		Username:   "test",
+		Number:     "9278923470",
+		Status:     configuration.NEW,
+		UploadedAt: uploadedAt,
+	}, l))
+	assert.Error(t, cursor.SaveOrder(ctx, &models.Order{
+		Username:   "test",
+		Number:     "12345678903",
+		Status:     "UNKNOWN",
+		UploadedAt: uploadedAt,
+	}, l))
+
+	assert.NoError(t, cursor.UpdateOrder(ctx, "test", &models.AccrualResponse{
+		Order:   "9278923470",
+		Status:  configuration.PROCESSED,
+		Accrual: models.NewMoney(729.98),
+	}, l))
+	order, err := cursor.GetOrder(ctx, "test", "9278923470", l)
+	assert.NoError(t, err)
+	assert.Equal(t, configuration.PROCESSED, order.Status)
+	assert.Equal(t, models.NewMoney(729.98), order.Accrual)
+	assert.True(t, uploadedAt.Equal(order.UploadedAt))
+
+	// reopening an already migrated database is not an error
+	_, err = GetCursor(url, 0, l)
+	assert.NoError(t, err)
+}
+
+func TestSQLiteLedger(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	cursor, _ := newSQLiteTestCursor(t)
+
+	credit := &models.LedgerEntry{
+		User:      "test",
+		Kind:      configuration.CREDIT,
+		Amount:    models.NewMoney(100.1),
+		Order:     "9278923470",
+		CreatedAt: time.Now(),
+	}
+	_, err := cursor.AppendLedgerEntry(ctx, credit, l)
+	assert.NoError(t, err)
+	balance, err := cursor.AppendLedgerEntry(ctx, credit, l)
+	assert.NoError(t, err)
+	assert.Equal(t, models.NewMoney(100.1), balance.Current)
+
+	_, err = cursor.Withdraw(ctx, &models.Withdrawal{User: "test", Order: "2377225624", Sum: models.NewMoney(200), ProcessedAt: time.Now()}, l)
+	assert.Equal(t, errors.ErrInsufficientFunds, err)
+
+	_, err = cursor.Withdraw(ctx, &models.Withdrawal{User: "test", Order: "2377225624", Sum: models.NewMoney(-200), ProcessedAt: time.Now()}, l)
+	assert.Equal(t, errors.ErrValidation, err)
+
+	balance, err = cursor.Withdraw(ctx, &models.Withdrawal{User: "test", Order: "2377225624", Sum: models.NewMoney(0.2), ProcessedAt: time.Now()}, l)
+	assert.NoError(t, err)
+	assert.Equal(t, models.NewMoney(99.9), balance.Current)
+	assert.Equal(t, models.NewMoney(0.2), balance.Withdrawn)
+
+	withdrawals, err := cursor.GetWithdrawals(ctx, "test", nil, l)
+	assert.NoError(t, err)
+	assert.Len(t, withdrawals, 1)
+	entries, err := cursor.GetLedger(ctx, "test", l)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestSQLiteJobs(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	cursor, _ := newSQLiteTestCursor(t)
+
+	job := &models.AccrualJob{Number: "9278923470", Username: "test", CreatedAt: time.Now()}
+	assert.NoError(t, cursor.EnqueueJob(ctx, job, l))
+	assert.NoError(t, cursor.EnqueueJob(ctx, job, l))
+
+	claimed, err := cursor.ClaimJobs(ctx, 10, time.Minute, l)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 1)
+	claimed, err = cursor.ClaimJobs(ctx, 10, time.Minute, l)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 0)
+
+	assert.NoError(t, cursor.BuryJob(ctx, job.Number, "accrual is down", l))
+	assert.NoError(t, cursor.EnqueueJob(ctx, job, l))
+	dead, err := cursor.GetDeadJobs(ctx, l)
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, 1, dead[0].Attempts)
+	assert.Equal(t, "accrual is down", dead[0].LastError)
+
+	assert.NoError(t, cursor.RedriveJob(ctx, job.Number, l))
+	assert.Equal(t, errors.ErrNotFound, cursor.RedriveJob(ctx, job.Number, l))
+	claimed, err = cursor.ClaimJobs(ctx, 10, time.Minute, l)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 1)
+}
+
+func TestSQLiteCanceledContext(t *testing.T) {
+	l := zap.NewNop()
+	cursor, _ := newSQLiteTestCursor(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cursor.GetOrders(ctx, "test", nil, l)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSQLiteTimeZones(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	cursor, _ := newSQLiteTestCursor(t)
+	east := time.FixedZone("east", 12*60*60)
+	west := time.FixedZone("west", -12*60*60)
+	now := time.Now()
+
+	// the lock reads earlier than the attempt as text, but is later
+	lock := &models.LoginAttempts{Scope: configuration.LOGINSCOPEACCOUNT, Key: "test", LastFailureAt: now.In(east)}
+	counted, err := cursor.RecordLoginAttempt(ctx, lock, time.Hour, l)
+	require.NoError(t, err)
+	require.True(t, counted)
+	lock.LockedUntil = now.Add(time.Hour).In(west)
+	require.NoError(t, cursor.LockLogin(ctx, lock, l))
+	attempts := &models.LoginAttempts{Scope: configuration.LOGINSCOPEACCOUNT, Key: "test", LastFailureAt: now.Add(time.Minute).In(east)}
+	counted, err = cursor.RecordLoginAttempt(ctx, attempts, time.Hour, l)
+	require.NoError(t, err)
+	assert.False(t, counted)
+	assert.True(t, now.Add(time.Hour).Equal(attempts.LockedUntil))
+
+	// a job created a moment ago in a zone ahead is due
+	job := &models.AccrualJob{Number: "9278923470", Username: "test", CreatedAt: now.Add(-time.Second).In(east)}
+	require.NoError(t, cursor.EnqueueJob(ctx, job, l))
+	claimed, err := cursor.ClaimJobs(ctx, 10, time.Minute, l)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.True(t, job.CreatedAt.Equal(claimed[0].CreatedAt))
+}
+
+func TestSQLiteMigrateTimestamps(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	chdirRoot(t)
+	path := filepath.Join(t.TempDir(), "gophermart.db")
+
+	// a database from before the timestamps were stored in UTC
+	m, err := migrate.New(configuration.SQLITEMIGRATIONS, configuration.SQLITESCHEME+path)
+	require.NoError(t, err)
+	require.NoError(t, m.Migrate(17))
+	m.Close()
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO audit_log (actor, action, created_at) VALUES ('admin', 'user.login', '2024-01-01 10:00:00.5+02:00');`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	cursor, err := GetCursor(configuration.SQLITESCHEME+path, 0, l)
+	require.NoError(t, err)
+	entries, err := cursor.GetAuditLog(ctx, &models.AuditQuery{}, l)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.True(t, time.Date(2024, 1, 1, 8, 0, 0, 5e8, time.UTC).Equal(entries[0].CreatedAt))
+
+	// the log is still append-only
+	_, err = cursor.IDBInterface.(*SQLiteCursor).DB.ExecContext(ctx, `UPDATE audit_log SET actor='nobody';`)
+	assert.Error(t, err)
+	_, err = cursor.IDBInterface.(*SQLiteCursor).DB.ExecContext(ctx, `DELETE FROM audit_log;`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWebhooks(t *testing.T) {
+	sqlite, _ := newSQLiteTestCursor(t)
+	backends := map[string]*Cursor{
+		"memory": {NewMemoryCursor()},
+		"sqlite": sqlite,
+	}
+	for name, cursor := range backends {
+		t.Run(name, func(t *testing.T) {
+			testWebhooks(t, cursor)
+		})
+	}
+}
+
+func testWebhooks(t *testing.T, cursor *Cursor) {
+	ctx := context.Background()
+	l := zap.NewNop()
+
+	first := &models.Webhook{User: "test", URL: "http://shop.example/first", Secret: "first", CreatedAt: time.Now()}
+	second := &models.Webhook{User: "test", URL: "http://shop.example/second", Secret: "second", CreatedAt: time.Now()}
+	other := &models.Webhook{User: "other", URL: "http://shop.example/other", Secret: "other", CreatedAt: time.Now()}
+	for _, webhook := range []*models.Webhook{first, second, other} {
+		require.NoError(t, cursor.SaveWebhook(ctx, webhook, l))
+		assert.NotZero(t, webhook.ID)
+	}
+	webhooks, err := cursor.GetWebhooks(ctx, "test", l)
+	require.NoError(t, err)
+	require.Len(t, webhooks, 2)
+	assert.Equal(t, first.URL, webhooks[0].URL)
+	assert.Equal(t, second.Secret, webhooks[1].Secret)
+
+	event := &models.WebhookEvent{ID: "event", Type: configuration.ORDERPROCESSED, CreatedAt: time.Now().Add(-time.Second)}
+	require.NoError(t, cursor.EnqueueDeliveries(ctx, "test", event, []byte(`{"id":"event"}`), l))
+
+	claimed, err := cursor.ClaimDeliveries(ctx, 10, time.Minute, l)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	secrets := map[string]string{}
+	for _, d := range claimed {
+		assert.Equal(t, configuration.ORDERPROCESSED, d.EventType)
+		assert.Equal(t, `{"id":"event"}`, d.Payload)
+		secrets[d.URL] = d.Secret
+	}
+	assert.Equal(t, map[string]string{first.URL: "first", second.URL: "second"}, secrets)
+	claimedAgain, err := cursor.ClaimDeliveries(ctx, 10, time.Minute, l)
+	require.NoError(t, err)
+	assert.Len(t, claimedAgain, 0)
+
+	deliveredAt := time.Now()
+	for _, d := range claimed {
+		d.Attempts = 1
+		d.NextAttemptAt = time.Now()
+		if d.WebhookID == first.ID {
+			d.Status = configuration.DELIVERED
+			d.ResponseCode = 200
+			d.DeliveredAt = &deliveredAt
+		} else {
+			d.ResponseCode = 500
+			d.LastError = "unexpected response status 500"
+		}
+		require.NoError(t, cursor.SaveDeliveryAttempt(ctx, d, l))
+	}
+	claimed, err = cursor.ClaimDeliveries(ctx, 10, time.Minute, l)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, second.ID, claimed[0].WebhookID)
+	assert.Equal(t, 1, claimed[0].Attempts)
+
+	deliveries, err := cursor.GetDeliveries(ctx, "test", first.ID, l)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, configuration.DELIVERED, deliveries[0].Status)
+	assert.Equal(t, 200, deliveries[0].ResponseCode)
+	assert.Equal(t, first.URL, deliveries[0].URL)
+	assert.NotNil(t, deliveries[0].DeliveredAt)
+	deliveries, err = cursor.GetDeliveries(ctx, "other", first.ID, l)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 0)
+
+	assert.Equal(t, errors.ErrNotFound, cursor.DeleteWebhook(ctx, "other", first.ID, l))
+	require.NoError(t, cursor.DeleteWebhook(ctx, "test", first.ID, l))
+	webhooks, err = cursor.GetWebhooks(ctx, "test", l)
+	require.NoError(t, err)
+	assert.Len(t, webhooks, 1)
+	deliveries, err = cursor.GetDeliveries(ctx, "test", first.ID, l)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 0)
+}
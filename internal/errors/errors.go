@@ -6,4 +6,15 @@ var ErrValidation error = errors.New("validation error")
 var ErrDatabaseUnreachable error = errors.New("database unreachable")
 var ErrDatabaseSQLQuery error = errors.New("error with SQL query")
 var ErrDatabaseMigration error = errors.New("error with migrations")
-var ErrJobChannelClosed error = errors.New("jobs channel closed")
+var ErrJobManagerStopped error = errors.New("job manager stopped")
+var ErrAccrualResponse error = errors.New("unexpected accrual response")
+var ErrNotFound error = errors.New("not found")
+var ErrInsufficientFunds error = errors.New("not enough money")
+var ErrAlreadyExists error = errors.New("already exists")
+var ErrInvalidToken error = errors.New("invalid token")
+var ErrInvalidKeys error = errors.New("invalid signing keys")
+var ErrNotPending error = errors.New("already reviewed")
+var ErrSelfApproval error = errors.New("cannot review own request")
+var ErrInvalidRateLimits error = errors.New("invalid rate limits")
+var ErrForbiddenAddress error = errors.New("address not allowed")
+var ErrPasswordTooLong error = errors.New("password is longer than 72 bytes")
@@ -0,0 +1,77 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+// Event is something that happened to a user's data. Data is sent to the
+// client as JSON.
+type Event struct {
+	Type string
+	User string
+	Data interface{}
+}
+
+// Bus delivers events to the subscribers of the event's user inside the
+// process. Publishing never blocks: a subscriber that is not keeping up loses
+// the events that do not fit into its buffer.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[string]map[chan Event]struct{})}
+}
+
+// Subscribe returns the channel of the user's events and the function that
+// stops the subscription and closes the channel.
+func (b *Bus) Subscribe(user string) (<-chan Event, func()) {
+	ch := make(chan Event, configuration.EVENTBUFFER)
+	b.mu.Lock()
+	if b.subscribers[user] == nil {
+		b.subscribers[user] = make(map[chan Event]struct{})
+	}
+	b.subscribers[user][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers[user], ch)
+			if len(b.subscribers[user]) == 0 {
+				delete(b.subscribers, user)
+			}
+			close(ch)
+		})
+	}
+}
+
+// Publish hands the event to every subscriber of event.User. A nil Bus drops
+// the event.
+func (b *Bus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subscribers[event.User] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// HandleEvent publishes an outbox event to the subscribers of its user.
+func (b *Bus) HandleEvent(ctx context.Context, event *models.OutboxEvent) error {
+	b.Publish(Event{Type: event.Type, User: event.User, Data: json.RawMessage(event.Payload)})
+	return nil
+}
@@ -0,0 +1,42 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	first, unsubscribeFirst := bus.Subscribe("test")
+	second, unsubscribeSecond := bus.Subscribe("test")
+	other, unsubscribeOther := bus.Subscribe("other")
+	defer unsubscribeOther()
+
+	bus.Publish(Event{Type: configuration.ORDERUPDATED, User: "test", Data: "9278923470"})
+	assert.Equal(t, "9278923470", (<-first).Data)
+	assert.Equal(t, "9278923470", (<-second).Data)
+	assert.Len(t, other, 0)
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+	_, open := <-first
+	assert.False(t, open)
+	bus.Publish(Event{Type: configuration.ORDERUPDATED, User: "test"})
+	assert.Len(t, second, 1)
+	unsubscribeSecond()
+}
+
+func TestBusSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+	ch, unsubscribe := bus.Subscribe("test")
+	defer unsubscribe()
+
+	for i := 0; i < configuration.EVENTBUFFER+1; i++ {
+		bus.Publish(Event{Type: configuration.ORDERUPDATED, User: "test", Data: i})
+	}
+	assert.Len(t, ch, configuration.EVENTBUFFER)
+	assert.Equal(t, 0, (<-ch).Data)
+}
@@ -60,7 +60,7 @@ func (mock *MockAccrualHandler) CreateOrder(rw http.ResponseWriter, r *http.Requ
 		time.Sleep(2 * time.Second)
 
 		mock.mu.Lock()
-		mock.OrdersStorage[number].Accrual = 100
+		mock.OrdersStorage[number].Accrual = models.NewMoney(100)
 		mock.OrdersStorage[number].Status = "PROCESSED"
 		mock.mu.Unlock()
 	}()
@@ -153,7 +153,7 @@ func TestAccrualValidOrder(t *testing.T) {
 	assert.Equal(t, &models.AccrualResponse{
 		Order:   "1",
 		Status:  "PROCESSED",
-		Accrual: 100,
+		Accrual: models.NewMoney(100),
 	}, result)
 }
 
@@ -0,0 +1,26 @@
+package jobmanager
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+)
+
+// Backoff returns the delay before the given failed attempt is retried. The
+// delay doubles with every attempt up to JOBBACKOFFMAX, and half of it is
+// randomized so that jobs failing together do not retry together.
+func Backoff(attempt int) time.Duration {
+	delay := time.Duration(configuration.JOBBACKOFFMAX) * time.Second
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt <= 30 {
+		exp := time.Duration(configuration.JOBBACKOFFBASE) * time.Second << (attempt - 1)
+		if exp < delay {
+			delay = exp
+		}
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
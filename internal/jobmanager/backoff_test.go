@@ -0,0 +1,29 @@
+package jobmanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{attempt: 1, min: 500 * time.Millisecond, max: time.Second},
+		{attempt: 2, min: time.Second, max: 2 * time.Second},
+		{attempt: 5, min: 8 * time.Second, max: 16 * time.Second},
+		{attempt: 20, min: 150 * time.Second, max: 300 * time.Second},
+		{attempt: 100, min: 150 * time.Second, max: 300 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 10; i++ {
+			delay := Backoff(tt.attempt)
+			assert.GreaterOrEqual(t, delay, tt.min)
+			assert.LessOrEqual(t, delay, tt.max)
+		}
+	}
+}
@@ -7,46 +7,65 @@ import (
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 
 	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/events"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
 )
 
 type Job struct {
 	orderNumber string
 	username    string
+	attempts    int
+	ctx         context.Context
 	cancel      context.CancelFunc
 }
 
 type Jobmanager struct {
 	AccrualURL string
-	Jobs       chan *Job
 	Cursor     *db.Cursor
-	mu         sync.Mutex
+	Workers    int
+	Events     *events.Bus
+	Webhooks   *WebhookDispatcher
+	busy       int32
+	limiter    *RateLimiter
 	client     *resty.Client
 	context    context.Context
+	logger     *zap.Logger
 	Shutdown   context.CancelFunc
 }
 
-func NewJobmanager(cursor *db.Cursor, accrualURL string, parent *context.Context) *Jobmanager {
+func NewJobmanager(cursor *db.Cursor, accrualURL string, workers int, parent *context.Context, l *zap.Logger) *Jobmanager {
 	ctx, cancel := context.WithCancel(*parent)
+	if workers <= 0 {
+		workers = configuration.JOBWORKERS
+	}
 	return &Jobmanager{
 		AccrualURL: accrualURL,
-		Jobs:       make(chan *Job),
 		Cursor:     cursor,
+		Workers:    workers,
+		Events:     events.NewBus(),
+		Webhooks:   NewWebhookDispatcher(cursor, l),
+		limiter:    NewRateLimiter(),
+		logger:     l,
 		client:     resty.New().SetBaseURL(accrualURL),
 		context:    ctx,
 		Shutdown:   cancel,
 	}
 }
 
-func (jm *Jobmanager) AskAccrual(url string, number string, l *zap.Logger) (*models.AccrualResponse, int, error) {
+func (jm *Jobmanager) AskAccrual(ctx context.Context, url string, number string, l *zap.Logger) (*models.AccrualResponse, int, error) {
+	if err := jm.limiter.Wait(ctx); err != nil {
+		return nil, 0, err
+	}
 	acc := models.AccrualResponse{}
 	req := jm.client.R().
+		SetContext(ctx).
 		SetResult(&acc).
 		SetPathParam("number", number)
 
@@ -57,79 +76,196 @@ func (jm *Jobmanager) AskAccrual(url string, number string, l *zap.Logger) (*mod
 	}
 	l.Info("Accrual GET status code", zap.String("", strconv.Itoa(resp.StatusCode())))
 	if resp.StatusCode() == 429 {
+		retryAfter := ParseRetryAfter(resp.Header().Get("Retry-After"))
+		limit := ParseRequestLimit(resp.String())
+		l.Warn("Accrual rate limit hit, pausing workers",
+			zap.Duration("retry_after", retryAfter), zap.Int("per_minute", limit))
+		jm.limiter.Throttle(retryAfter, limit)
 		return nil, resp.StatusCode(), nil
 	}
 	if resp.StatusCode() == 204 {
+		jm.limiter.Succeeded()
 		return &models.AccrualResponse{Status: "NEW"}, 204, nil
 	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, resp.StatusCode(), errors.ErrAccrualResponse
+	}
+	jm.limiter.Succeeded()
 	return &acc, resp.StatusCode(), nil
 }
 
-func (jm *Jobmanager) RunJob(job *Job, l *zap.Logger) {
-	response, statusCode, err := jm.AskAccrual(jm.AccrualURL, job.orderNumber, l)
+// RunJob polls the accrual system once for the job's order and stores the
+// result. It reports whether the order reached a final status.
+func (jm *Jobmanager) RunJob(job *Job, l *zap.Logger) (bool, error) {
+	defer job.cancel()
+	response, statusCode, err := jm.AskAccrual(job.ctx, jm.AccrualURL, job.orderNumber, l)
 	if err != nil {
-		job.cancel()
+		return false, err
 	}
 	if statusCode == http.StatusTooManyRequests {
-		time.Sleep(time.Second)
+		return false, nil
 	}
-	for response.Status != "INVALID" && response.Status != "PROCESSED" {
-		response, statusCode, err = jm.AskAccrual(jm.AccrualURL, job.orderNumber, l)
+	if err := jm.Cursor.ApplyAccrual(job.ctx, job.username, response, l); err != nil {
+		return false, err
+	}
+	if response.Status != configuration.INVALID && response.Status != configuration.PROCESSED {
+		return false, nil
+	}
+	l.Info("Job finished")
+	return true, nil
+}
+
+// AddJob persists a job for the order, so it survives restarts until the
+// accrual system reports a final status.
+func (jm *Jobmanager) AddJob(ctx context.Context, orderNumber string, username string) error {
+	if jm.context.Err() != nil {
+		return errors.ErrJobManagerStopped
+	}
+	return jm.Cursor.EnqueueJob(ctx, &models.AccrualJob{
+		Number:    orderNumber,
+		Username:  username,
+		CreatedAt: time.Now(),
+	}, jm.logger)
+}
+
+// RecoverJobs puts every order without a final status back into the queue.
+// Orders that are still queued are left untouched.
+func (jm *Jobmanager) RecoverJobs(l *zap.Logger) error {
+	orders, err := jm.Cursor.GetAllOrders(jm.context)
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		if order.Status != configuration.NEW && order.Status != configuration.PROCESSING {
+			continue
+		}
+		err := jm.Cursor.EnqueueJob(jm.context, &models.AccrualJob{
+			Number:    order.Number,
+			Username:  order.Username,
+			CreatedAt: order.UploadedAt,
+		}, l)
 		if err != nil {
-			job.cancel()
+			return err
 		}
-		if statusCode == 429 {
-			time.Sleep(time.Second)
-			continue
+	}
+	return nil
+}
+
+// schedule claims as many due jobs as there are idle workers and hands them
+// over to the pool.
+func (jm *Jobmanager) schedule(queue chan<- *Job, l *zap.Logger) {
+	if jm.limiter.Paused() {
+		return
+	}
+	idle := jm.Workers - int(atomic.LoadInt32(&jm.busy))
+	if idle <= 0 {
+		return
+	}
+	claimed, err := jm.Cursor.ClaimJobs(jm.context, idle, configuration.JOBLEASE*time.Second, l)
+	if err != nil {
+		l.Error("Error claiming accrual jobs", zap.Error(err))
+		return
+	}
+	for _, c := range claimed {
+		ctx, cancel := context.WithTimeout(jm.context, configuration.JOBTIMEOUT*time.Second)
+		atomic.AddInt32(&jm.busy, 1)
+		queue <- &Job{orderNumber: c.Number, username: c.Username, attempts: c.Attempts, ctx: ctx, cancel: cancel}
+	}
+}
+
+func (jm *Jobmanager) work(queue <-chan *Job, l *zap.Logger) {
+	for job := range queue {
+		l.Info("Running job for order", zap.String("", job.orderNumber))
+		finished, err := jm.RunJob(job, l)
+		switch {
+		case err != nil:
+			jm.failJob(job, err, l)
+		case !finished:
+			next := time.Now().Add(configuration.JOBRESCHEDULEDELAY * time.Second)
+			if err := jm.Cursor.RescheduleJob(jm.context, job.orderNumber, next, l); err != nil {
+				l.Error("Error rescheduling accrual job", zap.Error(err))
+			}
+		default:
+			if err := jm.Cursor.CompleteJob(jm.context, job.orderNumber, l); err != nil {
+				l.Error("Error completing accrual job", zap.Error(err))
+			}
 		}
-		jm.mu.Lock()
-		jm.Cursor.UpdateOrder(job.username, response, l)
-		jm.mu.Unlock()
-	}
-	jm.mu.Lock()
-	jm.Cursor.UpdateOrder(job.username, response, l)
-	jm.Cursor.UpdateUserBalance(job.username, &models.Balance{
-		Current:   response.Accrual,
-		Withdrawn: 0.0,
-	}, l)
-	jm.mu.Unlock()
-	l.Info("Job finished")
+		atomic.AddInt32(&jm.busy, -1)
+	}
 }
 
-func (jm *Jobmanager) AddJob(orderNumber string, username string) error {
-	_, cancel := context.WithTimeout(jm.context, configuration.JOBTIMEOUT*time.Second)
-	jm.Jobs <- &Job{orderNumber: orderNumber, username: username, cancel: cancel}
-	if jm.Jobs == nil {
-		cancel()
-		return errors.ErrJobChannelClosed
+// failJob retries the job with exponential backoff, or moves it to the
+// dead-letter table once JOBMAXATTEMPTS is exhausted.
+func (jm *Jobmanager) failJob(job *Job, jobErr error, l *zap.Logger) {
+	attempt := job.attempts + 1
+	if attempt >= configuration.JOBMAXATTEMPTS {
+		l.Error("Accrual job exhausted its retries, moving to dead letters",
+			zap.String("", job.orderNumber), zap.Int("attempts", attempt), zap.Error(jobErr))
+		if err := jm.Cursor.BuryJob(jm.context, job.orderNumber, jobErr.Error(), l); err != nil {
+			l.Error("Error burying accrual job", zap.Error(err))
+		}
+		return
 	}
-	return nil
+	next := time.Now().Add(Backoff(attempt))
+	l.Warn("Accrual job failed, retrying",
+		zap.String("", job.orderNumber), zap.Int("attempts", attempt), zap.Time("next_attempt_at", next), zap.Error(jobErr))
+	if err := jm.Cursor.FailJob(jm.context, job.orderNumber, jobErr.Error(), next, l); err != nil {
+		l.Error("Error rescheduling failed accrual job", zap.Error(err))
+	}
+}
+
+// DeadJobs lists jobs that gave up after exhausting their retries.
+func (jm *Jobmanager) DeadJobs(ctx context.Context, l *zap.Logger) ([]*models.AccrualJob, error) {
+	return jm.Cursor.GetDeadJobs(ctx, l)
+}
+
+// RedriveJob returns a dead job to the queue with a fresh retry budget.
+func (jm *Jobmanager) RedriveJob(ctx context.Context, orderNumber string, l *zap.Logger) error {
+	return jm.Cursor.RedriveJob(ctx, orderNumber, l)
 }
 
 func (jm *Jobmanager) ManageJobs(ctx context.Context, accrualURL string, done chan bool, l *zap.Logger) {
 	var wg sync.WaitGroup
 
+	if err := jm.RecoverJobs(l); err != nil {
+		l.Error("Error recovering accrual jobs", zap.Error(err))
+	}
+
+	queue := make(chan *Job)
+	for i := 0; i < jm.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jm.work(queue, l)
+		}()
+	}
+
+	dispatchCtx, stopDispatch := context.WithCancel(jm.context)
+	wg.Add(1)
 	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				close(jm.Jobs)
-				done <- true
-				return
-			case job, ok := <-jm.Jobs:
-				if !ok {
-					done <- true
-					return
-				}
-				wg.Add(1)
-				go func(job *Job) {
-					defer wg.Done()
-					l.Info("Running job for order", zap.String("", job.orderNumber))
-					jm.RunJob(job, l)
-				}(job)
-			}
-		}
+		defer wg.Done()
+		jm.Webhooks.Run(dispatchCtx)
 	}()
 
-	wg.Wait()
+	stop := func() {
+		close(queue)
+		stopDispatch()
+		wg.Wait()
+		done <- true
+	}
+
+	ticker := time.NewTicker(configuration.JOBPOLLINTERVAL * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			stop()
+			return
+		case <-jm.context.Done():
+			stop()
+			return
+		case <-ticker.C:
+			jm.schedule(queue, l)
+		}
+	}
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/logger"
 	"log"
 	"net/http"
@@ -15,6 +16,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/mocks"
 	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
 )
@@ -33,10 +35,10 @@ func TestJobmanager(t *testing.T) {
 	handler := &TestHandler{
 		chi.NewMux(),
 		cursor,
-		NewJobmanager(cursor, "localhost:8081", &ctx),
+		NewJobmanager(cursor, "localhost:8081", 1, &ctx, l),
 	}
 	ts := httptest.NewServer(handler)
-	handler.Cursor.SaveUserInfo(&models.UserInfo{
+	handler.Cursor.SaveUserInfo(context.Background(), &models.UserInfo{
 		Username: "test",
 		Password: "test",
 	}, l)
@@ -94,7 +96,7 @@ func TestJobmanager(t *testing.T) {
 		}
 		defer resp.Body.Close()
 		assert.Equal(t, 200, resp.StatusCode)
-		handler.Manager.AddJob("test", order)
+		handler.Manager.AddJob(context.Background(), "test", order)
 	}
 	time.Sleep(2 * time.Second)
 	request = httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/orders", nil)
@@ -133,8 +135,185 @@ func TestJobmanager(t *testing.T) {
 
 	assert.Equal(t, "11111111", result[0].Number)
 	assert.Equal(t, "PROCESSED", result[0].Status)
-	assert.Equal(t, float64(100), result[0].Accrual)
+	assert.Equal(t, models.NewMoney(100), result[0].Accrual)
 
 	assert.Equal(t, "22222222", result[1].Number)
 	assert.Equal(t, "INVALID", result[1].Status)
 }
+
+func TestRecoverJobs(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx := context.Background()
+	manager := NewJobmanager(cursor, "http://localhost:8081", 1, &ctx, l)
+
+	orders := []*models.Order{
+		{Number: "11111111", Username: "test", Status: "NEW", UploadedAt: time.Now()},
+		{Number: "22222222", Username: "test", Status: "PROCESSING", UploadedAt: time.Now()},
+		{Number: "33333333", Username: "test", Status: "PROCESSED", UploadedAt: time.Now()},
+		{Number: "44444444", Username: "test", Status: "INVALID", UploadedAt: time.Now()},
+	}
+	for _, order := range orders {
+		cursor.SaveOrder(context.Background(), order, l)
+	}
+	assert.NoError(t, manager.AddJob(context.Background(), "11111111", "test"))
+
+	assert.NoError(t, manager.RecoverJobs(l))
+
+	claimed, err := cursor.ClaimJobs(context.Background(), 10, time.Minute, l)
+	assert.NoError(t, err)
+	numbers := []string{}
+	for _, job := range claimed {
+		numbers = append(numbers, job.Number)
+	}
+	assert.ElementsMatch(t, []string{"11111111", "22222222"}, numbers)
+
+	claimed, err = cursor.ClaimJobs(context.Background(), 10, time.Minute, l)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+func TestWorkerReschedulesPendingOrder(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order":"11111111","status":"PROCESSING"}`))
+	}))
+	defer accrual.Close()
+
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	manager := NewJobmanager(cursor, accrual.URL, 1, &ctx, l)
+	cursor.SaveOrder(context.Background(), &models.Order{Number: "11111111", Username: "test", Status: "NEW", UploadedAt: time.Now()}, l)
+	assert.NoError(t, manager.AddJob(context.Background(), "11111111", "test"))
+
+	go manager.ManageJobs(ctx, accrual.URL, done, l)
+	time.Sleep(1500 * time.Millisecond)
+	cancel()
+	<-done
+
+	order, _ := cursor.GetOrder(context.Background(), "test", "11111111", l)
+	assert.Equal(t, "PROCESSING", order.Status)
+
+	claimed, err := cursor.ClaimJobs(context.Background(), 10, time.Minute, l)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	time.Sleep(configuration.JOBRESCHEDULEDELAY * time.Second)
+	claimed, err = cursor.ClaimJobs(context.Background(), 10, time.Minute, l)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 1)
+}
+
+func TestFailingJobIsDeadLettered(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer accrual.Close()
+
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	manager := NewJobmanager(cursor, accrual.URL, 1, &ctx, l)
+	cursor.SaveOrder(context.Background(), &models.Order{Number: "11111111", Username: "test", Status: "NEW", UploadedAt: time.Now()}, l)
+	assert.NoError(t, manager.AddJob(context.Background(), "11111111", "test"))
+	for i := 0; i < configuration.JOBMAXATTEMPTS-1; i++ {
+		cursor.FailJob(context.Background(), "11111111", "previous failure", time.Now(), l)
+	}
+
+	go manager.ManageJobs(ctx, accrual.URL, done, l)
+	time.Sleep(1500 * time.Millisecond)
+	cancel()
+	<-done
+
+	dead, err := manager.DeadJobs(context.Background(), l)
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, "11111111", dead[0].Number)
+	assert.Equal(t, configuration.JOBMAXATTEMPTS, dead[0].Attempts)
+	assert.Equal(t, errors.ErrAccrualResponse.Error(), dead[0].LastError)
+
+	assert.NoError(t, cursor.EnqueueJob(context.Background(), &models.AccrualJob{Number: "11111111", Username: "test", CreatedAt: time.Now()}, l))
+	claimed, _ := cursor.ClaimJobs(context.Background(), 10, time.Minute, l)
+	assert.Empty(t, claimed)
+
+	assert.NoError(t, manager.RedriveJob(context.Background(), "11111111", l))
+	assert.ErrorIs(t, manager.RedriveJob(context.Background(), "11111111", l), errors.ErrNotFound)
+	claimed, _ = cursor.ClaimJobs(context.Background(), 10, time.Minute, l)
+	assert.Len(t, claimed, 1)
+	assert.Equal(t, 0, claimed[0].Attempts)
+}
+
+func TestProcessedOrderIsCreditedOnce(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order":"11111111","status":"PROCESSED","accrual":100}`))
+	}))
+	defer accrual.Close()
+
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	manager := NewJobmanager(cursor, accrual.URL, 1, &ctx, l)
+	cursor.SaveOrder(context.Background(), &models.Order{Number: "11111111", Username: "test", Status: "NEW", UploadedAt: time.Now()}, l)
+	assert.NoError(t, manager.AddJob(context.Background(), "11111111", "test"))
+
+	go manager.ManageJobs(ctx, accrual.URL, done, l)
+	time.Sleep(1500 * time.Millisecond)
+	cancel()
+	<-done
+
+	balance, err := cursor.AppendLedgerEntry(context.Background(), &models.LedgerEntry{
+		User:   "test",
+		Kind:   configuration.CREDIT,
+		Amount: models.NewMoney(100),
+		Order:  "11111111",
+	}, l)
+	assert.NoError(t, err)
+	assert.Equal(t, models.NewMoney(100), balance.Current)
+
+	entries, err := cursor.GetLedger(context.Background(), "test", l)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "11111111", entries[0].Order)
+}
+
+func TestRunJobRecordsOrderEvents(t *testing.T) {
+	l, _ := logger.InitializeLogger("info")
+	ctx := context.Background()
+	response := &models.AccrualResponse{}
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(response)
+	}))
+	defer ts.Close()
+
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	jm := NewJobmanager(cursor, ts.URL, 1, &ctx, l)
+	cursor.SaveOrder(ctx, &models.Order{Number: "9278923470", Username: "test", Status: configuration.NEW, UploadedAt: time.Now()}, l)
+
+	run := func() []string {
+		jobCtx, cancel := context.WithCancel(ctx)
+		_, err := jm.RunJob(&Job{orderNumber: "9278923470", username: "test", ctx: jobCtx, cancel: cancel}, l)
+		assert.NoError(t, err)
+		claimed, err := cursor.ClaimOutbox(ctx, 10, time.Minute, l)
+		assert.NoError(t, err)
+		types := []string{}
+		for _, event := range claimed {
+			assert.Equal(t, "test", event.User)
+			types = append(types, event.Type)
+			cursor.DeleteOutboxEvent(ctx, event.ID, l)
+		}
+		return types
+	}
+
+	response = &models.AccrualResponse{Order: "9278923470", Status: configuration.REGISTERED}
+	assert.Equal(t, []string{configuration.ORDERUPDATED}, run())
+	assert.Equal(t, []string{}, run())
+
+	response = &models.AccrualResponse{Order: "9278923470", Status: configuration.PROCESSED, Accrual: models.NewMoney(500)}
+	assert.Equal(t, []string{configuration.ORDERUPDATED, configuration.ORDERPROCESSED, configuration.BALANCEUPDATED}, run())
+}
@@ -0,0 +1,130 @@
+package jobmanager
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+)
+
+var requestLimitPattern = regexp.MustCompile(`No more than (\d+) requests per minute`)
+
+// RateLimiter is shared by all workers of a Jobmanager. After the accrual
+// system answers 429 every worker is paused for the advertised window, and
+// afterwards requests are spaced out to match the advertised rate until
+// ACCRUALRECOVERSUCCESSES of them succeed in a row.
+type RateLimiter struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	next        time.Time
+	pausedUntil time.Time
+	successes   int
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{}
+}
+
+// Wait blocks until the caller is allowed to send the next request.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		delay := rl.reserve()
+		if delay <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (rl *RateLimiter) reserve() time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	if now.Before(rl.pausedUntil) {
+		return rl.pausedUntil.Sub(now)
+	}
+	if now.Before(rl.next) {
+		return rl.next.Sub(now)
+	}
+	rl.next = now.Add(rl.interval)
+	return 0
+}
+
+// Throttle pauses all callers for retryAfter and limits the request rate to
+// perMinute afterwards. A non-positive perMinute keeps the current rate.
+func (rl *RateLimiter) Throttle(retryAfter time.Duration, perMinute int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	until := time.Now().Add(retryAfter)
+	if until.After(rl.pausedUntil) {
+		rl.pausedUntil = until
+	}
+	if perMinute > 0 {
+		rl.interval = time.Minute / time.Duration(perMinute)
+	}
+	rl.successes = 0
+}
+
+// Succeeded records a request the accrual system answered. Once enough of
+// them in a row get through after a pause, requests are no longer spaced out.
+func (rl *RateLimiter) Succeeded() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.interval == 0 || time.Now().Before(rl.pausedUntil) {
+		return
+	}
+	rl.successes++
+	if rl.successes >= configuration.ACCRUALRECOVERSUCCESSES {
+		rl.interval = 0
+		rl.next = time.Time{}
+		rl.successes = 0
+	}
+}
+
+// Paused reports whether the limiter is inside a back-off window.
+func (rl *RateLimiter) Paused() bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return time.Now().Before(rl.pausedUntil)
+}
+
+// ParseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date. Missing or malformed values fall back to the default window.
+func ParseRetryAfter(header string) time.Duration {
+	header = strings.TrimSpace(header)
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+		return 0
+	}
+	return configuration.ACCRUALRETRYAFTER * time.Second
+}
+
+// ParseRequestLimit extracts N from "No more than N requests per minute
+// allowed". It returns 0 when the body does not advertise a limit.
+func ParseRequestLimit(body string) int {
+	match := requestLimitPattern.FindStringSubmatch(body)
+	if match == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0
+	}
+	return n
+}
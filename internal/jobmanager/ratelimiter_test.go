@@ -0,0 +1,99 @@
+package jobmanager
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{
+			name:   "Test seconds",
+			header: "60",
+			want:   60 * time.Second,
+		},
+		{
+			name:   "Test missing header",
+			header: "",
+			want:   60 * time.Second,
+		},
+		{
+			name:   "Test date in the past",
+			header: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat),
+			want:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseRetryAfter(tt.header))
+		})
+	}
+
+	delay := ParseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.InDelta(t, float64(time.Minute), float64(delay), float64(2*time.Second))
+}
+
+func TestParseRequestLimit(t *testing.T) {
+	assert.Equal(t, 100, ParseRequestLimit("No more than 100 requests per minute allowed"))
+	assert.Equal(t, 0, ParseRequestLimit("Too Many Requests"))
+}
+
+func TestRateLimiterThrottle(t *testing.T) {
+	limiter := NewRateLimiter()
+	ctx := context.Background()
+
+	start := time.Now()
+	assert.NoError(t, limiter.Wait(ctx))
+	assert.NoError(t, limiter.Wait(ctx))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	limiter.Throttle(200*time.Millisecond, 600)
+	assert.True(t, limiter.Paused())
+
+	start = time.Now()
+	assert.NoError(t, limiter.Wait(ctx))
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	assert.False(t, limiter.Paused())
+
+	start = time.Now()
+	assert.NoError(t, limiter.Wait(ctx))
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	limiter.Throttle(time.Minute, 0)
+	assert.ErrorIs(t, limiter.Wait(canceled), context.Canceled)
+}
+
+func TestRateLimiterRecovers(t *testing.T) {
+	limiter := NewRateLimiter()
+	ctx := context.Background()
+
+	limiter.Throttle(50*time.Millisecond, 600)
+	// answers to requests sent before the pause ended do not count
+	limiter.Succeeded()
+	assert.NoError(t, limiter.Wait(ctx))
+	for i := 1; i < configuration.ACCRUALRECOVERSUCCESSES; i++ {
+		limiter.Succeeded()
+	}
+	start := time.Now()
+	assert.NoError(t, limiter.Wait(ctx))
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	// the advertised rate is dropped after a run of successes
+	limiter.Succeeded()
+	start = time.Now()
+	assert.NoError(t, limiter.Wait(ctx))
+	assert.NoError(t, limiter.Wait(ctx))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
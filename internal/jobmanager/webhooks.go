@@ -0,0 +1,214 @@
+package jobmanager
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+// WebhookDispatcher records events for the users' webhooks and delivers them
+// in the background. Every attempt ends up in the delivery log, failed ones
+// are retried with Backoff until WEBHOOKMAXATTEMPTS is reached.
+type WebhookDispatcher struct {
+	Cursor *db.Cursor
+	client *resty.Client
+	logger *zap.Logger
+}
+
+func NewWebhookDispatcher(cursor *db.Cursor, l *zap.Logger) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		Cursor: cursor,
+		client: newWebhookClient(PublicAddr),
+		logger: l,
+	}
+}
+
+// sharedAddrs are the special purpose blocks that the netip checks leave out:
+// "this network" and carrier-grade NAT.
+var sharedAddrs = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// PublicAddr reports whether webhooks may be delivered to addr. Loopback,
+// private, link-local and other addresses inside the network of the service
+// are refused, so a webhook cannot be used to reach into it.
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range sharedAddrs {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckWebhookHost resolves host and refuses it with ErrForbiddenAddress
+// unless all of its addresses are public.
+func CheckWebhookHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !PublicAddr(addr) {
+			return errors.ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// newWebhookClient makes a client that connects only to the addresses allow
+// accepts. The address is checked when connecting, after the host has been
+// resolved, so a host that resolves elsewhere on delivery than on
+// registration is refused too. Redirects are not followed.
+func newWebhookClient(allow func(netip.Addr) bool) *resty.Client {
+	dialer := &net.Dialer{
+		Timeout: configuration.WEBHOOKTIMEOUT * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !allow(addrPort.Addr()) {
+				return errors.ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+	return resty.New().
+		SetTransport(&http.Transport{DialContext: dialer.DialContext, ForceAttemptHTTP2: true}).
+		SetTimeout(configuration.WEBHOOKTIMEOUT * time.Second).
+		SetRedirectPolicy(resty.NoRedirectPolicy())
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "timestamp.payload" keyed with
+// the webhook secret. Receivers recompute it to check the
+// X-Gophermart-Signature header.
+func SignWebhook(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HandleEvent schedules order results and withdrawals for every webhook of
+// the user. The outbox event id is the event id, so receivers can drop the
+// duplicates of a relayed event.
+func (d *WebhookDispatcher) HandleEvent(ctx context.Context, e *models.OutboxEvent) error {
+	switch e.Type {
+	case configuration.ORDERPROCESSED, configuration.ORDERINVALID, configuration.WITHDRAWALCREATED:
+	default:
+		return nil
+	}
+	event := &models.WebhookEvent{
+		ID:        strconv.FormatInt(e.ID, 10),
+		Type:      e.Type,
+		CreatedAt: e.CreatedAt,
+		Data:      json.RawMessage(e.Payload),
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return d.Cursor.EnqueueDeliveries(ctx, e.User, event, payload, d.logger)
+}
+
+// Run delivers due events every WEBHOOKPOLLINTERVAL seconds until ctx ends.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(configuration.WEBHOOKPOLLINTERVAL * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.Dispatch(ctx)
+		}
+	}
+}
+
+// Dispatch claims a batch of due deliveries and sends them concurrently.
+func (d *WebhookDispatcher) Dispatch(ctx context.Context) {
+	claimed, err := d.Cursor.ClaimDeliveries(ctx, configuration.WEBHOOKBATCH, configuration.WEBHOOKLEASE*time.Second, d.logger)
+	if err != nil {
+		d.logger.Error("Error claiming webhook deliveries", zap.Error(err))
+		return
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range claimed {
+		wg.Add(1)
+		go func(delivery *models.WebhookDelivery) {
+			defer wg.Done()
+			d.deliver(ctx, delivery)
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	timestamp := time.Now().Unix()
+	payload := []byte(delivery.Payload)
+	resp, err := d.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader("X-Gophermart-Event", delivery.EventType).
+		SetHeader("X-Gophermart-Delivery", delivery.EventID).
+		SetHeader("X-Gophermart-Timestamp", strconv.FormatInt(timestamp, 10)).
+		SetHeader("X-Gophermart-Signature", "sha256="+SignWebhook(delivery.Secret, timestamp, payload)).
+		SetBody(payload).
+		Post(delivery.URL)
+
+	delivery.Attempts++
+	delivery.ResponseCode, delivery.LastError = 0, ""
+	switch {
+	case err != nil:
+		delivery.LastError = err.Error()
+	case resp.IsSuccess():
+		now := time.Now()
+		delivery.ResponseCode = resp.StatusCode()
+		delivery.Status = configuration.DELIVERED
+		delivery.DeliveredAt = &now
+	default:
+		delivery.ResponseCode = resp.StatusCode()
+		delivery.LastError = fmt.Sprintf("unexpected response status %d", resp.StatusCode())
+	}
+	delivery.NextAttemptAt = time.Now()
+	if delivery.Status != configuration.DELIVERED {
+		if delivery.Attempts >= configuration.WEBHOOKMAXATTEMPTS {
+			d.logger.Error("Webhook delivery exhausted its retries",
+				zap.Int64("delivery", delivery.ID), zap.String("url", delivery.URL), zap.String("error", delivery.LastError))
+			delivery.Status = configuration.DELIVERYFAILED
+		} else {
+			delivery.NextAttemptAt = time.Now().Add(Backoff(delivery.Attempts))
+			d.logger.Warn("Webhook delivery failed, retrying",
+				zap.Int64("delivery", delivery.ID), zap.Time("next_attempt_at", delivery.NextAttemptAt), zap.String("error", delivery.LastError))
+		}
+	}
+	// the outcome is stored even if the dispatcher is shutting down
+	if err := d.Cursor.SaveDeliveryAttempt(context.WithoutCancel(ctx), delivery, d.logger); err != nil {
+		d.logger.Error("Error saving webhook delivery attempt", zap.Error(err))
+	}
+}
@@ -0,0 +1,180 @@
+package jobmanager
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/mocks"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWebhookDispatcher(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	status := http.StatusInternalServerError
+	received := make(chan *models.WebhookEvent, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get("X-Gophermart-Timestamp"), 10, 64)
+		assert.Equal(t, "sha256="+SignWebhook("secret", timestamp, body), r.Header.Get("X-Gophermart-Signature"))
+		assert.Equal(t, configuration.ORDERPROCESSED, r.Header.Get("X-Gophermart-Event"))
+		if status == http.StatusOK {
+			event := &models.WebhookEvent{}
+			json.Unmarshal(body, event)
+			received <- event
+		}
+		rw.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	d := NewWebhookDispatcher(cursor, l)
+	// the test server listens on loopback
+	d.client = newWebhookClient(func(netip.Addr) bool { return true })
+	webhook := &models.Webhook{User: "test", URL: ts.URL, Secret: "secret", CreatedAt: time.Now()}
+	require.NoError(t, cursor.SaveWebhook(ctx, webhook, l))
+	require.NoError(t, d.HandleEvent(ctx, &models.OutboxEvent{ID: 1, User: "test", Type: configuration.ORDERPROCESSED, Payload: `{"number":"9278923470"}`}))
+	require.NoError(t, d.HandleEvent(ctx, &models.OutboxEvent{ID: 2, User: "other", Type: configuration.ORDERPROCESSED, Payload: `{"number":"346436439"}`}))
+	require.NoError(t, d.HandleEvent(ctx, &models.OutboxEvent{ID: 3, User: "test", Type: configuration.BALANCEUPDATED, Payload: `{"current":500}`}))
+
+	d.Dispatch(ctx)
+	deliveries, err := cursor.GetDeliveries(ctx, "test", webhook.ID, l)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, configuration.DELIVERYPENDING, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusInternalServerError, deliveries[0].ResponseCode)
+
+	// the retry is not due yet
+	d.Dispatch(ctx)
+	deliveries, _ = cursor.GetDeliveries(ctx, "test", webhook.ID, l)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+
+	status = http.StatusOK
+	claimed, err := cursor.ClaimDeliveries(ctx, 10, time.Minute, l)
+	require.NoError(t, err)
+	assert.Len(t, claimed, 0)
+	delivery := deliveries[0]
+	delivery.NextAttemptAt = time.Now()
+	require.NoError(t, cursor.SaveDeliveryAttempt(ctx, delivery, l))
+	d.Dispatch(ctx)
+
+	event := <-received
+	assert.Equal(t, "1", event.ID)
+	assert.Equal(t, configuration.ORDERPROCESSED, event.Type)
+	assert.Equal(t, "9278923470", event.Data.(map[string]interface{})["number"])
+	deliveries, _ = cursor.GetDeliveries(ctx, "test", webhook.ID, l)
+	assert.Equal(t, configuration.DELIVERED, deliveries[0].Status)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+	assert.NotNil(t, deliveries[0].DeliveredAt)
+}
+
+func TestWebhookDeliveryGivesUp(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	d := NewWebhookDispatcher(cursor, l)
+	webhook := &models.Webhook{User: "test", URL: "http://127.0.0.1:1", Secret: "secret", CreatedAt: time.Now()}
+	require.NoError(t, cursor.SaveWebhook(ctx, webhook, l))
+	require.NoError(t, d.HandleEvent(ctx, &models.OutboxEvent{ID: 1, User: "test", Type: configuration.WITHDRAWALCREATED, Payload: `{"order":"2377225624"}`}))
+
+	for attempt := 1; attempt <= configuration.WEBHOOKMAXATTEMPTS; attempt++ {
+		claimed, err := cursor.ClaimDeliveries(ctx, 1, time.Minute, l)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+		d.deliver(ctx, claimed[0])
+		claimed[0].NextAttemptAt = time.Now()
+		if claimed[0].Status == configuration.DELIVERYPENDING {
+			require.NoError(t, cursor.SaveDeliveryAttempt(ctx, claimed[0], l))
+		}
+	}
+	deliveries, err := cursor.GetDeliveries(ctx, "test", webhook.ID, l)
+	require.NoError(t, err)
+	assert.Equal(t, configuration.DELIVERYFAILED, deliveries[0].Status)
+	assert.Equal(t, configuration.WEBHOOKMAXATTEMPTS, deliveries[0].Attempts)
+	assert.NotEmpty(t, deliveries[0].LastError)
+}
+
+func TestPublicAddr(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"203.0.113.7":      true,
+		"2001:db8::1":      true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"0.0.0.0":          false,
+		"100.64.0.1":       false,
+		"::ffff:127.0.0.1": false,
+		"224.0.0.1":        false,
+	} {
+		assert.Equal(t, public, PublicAddr(netip.MustParseAddr(addr)), addr)
+	}
+}
+
+func TestWebhookDeliveryRefusesPrivateAddresses(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer ts.Close()
+
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	d := NewWebhookDispatcher(cursor, l)
+	webhook := &models.Webhook{User: "test", URL: ts.URL, Secret: "secret", CreatedAt: time.Now()}
+	require.NoError(t, cursor.SaveWebhook(ctx, webhook, l))
+	require.NoError(t, d.HandleEvent(ctx, &models.OutboxEvent{ID: 1, User: "test", Type: configuration.WITHDRAWALCREATED, Payload: `{"order":"2377225624"}`}))
+
+	d.Dispatch(ctx)
+	assert.Equal(t, 0, hits)
+	deliveries, err := cursor.GetDeliveries(ctx, "test", webhook.ID, l)
+	require.NoError(t, err)
+	assert.Equal(t, configuration.DELIVERYPENDING, deliveries[0].Status)
+	assert.Zero(t, deliveries[0].ResponseCode)
+	assert.Contains(t, deliveries[0].LastError, errors.ErrForbiddenAddress.Error())
+}
+
+func TestWebhookDeliveryDoesNotFollowRedirects(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	hits := 0
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer target.Close()
+	ts := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer ts.Close()
+
+	cursor := &db.Cursor{IDBInterface: mocks.NewMock()}
+	d := NewWebhookDispatcher(cursor, l)
+	d.client = newWebhookClient(func(netip.Addr) bool { return true })
+	webhook := &models.Webhook{User: "test", URL: ts.URL, Secret: "secret", CreatedAt: time.Now()}
+	require.NoError(t, cursor.SaveWebhook(ctx, webhook, l))
+	require.NoError(t, d.HandleEvent(ctx, &models.OutboxEvent{ID: 1, User: "test", Type: configuration.WITHDRAWALCREATED, Payload: `{"order":"2377225624"}`}))
+
+	d.Dispatch(ctx)
+	assert.Equal(t, 0, hits)
+	deliveries, err := cursor.GetDeliveries(ctx, "test", webhook.ID, l)
+	require.NoError(t, err)
+	assert.Equal(t, configuration.DELIVERYPENDING, deliveries[0].Status)
+}
@@ -1,46 +1,186 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type UserInfo struct {
 	Username string `json:"login"`
 	Password string `json:"password"`
+	Role     string `json:"-"`
 }
 
+// Session is a login of a user on one device. Token authenticates requests
+// until ExpiresAt, the refresh token renews it until RefreshExpiresAt. Only
+// the hash of the refresh token is stored. Role is the current role of the
+// user.
 type Session struct {
-	Username  string
-	ExpiresAt time.Time
-	Token     string
+	ID               int64     `json:"id"`
+	Username         string    `json:"-"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	Token            string    `json:"-"`
+	RefreshToken     string    `json:"-"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	CreatedAt        time.Time `json:"created_at"`
+	UserAgent        string    `json:"user_agent"`
+	IP               string    `json:"ip"`
+	Current          bool      `json:"current"`
+	Role             string    `json:"-"`
 }
 
 type Order struct {
 	Number     string    `json:"number"`
 	Username   string    `json:"-"`
 	Status     string    `json:"status"`
-	Accrual    float64   `json:"accrual,omitempty"`
+	Accrual    Money     `json:"accrual,omitempty"`
 	UploadedAt time.Time `json:"uploaded_at"`
 }
 
 type Balance struct {
-	User      string  `json:"-"`
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	User      string `json:"-"`
+	Current   Money  `json:"current"`
+	Withdrawn Money  `json:"withdrawn"`
+}
+
+// LedgerEntry is a single balance movement. Amount is signed: credits are
+// positive, debits are negative, adjustments may be either.
+type LedgerEntry struct {
+	ID        int64     `json:"id"`
+	User      string    `json:"-"`
+	Kind      string    `json:"kind"`
+	Amount    Money     `json:"amount"`
+	Order     string    `json:"order,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type WithdrawalPost struct {
 	Order string
-	Sum   float64
+	Sum   Money
+}
+
+// LoginAttempts counts the recent failed logins to an account or from an
+// address. Logins are refused until LockedUntil.
+type LoginAttempts struct {
+	Scope         string    `json:"scope"`
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+// AdjustmentPost is a manual balance change. Negative amounts take points
+// back.
+type AdjustmentPost struct {
+	Amount     Money  `json:"amount"`
+	ReasonCode string `json:"reason_code"`
+	Reason     string `json:"reason"`
+}
+
+// Adjustment is a manual balance change made by staff. Large ones wait in
+// PENDING until another admin approves or rejects them.
+type Adjustment struct {
+	ID          int64      `json:"id"`
+	User        string     `json:"user"`
+	Amount      Money      `json:"amount"`
+	ReasonCode  string     `json:"reason_code"`
+	Reason      string     `json:"reason"`
+	Status      string     `json:"status"`
+	RequestedBy string     `json:"requested_by"`
+	ReviewedBy  string     `json:"reviewed_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+}
+
+type RolePost struct {
+	Role string `json:"role"`
 }
 
 type Withdrawal struct {
 	User        string    `json:"-"`
 	Order       string    `json:"order"`
-	Sum         float64   `json:"sum"`
+	Sum         Money     `json:"sum"`
 	ProcessedAt time.Time `json:"processed_at"`
 }
 
 type AccrualResponse struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual,omitempty"`
+	Order   string `json:"order"`
+	Status  string `json:"status"`
+	Accrual Money  `json:"accrual,omitempty"`
+}
+
+type AccrualJob struct {
+	Number        string    `json:"number"`
+	Username      string    `json:"user"`
+	CreatedAt     time.Time `json:"created_at"`
+	LockedUntil   time.Time `json:"-"`
+	NextAttemptAt time.Time `json:"-"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	FailedAt      time.Time `json:"failed_at"`
+}
+
+// Webhook is a URL the user wants to be notified at. Secret signs the
+// payloads and is only shown when the webhook is created.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	User      string    `json:"-"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookEvent is the body sent to webhooks.
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// WebhookDelivery is one event sent to one webhook together with the outcome
+// of its last attempt.
+type WebhookDelivery struct {
+	ID            int64      `json:"id"`
+	WebhookID     int64      `json:"webhook_id"`
+	URL           string     `json:"url"`
+	Secret        string     `json:"-"`
+	EventID       string     `json:"event_id"`
+	EventType     string     `json:"event_type"`
+	Payload       string     `json:"-"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	ResponseCode  int        `json:"response_code,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	NextAttemptAt time.Time  `json:"-"`
+	LockedUntil   time.Time  `json:"-"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
+
+// OutboxEvent is a domain event stored in the same transaction as the change
+// it describes. Payload is the JSON of the changed entity.
+type OutboxEvent struct {
+	ID          int64
+	User        string
+	Type        string
+	Payload     string
+	CreatedAt   time.Time
+	LockedUntil time.Time
+}
+
+// AuditEntry records a security or financial action: who took it, from
+// where, on whose account and the state before and after it. Entries are
+// never changed once written.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Subject   string          `json:"subject,omitempty"`
+	IP        string          `json:"ip,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
 }
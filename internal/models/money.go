@@ -0,0 +1,139 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+)
+
+// Money is an amount of loyalty points kept in hundredths of a point, so
+// sums and differences are exact. In JSON it is a plain number like 500.5.
+type Money int64
+
+const moneyScale = 100
+
+// NewMoney converts a float amount rounding it to the nearest hundredth.
+func NewMoney(amount float64) Money {
+	return Money(math.Round(amount * moneyScale))
+}
+
+// ParseMoney parses a decimal string such as "729.98" without going through
+// float64. Digits beyond hundredths are rounded half away from zero, amounts
+// that do not fit are a validation error.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, err
+		}
+		if math.Abs(math.Round(f*moneyScale)) >= math.MaxInt64 {
+			return 0, fmt.Errorf("money amount %q out of range: %w", s, errors.ErrValidation)
+		}
+		return NewMoney(f), nil
+	}
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+	whole, frac, _ := strings.Cut(s, ".")
+	if (whole == "" && frac == "") || strings.Trim(whole+frac, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid money amount %q", s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	// only digits are left, so parsing fails on the range alone
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("money amount %q out of range: %w", s, errors.ErrValidation)
+	}
+	for len(frac) < 3 {
+		frac += "0"
+	}
+	cents, _ := strconv.ParseInt(frac[:3], 10, 64)
+	cents = (cents + 5) / 10
+	if units > (math.MaxInt64-cents)/moneyScale {
+		return 0, fmt.Errorf("money amount %q out of range: %w", s, errors.ErrValidation)
+	}
+	m := Money(units*moneyScale + cents)
+	if negative {
+		m = -m
+	}
+	return m, nil
+}
+
+func (m Money) Float64() float64 {
+	return float64(m) / moneyScale
+}
+
+// String formats the amount with at most two decimals and no trailing zeros.
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	units, cents := v/moneyScale, v%moneyScale
+	switch {
+	case cents == 0:
+		return fmt.Sprintf("%s%d", sign, units)
+	case cents%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, units, cents/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, units, cents)
+	}
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" {
+		return nil
+	}
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads NUMERIC columns, which drivers hand over as text, as well as
+// plain integer and float columns.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v * moneyScale)
+	case float64:
+		*m = NewMoney(v)
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
+
+func (m *Money) scanString(s string) error {
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Money
+	}{
+		{input: "500.5", want: 50050},
+		{input: "729.98", want: 72998},
+		{input: "42", want: 4200},
+		{input: "-0.01", want: -1},
+		{input: ".5", want: 50},
+		{input: "1.005", want: 101},
+		{input: "1.004", want: 100},
+		{input: "5e2", want: 50000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseMoney(tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, input := range []string{"", "abc", "1.-5", "1..2", "-"} {
+		_, err := ParseMoney(input)
+		assert.Error(t, err, input)
+	}
+
+	// the largest amount fits, anything above is refused instead of wrapping
+	got, err := ParseMoney("92233720368547758.07")
+	assert.NoError(t, err)
+	assert.Equal(t, Money(math.MaxInt64), got)
+	for _, input := range []string{"99999999999999999999.99", "92233720368547758.08", "-92233720368547758.08", "92233720368547758.075", "1e30"} {
+		_, err := ParseMoney(input)
+		assert.ErrorIs(t, err, errors.ErrValidation, input)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	assert.Equal(t, "500.5", Money(50050).String())
+	assert.Equal(t, "729.98", Money(72998).String())
+	assert.Equal(t, "42", Money(4200).String())
+	assert.Equal(t, "0.05", Money(5).String())
+	assert.Equal(t, "-0.01", Money(-1).String())
+	assert.Equal(t, "0", Money(0).String())
+}
+
+func TestMoneyJSON(t *testing.T) {
+	balance := &Balance{Current: NewMoney(500.5), Withdrawn: NewMoney(42)}
+	data, err := json.Marshal(balance)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"current":500.5,"withdrawn":42}`, string(data))
+
+	order := &AccrualResponse{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"order":"1","status":"PROCESSED","accrual":729.98}`), order))
+	assert.Equal(t, Money(72998), order.Accrual)
+
+	sum := Money(0)
+	for i := 0; i < 1000; i++ {
+		sum += NewMoney(0.1)
+	}
+	assert.Equal(t, NewMoney(100), sum)
+}
+
+func TestMoneyScan(t *testing.T) {
+	var m Money
+	assert.NoError(t, m.Scan("729.98"))
+	assert.Equal(t, Money(72998), m)
+	assert.NoError(t, m.Scan([]byte("0.10")))
+	assert.Equal(t, Money(10), m)
+	assert.NoError(t, m.Scan(int64(3)))
+	assert.Equal(t, Money(300), m)
+	assert.NoError(t, m.Scan(float64(500.5)))
+	assert.Equal(t, Money(50050), m)
+	assert.NoError(t, m.Scan(nil))
+	assert.Equal(t, Money(0), m)
+}
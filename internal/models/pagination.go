@@ -0,0 +1,72 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PageCursor points to the last item of a page. Items are ordered by Time
+// and, for equal times, by Key, so the pair identifies a position exactly.
+type PageCursor struct {
+	Time time.Time
+	Key  string
+}
+
+// Encode turns the cursor into an opaque URL-safe token.
+func (c *PageCursor) Encode() string {
+	raw := c.Time.Format(time.RFC3339Nano) + "|" + c.Key
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodePageCursor parses a token produced by Encode.
+func DecodePageCursor(token string) (*PageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q", token)
+	}
+	at, key, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, fmt.Errorf("invalid cursor %q", token)
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q", token)
+	}
+	return &PageCursor{Time: parsed, Key: key}, nil
+}
+
+// OrderQuery selects a page of a user's orders. Orders are sorted by upload
+// time, newest first unless Ascending is set, and by number for equal times.
+// From is inclusive, To is exclusive, zero values and a zero Limit mean no
+// bound.
+type OrderQuery struct {
+	Statuses  []string
+	From      time.Time
+	To        time.Time
+	Ascending bool
+	After     *PageCursor
+	Limit     int
+}
+
+// WithdrawalQuery selects a page of a user's withdrawals, newest first and by
+// order number for equal times. Bounds follow OrderQuery.
+type WithdrawalQuery struct {
+	From  time.Time
+	To    time.Time
+	After *PageCursor
+	Limit int
+}
+
+// AuditQuery selects a page of the audit log, newest first. Empty fields do
+// not filter, bounds follow OrderQuery. The cursor key is the entry ID.
+type AuditQuery struct {
+	Actor   string
+	Subject string
+	Action  string
+	From    time.Time
+	To      time.Time
+	After   *PageCursor
+	Limit   int
+}
@@ -0,0 +1,93 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+)
+
+// Sink receives the outbox events. It may see an event more than once and
+// should ignore the types it does not handle.
+type Sink interface {
+	HandleEvent(context.Context, *models.OutboxEvent) error
+}
+
+// Broadcast is the sink of the live updates. The relay of one instance
+// handles an event, so it is broadcast through the storage to every instance
+// and each publishes it to its own subscribers with Listen.
+type Broadcast struct {
+	Cursor *db.Cursor
+	logger *zap.Logger
+}
+
+func NewBroadcast(cursor *db.Cursor, l *zap.Logger) *Broadcast {
+	return &Broadcast{Cursor: cursor, logger: l}
+}
+
+func (b *Broadcast) HandleEvent(ctx context.Context, e *models.OutboxEvent) error {
+	return b.Cursor.BroadcastEvent(ctx, e, b.logger)
+}
+
+// Listen hands the events broadcast by any instance to sink until ctx ends.
+func (b *Broadcast) Listen(ctx context.Context, sink Sink) error {
+	return b.Cursor.ListenEvents(ctx, sink.HandleEvent, b.logger)
+}
+
+// Relay moves the events recorded in the outbox to the sinks. An event is
+// removed only after every sink accepted it, so delivery is at-least-once.
+type Relay struct {
+	Cursor *db.Cursor
+	Sinks  []Sink
+	logger *zap.Logger
+}
+
+func NewRelay(cursor *db.Cursor, l *zap.Logger, sinks ...Sink) *Relay {
+	return &Relay{
+		Cursor: cursor,
+		Sinks:  sinks,
+		logger: l,
+	}
+}
+
+// Run dispatches the outbox every OUTBOXPOLLINTERVAL seconds until ctx ends.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(configuration.OUTBOXPOLLINTERVAL * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Dispatch(ctx)
+		}
+	}
+}
+
+// Dispatch hands a batch of events to the sinks in the order they were
+// recorded. It stops at the first failure, the rest of the batch is retried
+// with it once the lease runs out.
+func (r *Relay) Dispatch(ctx context.Context) {
+	claimed, err := r.Cursor.ClaimOutbox(ctx, configuration.OUTBOXBATCH, configuration.OUTBOXLEASE*time.Second, r.logger)
+	if err != nil {
+		r.logger.Error("Error claiming outbox events", zap.Error(err))
+		return
+	}
+	for _, event := range claimed {
+		for _, sink := range r.Sinks {
+			if err := sink.HandleEvent(ctx, event); err != nil {
+				r.logger.Error("Error relaying outbox event",
+					zap.Int64("event", event.ID), zap.String("type", event.Type), zap.Error(err))
+				return
+			}
+		}
+		if err := r.Cursor.DeleteOutboxEvent(ctx, event.ID, r.logger); err != nil {
+			r.logger.Error("Error deleting relayed outbox event", zap.Error(err))
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/db"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type recordingSink struct {
+	fail   bool
+	events []*models.OutboxEvent
+}
+
+func (s *recordingSink) HandleEvent(ctx context.Context, e *models.OutboxEvent) error {
+	if s.fail {
+		return errors.New("sink is down")
+	}
+	s.events = append(s.events, e)
+	return nil
+}
+
+// noLease lets the test retry claimed events without waiting for the lease.
+type noLease struct {
+	db.IDBInterface
+}
+
+func (n noLease) ClaimOutbox(ctx context.Context, limit int, lease time.Duration, logger *zap.Logger) ([]*models.OutboxEvent, error) {
+	return n.IDBInterface.ClaimOutbox(ctx, limit, 0, logger)
+}
+
+func TestRelayDispatch(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	cursor := &db.Cursor{IDBInterface: noLease{db.NewMemoryCursor()}}
+	require.NoError(t, cursor.SaveOrder(ctx, &models.Order{Username: "test", Number: "9278923470", Status: configuration.NEW, UploadedAt: time.Now()}, l))
+	require.NoError(t, cursor.UpdateOrder(ctx, "test", &models.AccrualResponse{Order: "9278923470", Status: configuration.INVALID}, l))
+
+	working := &recordingSink{}
+	failing := &recordingSink{fail: true}
+	NewRelay(cursor, l, working, failing).Dispatch(ctx)
+	require.Len(t, working.events, 1)
+	assert.Equal(t, configuration.ORDERUPDATED, working.events[0].Type)
+
+	// the failed event stays in the outbox and is retried
+	NewRelay(cursor, l, working).Dispatch(ctx)
+	types := []string{}
+	for _, e := range working.events {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []string{configuration.ORDERUPDATED, configuration.ORDERUPDATED, configuration.ORDERINVALID}, types)
+	assert.Equal(t, working.events[0].ID, working.events[1].ID)
+
+	claimed, err := cursor.ClaimOutbox(ctx, 10, time.Minute, l)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+// broadcasts records the events broadcast through the storage.
+type broadcasts struct {
+	db.IDBInterface
+	events []*models.OutboxEvent
+}
+
+func (b *broadcasts) BroadcastEvent(ctx context.Context, event *models.OutboxEvent, logger *zap.Logger) error {
+	b.events = append(b.events, event)
+	return nil
+}
+
+func TestRelayBroadcast(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	storage := &broadcasts{IDBInterface: db.NewMemoryCursor()}
+	cursor := &db.Cursor{IDBInterface: storage}
+	require.NoError(t, cursor.SaveOrder(ctx, &models.Order{Username: "test", Number: "9278923470", Status: configuration.NEW, UploadedAt: time.Now()}, l))
+	require.NoError(t, cursor.UpdateOrder(ctx, "test", &models.AccrualResponse{Order: "9278923470", Status: configuration.PROCESSING}, l))
+
+	NewRelay(cursor, l, NewBroadcast(cursor, l)).Dispatch(ctx)
+	require.Len(t, storage.events, 1)
+	assert.Equal(t, configuration.ORDERUPDATED, storage.events[0].Type)
+	assert.Equal(t, "test", storage.events[0].User)
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+)
+
+// Limit is how many requests per second a key gets on average and how many
+// it may make at once.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limits are the limits of the route groups by name.
+type Limits map[string]Limit
+
+// DefaultLimits are used for the route groups the configuration leaves out.
+func DefaultLimits() Limits {
+	return Limits{
+		configuration.RATELIMITAUTH:    {Rate: configuration.RATELIMITAUTHRATE, Burst: configuration.RATELIMITAUTHBURST},
+		configuration.RATELIMITORDERS:  {Rate: configuration.RATELIMITORDERSRATE, Burst: configuration.RATELIMITORDERSBURST},
+		configuration.RATELIMITBALANCE: {Rate: configuration.RATELIMITBALANCERATE, Burst: configuration.RATELIMITBALANCEBURST},
+	}
+}
+
+// ParseLimits reads limits written as "group:rate/burst,group:rate/burst"
+// over the defaults, the rate being in requests per second.
+func ParseLimits(spec string) (Limits, error) {
+	limits := DefaultLimits()
+	if strings.TrimSpace(spec) == "" {
+		return limits, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		group, value, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, errors.ErrInvalidRateLimits
+		}
+		if _, ok := limits[group]; !ok {
+			return nil, errors.ErrInvalidRateLimits
+		}
+		rate, burst, ok := strings.Cut(value, "/")
+		if !ok {
+			return nil, errors.ErrInvalidRateLimits
+		}
+		limit := Limit{}
+		var err error
+		if limit.Rate, err = strconv.ParseFloat(rate, 64); err != nil || limit.Rate <= 0 {
+			return nil, errors.ErrInvalidRateLimits
+		}
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 1 {
+			return nil, errors.ErrInvalidRateLimits
+		}
+		limits[group] = limit
+	}
+	return limits, nil
+}
+
+type bucket struct {
+	tokens float64
+	seen   time.Time
+}
+
+// Limiter is a token bucket per key. A bucket holds up to Burst tokens and
+// gains Rate of them every second, each request takes one.
+type Limiter struct {
+	limit     Limit
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	nextSweep time.Time
+}
+
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow takes a token from the bucket of key. When there is none it returns
+// how long until there is.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), seen: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.seen); elapsed > 0 {
+		b.tokens = math.Min(float64(l.limit.Burst), b.tokens+elapsed.Seconds()*l.limit.Rate)
+		b.seen = now
+	}
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets the buckets that have filled up again every
+// RATELIMITSWEEPINTERVAL seconds, they are no different from new ones.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	l.nextSweep = now.Add(configuration.RATELIMITSWEEPINTERVAL * time.Second)
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.seen).Seconds()*l.limit.Rate >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
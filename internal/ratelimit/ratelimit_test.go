@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/MlDenis/diploma-wannabe-v2/internal/configuration"
+	"github.com/MlDenis/diploma-wannabe-v2/internal/errors"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(Limit{Rate: 2, Burst: 3})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("test", now)
+		assert.True(t, ok)
+	}
+	ok, wait := limiter.Allow("test", now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// other keys have buckets of their own
+	ok, _ = limiter.Allow("other", now)
+	assert.True(t, ok)
+
+	ok, _ = limiter.Allow("test", now.Add(500*time.Millisecond))
+	assert.True(t, ok)
+	ok, _ = limiter.Allow("test", now.Add(500*time.Millisecond))
+	assert.False(t, ok)
+
+	// the bucket never holds more than the burst
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("test", later)
+		assert.True(t, ok)
+	}
+	ok, _ = limiter.Allow("test", later)
+	assert.False(t, ok)
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLimits(), limits)
+
+	limits, err = ParseLimits("orders:0.5/5, auth:3/6")
+	require.NoError(t, err)
+	assert.Equal(t, Limit{Rate: 0.5, Burst: 5}, limits[configuration.RATELIMITORDERS])
+	assert.Equal(t, Limit{Rate: 3, Burst: 6}, limits[configuration.RATELIMITAUTH])
+	assert.Equal(t, DefaultLimits()[configuration.RATELIMITBALANCE], limits[configuration.RATELIMITBALANCE])
+
+	for _, spec := range []string{"orders", "orders:1", "unknown:1/1", "orders:0/1", "orders:1/0", "orders:x/1"} {
+		_, err := ParseLimits(spec)
+		assert.Equal(t, errors.ErrInvalidRateLimits, err, spec)
+	}
+}
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS accrual_jobs;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE accrual_jobs ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP NOT NULL DEFAULT now();
\ No newline at end of file
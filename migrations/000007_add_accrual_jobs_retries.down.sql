@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS accrual_dead_jobs;
+ALTER TABLE accrual_jobs DROP COLUMN IF EXISTS last_error;
+ALTER TABLE accrual_jobs DROP COLUMN IF EXISTS attempts;
\ No newline at end of file
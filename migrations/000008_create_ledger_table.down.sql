@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ledger;
+DROP TYPE IF EXISTS LEDGER_KIND;
\ No newline at end of file
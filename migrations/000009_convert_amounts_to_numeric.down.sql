@@ -0,0 +1,8 @@
+ALTER TABLE ledger ALTER COLUMN amount TYPE FLOAT;
+
+ALTER TABLE withdrawal ALTER COLUMN _sum TYPE FLOAT;
+
+ALTER TABLE balances ALTER COLUMN _current TYPE FLOAT,
+                     ALTER COLUMN withdrawn TYPE FLOAT;
+
+ALTER TABLE orders ALTER COLUMN accrual TYPE FLOAT;
\ No newline at end of file
@@ -0,0 +1,8 @@
+ALTER TABLE orders ALTER COLUMN accrual TYPE NUMERIC(14, 2) USING round(accrual::numeric, 2);
+
+ALTER TABLE balances ALTER COLUMN _current TYPE NUMERIC(14, 2) USING round(_current::numeric, 2),
+                     ALTER COLUMN withdrawn TYPE NUMERIC(14, 2) USING round(withdrawn::numeric, 2);
+
+ALTER TABLE withdrawal ALTER COLUMN _sum TYPE NUMERIC(14, 2) USING round(_sum::numeric, 2);
+
+ALTER TABLE ledger ALTER COLUMN amount TYPE NUMERIC(14, 2) USING round(amount::numeric, 2);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE userinfo ALTER COLUMN _password TYPE VARCHAR(255);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TYPE IF EXISTS DELIVERY_STATUS;
+DROP TABLE IF EXISTS webhooks;
\ No newline at end of file
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+                                        id BIGSERIAL PRIMARY KEY,
+                                        username VARCHAR(50) NOT NULL,
+                                        url TEXT NOT NULL,
+                                        secret VARCHAR(128) NOT NULL,
+                                        created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_username_idx ON webhooks (username);
+
+CREATE TYPE DELIVERY_STATUS AS ENUM ('PENDING', 'DELIVERED', 'FAILED');
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+                                                  id BIGSERIAL PRIMARY KEY,
+                                                  webhook_id BIGINT NOT NULL REFERENCES webhooks (id),
+                                                  event_id VARCHAR(36) NOT NULL,
+                                                  event_type VARCHAR(50) NOT NULL,
+                                                  payload TEXT NOT NULL,
+                                                  status DELIVERY_STATUS NOT NULL DEFAULT 'PENDING',
+                                                  attempts INTEGER NOT NULL DEFAULT 0,
+                                                  response_code INTEGER,
+                                                  last_error TEXT,
+                                                  created_at TIMESTAMP NOT NULL,
+                                                  next_attempt_at TIMESTAMP NOT NULL,
+                                                  locked_until TIMESTAMP,
+                                                  delivered_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, id);
\ No newline at end of file
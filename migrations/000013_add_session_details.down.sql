@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS sessions_refresh_expires_at_idx;
+DROP INDEX IF EXISTS sessions_username_idx;
+
+ALTER TABLE _sessions DROP COLUMN IF EXISTS ip;
+ALTER TABLE _sessions DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE _sessions DROP COLUMN IF EXISTS created_at;
+ALTER TABLE _sessions DROP COLUMN IF EXISTS refresh_expires_at;
+ALTER TABLE _sessions DROP COLUMN IF EXISTS refresh_token;
+ALTER TABLE _sessions DROP COLUMN IF EXISTS id;
\ No newline at end of file
@@ -0,0 +1,13 @@
+ALTER TABLE _sessions ADD COLUMN id BIGSERIAL PRIMARY KEY;
+ALTER TABLE _sessions ADD COLUMN refresh_token VARCHAR (64) UNIQUE;
+ALTER TABLE _sessions ADD COLUMN refresh_expires_at TIMESTAMP;
+ALTER TABLE _sessions ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT now();
+ALTER TABLE _sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE _sessions ADD COLUMN ip VARCHAR (64) NOT NULL DEFAULT '';
+
+-- sessions without a refresh token end together with their access token
+UPDATE _sessions SET refresh_expires_at = expires_at;
+ALTER TABLE _sessions ALTER COLUMN refresh_expires_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS sessions_username_idx ON _sessions (username);
+CREATE INDEX IF NOT EXISTS sessions_refresh_expires_at_idx ON _sessions (refresh_expires_at);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE userinfo DROP COLUMN IF EXISTS _role;
+
+DROP TYPE IF EXISTS USER_ROLE;
\ No newline at end of file
@@ -0,0 +1,3 @@
+CREATE TYPE USER_ROLE AS ENUM ('user', 'support', 'admin');
+
+ALTER TABLE userinfo ADD COLUMN _role USER_ROLE NOT NULL DEFAULT 'user';
\ No newline at end of file
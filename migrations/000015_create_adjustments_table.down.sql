@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS adjustments;
+DROP TYPE IF EXISTS ADJUSTMENT_STATUS;
+DROP TYPE IF EXISTS ADJUSTMENT_REASON;
\ No newline at end of file
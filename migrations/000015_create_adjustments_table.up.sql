@@ -0,0 +1,18 @@
+CREATE TYPE ADJUSTMENT_REASON AS ENUM ('ACCRUAL_CORRECTION', 'GOODWILL', 'FRAUD_REVERSAL', 'OTHER');
+
+CREATE TYPE ADJUSTMENT_STATUS AS ENUM ('PENDING', 'APPLIED', 'REJECTED');
+
+CREATE TABLE IF NOT EXISTS adjustments (
+                                           id BIGSERIAL PRIMARY KEY,
+                                           username VARCHAR(50) NOT NULL,
+                                           amount NUMERIC(14, 2) NOT NULL,
+                                           reason_code ADJUSTMENT_REASON NOT NULL,
+                                           reason TEXT NOT NULL,
+                                           status ADJUSTMENT_STATUS NOT NULL DEFAULT 'PENDING',
+                                           requested_by VARCHAR(50) NOT NULL,
+                                           reviewed_by VARCHAR(50),
+                                           created_at TIMESTAMP NOT NULL,
+                                           reviewed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS adjustments_status_idx ON adjustments (status, id);
\ No newline at end of file
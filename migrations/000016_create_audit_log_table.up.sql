@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+                                         id BIGSERIAL PRIMARY KEY,
+                                         actor TEXT NOT NULL,
+                                         action VARCHAR(50) NOT NULL,
+                                         subject TEXT,
+                                         ip VARCHAR(64),
+                                         user_agent TEXT,
+                                         before_value TEXT,
+                                         after_value TEXT,
+                                         created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor, id);
+CREATE INDEX IF NOT EXISTS audit_log_subject_idx ON audit_log (subject, id);
+
+-- the log is append-only, rows can be neither changed nor removed
+CREATE OR REPLACE FUNCTION audit_log_immutable() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_immutable BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_immutable();
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+                                              scope VARCHAR(10) NOT NULL,
+                                              _key TEXT NOT NULL,
+                                              failures INTEGER NOT NULL,
+                                              last_failure_at TIMESTAMP NOT NULL,
+                                              locked_until TIMESTAMP,
+                                              PRIMARY KEY (scope, _key)
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_last_failure_idx ON login_attempts (last_failure_at);
\ No newline at end of file
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS orders (
+                                      username VARCHAR(50) NOT NULL,
+                                      _number VARCHAR(50) UNIQUE NOT NULL,
+                                      _status VARCHAR(20) NOT NULL CHECK (_status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+                                      accrual FLOAT DEFAULT 0.0,
+                                      uploaded_at TIMESTAMP
+);
\ No newline at end of file
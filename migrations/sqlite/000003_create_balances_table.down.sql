@@ -0,0 +1 @@
+DROP TABLE IF EXISTS balances;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS withdrawal;
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS accrual_jobs (
+                                            _number VARCHAR(50) UNIQUE NOT NULL,
+                                            username VARCHAR(50) NOT NULL,
+                                            created_at TIMESTAMP NOT NULL,
+                                            locked_until TIMESTAMP
+);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE accrual_jobs DROP COLUMN next_attempt_at;
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- SQLite only accepts constant defaults here, so existing jobs become due at once.
+ALTER TABLE accrual_jobs ADD COLUMN next_attempt_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS accrual_dead_jobs;
+ALTER TABLE accrual_jobs DROP COLUMN last_error;
+ALTER TABLE accrual_jobs DROP COLUMN attempts;
\ No newline at end of file
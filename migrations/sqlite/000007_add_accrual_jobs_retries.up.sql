@@ -0,0 +1,11 @@
+ALTER TABLE accrual_jobs ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE accrual_jobs ADD COLUMN last_error TEXT;
+
+CREATE TABLE IF NOT EXISTS accrual_dead_jobs (
+                                                 _number VARCHAR(50) UNIQUE NOT NULL,
+                                                 username VARCHAR(50) NOT NULL,
+                                                 created_at TIMESTAMP NOT NULL,
+                                                 attempts INTEGER NOT NULL,
+                                                 last_error TEXT,
+                                                 failed_at TIMESTAMP NOT NULL
+);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS ledger;
\ No newline at end of file
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS ledger (
+                                      id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                      username VARCHAR(50) NOT NULL,
+                                      kind VARCHAR(20) NOT NULL CHECK (kind IN ('CREDIT', 'DEBIT', 'ADJUSTMENT')),
+                                      amount FLOAT NOT NULL,
+                                      _order VARCHAR(200),
+                                      reason TEXT,
+                                      created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ledger_username_idx ON ledger (username, id);
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_credit_order_idx ON ledger (_order) WHERE kind = 'CREDIT';
+
+INSERT INTO ledger (username, kind, amount, reason, created_at)
+SELECT username, 'ADJUSTMENT', _current + withdrawn, 'opening balance', CURRENT_TIMESTAMP FROM balances WHERE _current + withdrawn <> 0;
+
+INSERT INTO ledger (username, kind, amount, reason, created_at)
+SELECT username, 'DEBIT', -withdrawn, 'opening withdrawals', CURRENT_TIMESTAMP FROM balances WHERE withdrawn <> 0;
\ No newline at end of file
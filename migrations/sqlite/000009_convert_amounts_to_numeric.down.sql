@@ -0,0 +1 @@
+-- Nothing to undo, rounded amounts are valid FLOAT values.
\ No newline at end of file
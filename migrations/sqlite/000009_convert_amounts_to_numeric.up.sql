@@ -0,0 +1,10 @@
+-- SQLite has no fixed-point column type, amounts stay FLOAT and are rounded
+-- to hundredths once here. Money rounds them again when scanning.
+UPDATE orders SET accrual = round(accrual, 2);
+
+UPDATE balances SET _current = round(_current, 2),
+                    withdrawn = round(withdrawn, 2);
+
+UPDATE withdrawal SET _sum = round(_sum, 2);
+
+UPDATE ledger SET amount = round(amount, 2);
\ No newline at end of file
@@ -0,0 +1 @@
+-- SQLite does not enforce VARCHAR lengths.
\ No newline at end of file
@@ -0,0 +1 @@
+-- SQLite does not enforce VARCHAR lengths, so bcrypt hashes already fit.
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS outbox (
+                                      id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                      username VARCHAR(50) NOT NULL,
+                                      event_type VARCHAR(50) NOT NULL,
+                                      payload TEXT NOT NULL,
+                                      created_at TIMESTAMP NOT NULL,
+                                      locked_until TIMESTAMP
+);
\ No newline at end of file
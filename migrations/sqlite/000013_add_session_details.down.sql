@@ -0,0 +1,10 @@
+CREATE TABLE _sessions_old (
+                               username VARCHAR (50) NOT NULL,
+                               token VARCHAR (100) UNIQUE NOT NULL,
+                               expires_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO _sessions_old SELECT username, token, expires_at FROM _sessions;
+
+DROP TABLE _sessions;
+ALTER TABLE _sessions_old RENAME TO _sessions;
\ No newline at end of file
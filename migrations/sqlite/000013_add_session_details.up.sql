@@ -0,0 +1,22 @@
+-- SQLite cannot add a primary key to an existing table, so it is rebuilt.
+CREATE TABLE _sessions_new (
+                               id INTEGER PRIMARY KEY AUTOINCREMENT,
+                               username VARCHAR (50) NOT NULL,
+                               token VARCHAR (100) UNIQUE NOT NULL,
+                               expires_at TIMESTAMP NOT NULL,
+                               refresh_token VARCHAR (64) UNIQUE,
+                               refresh_expires_at TIMESTAMP NOT NULL,
+                               created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                               user_agent TEXT NOT NULL DEFAULT '',
+                               ip VARCHAR (64) NOT NULL DEFAULT ''
+);
+
+-- sessions without a refresh token end together with their access token
+INSERT INTO _sessions_new (username, token, expires_at, refresh_expires_at)
+SELECT username, token, expires_at, expires_at FROM _sessions;
+
+DROP TABLE _sessions;
+ALTER TABLE _sessions_new RENAME TO _sessions;
+
+CREATE INDEX IF NOT EXISTS sessions_username_idx ON _sessions (username);
+CREATE INDEX IF NOT EXISTS sessions_refresh_expires_at_idx ON _sessions (refresh_expires_at);
\ No newline at end of file
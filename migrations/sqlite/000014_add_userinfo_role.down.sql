@@ -0,0 +1 @@
+ALTER TABLE userinfo DROP COLUMN _role;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE userinfo ADD COLUMN _role VARCHAR(20) NOT NULL DEFAULT 'user' CHECK (_role IN ('user', 'support', 'admin'));
\ No newline at end of file
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS adjustments (
+                                           id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                           username VARCHAR(50) NOT NULL,
+                                           amount FLOAT NOT NULL,
+                                           reason_code VARCHAR(20) NOT NULL CHECK (reason_code IN ('ACCRUAL_CORRECTION', 'GOODWILL', 'FRAUD_REVERSAL', 'OTHER')),
+                                           reason TEXT NOT NULL,
+                                           status VARCHAR(20) NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'APPLIED', 'REJECTED')),
+                                           requested_by VARCHAR(50) NOT NULL,
+                                           reviewed_by VARCHAR(50),
+                                           created_at TIMESTAMP NOT NULL,
+                                           reviewed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS adjustments_status_idx ON adjustments (status, id);
\ No newline at end of file
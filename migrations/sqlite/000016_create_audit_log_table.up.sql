@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+                                         id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                         actor TEXT NOT NULL,
+                                         action VARCHAR(50) NOT NULL,
+                                         subject TEXT,
+                                         ip VARCHAR(64),
+                                         user_agent TEXT,
+                                         before_value TEXT,
+                                         after_value TEXT,
+                                         created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor, id);
+CREATE INDEX IF NOT EXISTS audit_log_subject_idx ON audit_log (subject, id);
+
+-- the log is append-only, rows can be neither changed nor removed
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
\ No newline at end of file
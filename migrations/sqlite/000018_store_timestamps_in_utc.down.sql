@@ -0,0 +1,11 @@
+-- UTC timestamps are read back the same way, there is nothing to undo. The
+-- append-only guards of the audit log are made sure to be in place, as the
+-- up migration recreates them.
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
@@ -0,0 +1,36 @@
+-- Timestamps used to be written in the zone of the process and are compared
+-- as text, so they are rewritten in UTC with a fixed width. NULLs stay NULL.
+UPDATE _sessions SET expires_at=strftime('%Y-%m-%d %H:%M:%f', expires_at) || '000000+00:00',
+                     refresh_expires_at=strftime('%Y-%m-%d %H:%M:%f', refresh_expires_at) || '000000+00:00',
+                     created_at=strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00';
+UPDATE orders SET uploaded_at=strftime('%Y-%m-%d %H:%M:%f', uploaded_at) || '000000+00:00';
+UPDATE withdrawal SET processed_at=strftime('%Y-%m-%d %H:%M:%f', processed_at) || '000000+00:00';
+UPDATE accrual_jobs SET created_at=strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00',
+                        locked_until=strftime('%Y-%m-%d %H:%M:%f', locked_until) || '000000+00:00',
+                        next_attempt_at=strftime('%Y-%m-%d %H:%M:%f', next_attempt_at) || '000000+00:00';
+UPDATE accrual_dead_jobs SET created_at=strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00',
+                             failed_at=strftime('%Y-%m-%d %H:%M:%f', failed_at) || '000000+00:00';
+UPDATE ledger SET created_at=strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00';
+UPDATE webhooks SET created_at=strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00';
+UPDATE webhook_deliveries SET created_at=strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00',
+                              next_attempt_at=strftime('%Y-%m-%d %H:%M:%f', next_attempt_at) || '000000+00:00',
+                              locked_until=strftime('%Y-%m-%d %H:%M:%f', locked_until) || '000000+00:00',
+                              delivered_at=strftime('%Y-%m-%d %H:%M:%f', delivered_at) || '000000+00:00';
+UPDATE outbox SET created_at=strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00',
+                  locked_until=strftime('%Y-%m-%d %H:%M:%f', locked_until) || '000000+00:00';
+UPDATE adjustments SET created_at=strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00',
+                       reviewed_at=strftime('%Y-%m-%d %H:%M:%f', reviewed_at) || '000000+00:00';
+-- the audit log is append-only, its guards are lifted for the rewrite alone
+DROP TRIGGER IF EXISTS audit_log_no_update;
+DROP TRIGGER IF EXISTS audit_log_no_delete;
+UPDATE audit_log SET created_at=strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00';
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+UPDATE login_attempts SET last_failure_at=strftime('%Y-%m-%d %H:%M:%f', last_failure_at) || '000000+00:00',
+                          locked_until=strftime('%Y-%m-%d %H:%M:%f', locked_until) || '000000+00:00';